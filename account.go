@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+func accountCommand() *Client {
+	now := time.Now().Round(time.Millisecond)
+	if len(os.Args) < 2 || os.Args[1] != "move" {
+		exitf(exitUsage, "Usage: letmein account move -server URL [-name NEW]\n")
+	}
+	os.Args = os.Args[1:]
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	server, name := "", ""
+	flag.StringVar(&server, "server", server, "URL of the new server (required)")
+	flag.StringVar(&name, "name", name, "New account name (default: keep the current name)")
+	flag.Parse()
+	if server == "" {
+		exitf(exitUsage, "-server is required\n")
+	}
+	server = strings.TrimSuffix(server, "/")
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+
+	if name != "" && name != client.Name {
+		renameAccount(client, master, name)
+	}
+
+	// the new server has none of our profiles, so mark them all changed
+	// and forget the old sync cursor
+	for _, elt := range client.Profiles {
+		elt.ModifiedAt = &now
+	}
+	client.PreviousSyncAt = nil
+	clearPendingSync()
+	saveClient(client)
+
+	c := loadConfig()
+	c.Server = server
+	saveConfig(c)
+	config.Server = server
+	fmt.Printf("account %s now syncs with %s\n", client.Name, server)
+
+	return runSync(now, client, master, server, false, true)
+}
+
+// renameAccount rekeys everything sealed under the account name: custom
+// fields, codes, attachments, the trash, and the audit log.
+func renameAccount(client *Client, master, name string) {
+	if len(name) < minNameLength || len(name) > maxNameLength {
+		failf("Account name must be between %d and %d characters\n", minNameLength, maxNameLength)
+	}
+	if len(client.Shared) > 0 {
+		failf("Shared vaults are tied to the account name; leave them before renaming\n")
+	}
+	oldKey := secretKey(master, client.Name)
+	newKey := secretKey(master, name)
+	blobs := make(map[string]string)
+	for _, p := range client.Profiles {
+		if p.IsDeleted() {
+			continue
+		}
+		for field, sealed := range p.Fields {
+			plain, err := openSecret(oldKey, sealed)
+			if err != nil {
+				failf("Error decrypting field %s of %s: %v\n", field, p.Name, err)
+			}
+			p.Fields[field] = sealSecret(newKey, plain)
+			wipe(plain)
+		}
+		if p.Codes != "" {
+			sealCodes(newKey, p, openCodes(oldKey, p))
+		}
+		for _, a := range p.Attachments {
+			sealed, err := ioutil.ReadFile(blobPath(a.Blob))
+			if os.IsNotExist(err) {
+				failf("Attachment %s of %s is not downloaded; sync with the old server first\n", a.Name, p.Name)
+			} else if err != nil {
+				failf("Error reading attachment %s: %v\n", a.Name, err)
+			}
+			plain, err := openSecret(oldKey, string(sealed))
+			if err != nil {
+				failf("Error decrypting attachment %s: %v\n", a.Name, err)
+			}
+			blobs[a.Blob] = sealSecret(newKey, plain)
+			wipe(plain)
+		}
+	}
+
+	// the trash and the audit log are sealed under the account name too
+	trash := loadTrash()
+	for _, elt := range trash {
+		plain, err := openSecret(trashKey(master, client.Name), elt.Sealed)
+		if err != nil {
+			failf("Error decrypting trashed profile %s: %v\n", elt.UUID, err)
+		}
+		elt.Sealed = sealSecret(trashKey(master, name), plain)
+		wipe(plain)
+	}
+	audit := readAudit(auditKeyFor(master, client.Name))
+
+	// only rewrite attachments once all of them have been decrypted
+	for blob, sealed := range blobs {
+		if err := writeFileAtomic(blobPath(blob), []byte(sealed), 0600); err != nil {
+			failf("Error writing attachment %s: %v\n", blob, err)
+		}
+	}
+	if trash != nil {
+		writeTrash(trash)
+	}
+	if audit != nil {
+		writeAudit(auditKeyFor(master, name), audit)
+	}
+	if auditKey != nil {
+		auditKey = auditKeyFor(master, name)
+	}
+	client.Name = name
+	integrityKey = integrityKeyFor(master, name)
+
+	// the new name is claimed afresh on its first sync
+	client.AccountSecret = ""
+
+	// older journal entries hold fields sealed under the old name
+	saveJournal(nil)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+func ageKeyProfile() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	public := false
+	output := ""
+	flag.BoolVar(&public, "public", public, "Print only the recipient (public key)")
+	flag.StringVar(&output, "o", output, "Write the identity to this file")
+	flag.Parse()
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+
+	// get search string
+	args := flag.Args()
+	if len(args) != 1 {
+		failf("Must provide exactly one search term to find profile for the key\n")
+	}
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	p := chooseProfile(client.Search(query), args[0], "derive a key from")
+	if p.Scheme == schemeStored {
+		failf("Cannot derive keys from a stored-password profile\n")
+	}
+
+	// derive the X25519 identity
+	scalar := p.DeriveKey(master, "age-x25519", curve25519.ScalarSize)
+	point, err := curve25519.X25519(scalar, curve25519.Basepoint)
+	if err != nil {
+		failf("Error computing public key: %v\n", err)
+	}
+	recipient := bech32Encode("age", point)
+	identity := bech32Encode("AGE-SECRET-KEY-", scalar)
+	wipe(scalar)
+
+	if public {
+		fmt.Println(recipient)
+		return client
+	}
+	text := fmt.Sprintf("# letmein profile: %s\n# public key: %s\n%s\n", p.Name, recipient, identity)
+	if output == "" {
+		fmt.Print(text)
+		return client
+	}
+	if err := ioutil.WriteFile(output, []byte(text), 0600); err != nil {
+		failf("Error writing %s: %v\n", output, err)
+	}
+	fmt.Printf("wrote %s\npublic key: %s\n", output, recipient)
+
+	return client
+}
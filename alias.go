@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// aliasTagLength is the number of base32 characters added to an alias,
+// about 25 bits: enough that aliases for different sites do not collide
+// or reveal one another.
+const aliasTagLength = 5
+
+// aliasBase is set by -alias, or defaults to the alias config setting.
+var aliasBase string
+
+func registerAliasFlag() {
+	flag.StringVar(&aliasBase, "alias", "", "Derive a site-specific username from this address, e.g. me@example.com (or set alias)")
+}
+
+// aliasKey derives the key for alias tags. Only someone with the master
+// password can link one alias to another.
+func aliasKey(master, account string) []byte {
+	return secretKey(master, "alias\t"+account)
+}
+
+// deriveAlias adds a tag derived from the profile name to base: for an
+// email address, me@example.com becomes me+k3f9q@example.com (plus
+// addressing, which most mail providers deliver to me@example.com);
+// for a plain username, me becomes me-k3f9q.
+func deriveAlias(key []byte, base, name string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.ToLower(name)))
+	tag := base32.StdEncoding.EncodeToString(mac.Sum(nil))
+	tag = strings.ToLower(tag[:aliasTagLength])
+	if at := strings.LastIndex(base, "@"); at >= 0 {
+		return base[:at] + "+" + tag + base[at:]
+	}
+	return base + "-" + tag
+}
+
+func checkAliasBase(base string) error {
+	if strings.ContainsAny(base, " \t+") {
+		return fmt.Errorf("alias %q cannot contain spaces or +", base)
+	}
+	if at := strings.LastIndex(base, "@"); at == 0 || at == len(base)-1 {
+		return fmt.Errorf("alias %q is not a valid address", base)
+	}
+	return nil
+}
+
+// applyAlias sets p's username to its alias when -alias was given, or
+// when useDefault is set and the alias config setting applies.
+func applyAlias(p *Profile, master, account string, useDefault bool) {
+	base := aliasBase
+	given, username := false, false
+	flag.Visit(func(f *flag.Flag) {
+		given = given || f.Name == "alias"
+		username = username || f.Name == "username"
+	})
+	if !given {
+		if !useDefault || config.Alias == "" || p.Username != "" {
+			return
+		}
+		base = config.Alias
+	}
+	if given && username {
+		failf("-alias and -username cannot be used together\n")
+	}
+	if err := checkAliasBase(base); err != nil {
+		failf("%v\n", err)
+	}
+	p.Username = deriveAlias(aliasKey(master, account), base, p.Name)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math"
+	"math/big"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// letmein analyze checks how evenly a profile's passwords use its
+// character set. It generates passwords for random masters and reports
+// how often each character comes up against how often it should, along
+// with the exact bias of the mapping where that can be worked out.
+//
+// For scrypt, scrypt-v2, and scrypt-v3 profiles it skips scrypt by
+// default and maps random bytes instead: scrypt output cannot be told
+// apart from random bytes, so only the mapping is left to measure.
+// -full runs the real scheme, which is slow but covers every scheme.
+
+const defaultAnalyzeSamples = 10000
+
+func analyzeCommand() *Client {
+	// gather options
+	registerVaultFlag()
+	query := new(Query)
+	registerQueryFlags(query)
+	samples := defaultAnalyzeSamples
+	flag.IntVar(&samples, "samples", samples, "Number of passwords to generate for each profile")
+	full := false
+	flag.BoolVar(&full, "full", full, "Run the real scheme with random masters instead of mapping random bytes")
+	flag.Parse()
+	if samples < 1 {
+		exitf(exitUsage, "-samples must be at least 1\n")
+	}
+
+	args := flag.Args()
+	if len(args) != 1 {
+		failf("Must provide exactly one search term to find profiles to analyze\n")
+	}
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	client := loadMatches(query)
+	matches := client.Search(query)
+	if len(matches) == 0 {
+		failf("No profiles match %q\n", args[0])
+	}
+
+	// random masters must not crowd the cache
+	config.CacheSeconds = 0
+	for i, p := range matches {
+		if i > 0 {
+			fmt.Println()
+		}
+		analyzeProfile(p, samples, full)
+	}
+	return client
+}
+
+func analyzeProfile(p *Profile, samples int, full bool) {
+	chars := []rune(p.GetCharacterSet())
+	k, length := len(chars), p.Length
+	fmt.Printf("name:        %s\n", p.Name)
+	fmt.Printf("scheme:      %s\n", p.Scheme)
+	fmt.Printf("characters:  %d, length %d, %.1f bits\n", k, length, float64(length)*math.Log2(float64(k)))
+
+	v1 := strings.HasPrefix(p.Scheme, "scrypt(")
+	v2 := isSampledScheme(p.Scheme)
+	switch {
+	case v1:
+		fmt.Printf("mapping:     one big fraction in base %d, in variable time\n", k)
+		fmt.Printf("bias:        %s\n", fractionBias(k, length))
+	case v2:
+		fmt.Printf("mapping:     rejection sampling, in constant time\n")
+		fmt.Printf("bias:        none\n")
+	default:
+		fmt.Printf("mapping:     the scheme's own\n")
+		full = true
+	}
+
+	passwords, err := simulatePasswords(p, chars, samples, full)
+	if err != nil {
+		failf("%v\n", err)
+	}
+	printDistribution(chars, passwords)
+}
+
+// fractionBias describes the exact bias of encodeCharset for a profile.
+// The length*8 derived bits pick one of 256^length values, and each
+// password gets either Q or Q+1 of them, where Q = 256^length / k^length.
+func fractionBias(k, length int) string {
+	space := new(big.Int).Lsh(big.NewInt(1), uint(8*length))
+	passwords := new(big.Int).Exp(big.NewInt(int64(k)), big.NewInt(int64(length)), nil)
+	q, r := new(big.Int).QuoRem(space, passwords, new(big.Int))
+	switch {
+	case r.Sign() == 0:
+		return "none"
+	case q.Sign() == 0:
+		return "some passwords can never come up"
+	}
+	return fmt.Sprintf("the likeliest passwords beat the rarest by 1 part in 2^%d", q.BitLen()-1)
+}
+
+// simulatePasswords generates passwords for random masters, or with
+// full unset, maps random bytes as the profile's scheme would.
+func simulatePasswords(p *Profile, chars []rune, samples int, full bool) ([]string, error) {
+	v2 := isSampledScheme(p.Scheme)
+	passwords := make([]string, samples)
+	errs := make([]error, samples)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				passwords[i], errs[i] = simulatePassword(p, chars, full, v2)
+			}
+		}()
+	}
+	for i := 0; i < samples; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return passwords, nil
+}
+
+func simulatePassword(p *Profile, chars []rune, full, v2 bool) (string, error) {
+	if full {
+		master := make([]byte, 16)
+		if _, err := rand.Read(master); err != nil {
+			return "", err
+		}
+		return p.generate(hex.EncodeToString(master))
+	}
+	for n := p.Length + scryptV2Headroom; ; n *= 2 {
+		stream := make([]byte, n)
+		if _, err := rand.Read(stream); err != nil {
+			return "", err
+		}
+		if !v2 {
+			return string(encodeCharset(stream[:p.Length], chars, p.Length)), nil
+		}
+		if password, ok := sampleCharset(stream, chars, p.Length); ok {
+			return string(password), nil
+		}
+	}
+}
+
+// printDistribution reports how often each character came up: a
+// chi-square test against an even spread, and the characters furthest
+// from it.
+func printDistribution(chars []rune, passwords []string) {
+	counts := make(map[rune]int)
+	total := 0
+	for _, password := range passwords {
+		for _, r := range password {
+			counts[r]++
+			total++
+		}
+	}
+	if total == 0 {
+		fmt.Printf("samples:     %d empty passwords\n", len(passwords))
+		return
+	}
+	expected := float64(total) / float64(len(chars))
+	chi := 0.0
+	for _, r := range chars {
+		d := float64(counts[r]) - expected
+		chi += d * d / expected
+	}
+	other := total
+	for _, r := range chars {
+		other -= counts[r]
+	}
+
+	sorted := append([]rune(nil), chars...)
+	sort.SliceStable(sorted, func(i, j int) bool { return counts[sorted[i]] < counts[sorted[j]] })
+	least, most := sorted[0], sorted[len(sorted)-1]
+
+	fmt.Printf("samples:     %d passwords, %d characters, %.1f of each expected\n", len(passwords), total, expected)
+	if len(chars) > 1 {
+		fmt.Printf("chi-square:  %.1f with %d degrees of freedom, p = %.3f\n", chi, len(chars)-1, chiSquareP(chi, len(chars)-1))
+	}
+	fmt.Printf("least:       %q %d times (%.3f of expected)\n", least, counts[least], float64(counts[least])/expected)
+	fmt.Printf("most:        %q %d times (%.3f of expected)\n", most, counts[most], float64(counts[most])/expected)
+	if other > 0 {
+		fmt.Printf("outside:     %d characters not in the character set\n", other)
+	}
+}
+
+// chiSquareP is the chance of a chi-square statistic of at least x with
+// df degrees of freedom, by the Wilson-Hilferty approximation. A small
+// value means the characters are not evenly spread.
+func chiSquareP(x float64, df int) float64 {
+	d := float64(df)
+	z := (math.Cbrt(x/d) - (1 - 2/(9*d))) / math.Sqrt(2/(9*d))
+	return math.Erfc(z/math.Sqrt2) / 2
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAPIListen = "127.0.0.1:7086"
+
+// apiServer answers local integration requests on behalf of one user.
+type apiServer struct {
+	master string
+	token  string
+
+	// mu serializes changes to the store
+	mu sync.Mutex
+}
+
+func apiTokenFilename() string {
+	return filepath.Join(filepath.Dir(filename), "api-token")
+}
+
+func serveAPI() {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	listen := defaultAPIListen
+	flag.StringVar(&listen, "listen", listen, "Loopback address to listen on")
+	flag.Parse()
+
+	// refuse to expose the API beyond this machine
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		failf("Invalid listen address %s: %v\n", listen, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		failf("Listen address must be a loopback address, not %s\n", host)
+	}
+
+	master = getAndVerifyMaster(master)
+	getClient(now, master)
+	unlockStore()
+
+	// generate a fresh access token for this session
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		failf("Error generating API token: %v\n", err)
+	}
+	s := &apiServer{master: master, token: hex.EncodeToString(b)}
+	if err := writeFileAtomic(apiTokenFilename(), []byte(s.token+"\n"), 0600); err != nil {
+		failf("Error writing %s: %v\n", apiTokenFilename(), err)
+	}
+	defer os.Remove(apiTokenFilename())
+	go retryPendingSyncs(master)
+
+	fmt.Fprintf(os.Stderr, "listening on http://%s/api/v1/\n", listen)
+	fmt.Fprintf(os.Stderr, "API token written to %s\n", apiTokenFilename())
+	if err := http.ListenAndServe(listen, s); err != nil {
+		failf("Error serving API: %v\n", err)
+	}
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// every request must carry the session token
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(s.token)) != 1 {
+		apiError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/api/v1/profiles" && r.Method == "GET":
+		s.listProfiles(w, r)
+	case path == "/api/v1/profiles" && r.Method == "POST":
+		s.createProfile(w, r)
+	case strings.HasPrefix(path, "/api/v1/profiles/") && strings.HasSuffix(path, "/password") && r.Method == "GET":
+		uuid := strings.TrimSuffix(strings.TrimPrefix(path, "/api/v1/profiles/"), "/password")
+		s.generate(w, r, uuid)
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *apiServer) listProfiles(w http.ResponseWriter, r *http.Request) {
+	query := &Query{Term: r.URL.Query().Get("q"), URL: r.URL.Query().Get("url")}
+	client, err := readMatches(query)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	apiReply(w, http.StatusOK, client.Search(query))
+}
+
+func (s *apiServer) generate(w http.ResponseWriter, r *http.Request, uuid string) {
+	client, err := readClient()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, elt := range client.Profiles {
+		if !elt.IsDeleted() && elt.UUID == uuid {
+			apiReply(w, http.StatusOK, map[string]string{
+				"uuid":     elt.UUID,
+				"username": elt.Username,
+				"password": elt.Generate(s.master),
+			})
+			return
+		}
+	}
+	apiError(w, http.StatusNotFound, "no profile with uuid "+uuid)
+}
+
+// apiProfile copies the settings an API caller may choose onto a new
+// profile. Everything else, from the UUID and scheme to sealed data and
+// signatures, is set by letmein.
+func apiProfile(in *Profile) *Profile {
+	return &Profile{
+		Name:        in.Name,
+		Username:    in.Username,
+		URL:         in.URL,
+		Folder:      in.Folder,
+		Generation:  in.Generation,
+		Length:      in.Length,
+		Lower:       in.Lower,
+		Upper:       in.Upper,
+		Digits:      in.Digits,
+		Punctuation: in.Punctuation,
+		Spaces:      in.Spaces,
+		Latin1:      in.Latin1,
+		Include:     in.Include,
+		Exclude:     in.Exclude,
+		AutoType:    in.AutoType,
+		Favorite:    in.Favorite,
+	}
+}
+
+func (s *apiServer) createProfile(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().Round(time.Millisecond)
+
+	// start from the configured defaults, like the create command
+	in := &Profile{
+		Length:      config.Length,
+		Lower:       config.Lower,
+		Upper:       config.Upper,
+		Digits:      config.Digits,
+		Punctuation: config.Punctuation,
+		Spaces:      config.Spaces,
+	}
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if in.Secret != "" || len(in.Fields) > 0 || len(in.Attachments) > 0 || in.Codes != "" || in.Vault != "" || in.Signature != "" {
+		apiError(w, http.StatusBadRequest, "the API can only create generated-password profiles")
+		return
+	}
+	if readOnly {
+		apiError(w, http.StatusForbidden, "read-only mode")
+		return
+	}
+	p := apiProfile(in)
+	p.UUID = newUUID()
+	p.Scheme = defaultScheme()
+	p.ModifiedAt = &now
+	if err := p.Validate(); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid profile: "+err.Error())
+		return
+	}
+	if err := p.checkMaxLength(0); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid profile: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lockStore()
+	defer unlockStore()
+	client, err := readClient()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if matches := client.Matches(p.Name); len(matches) != 0 {
+		apiError(w, http.StatusConflict, "profile matches existing profile "+matches[0].Name)
+		return
+	}
+	client.Profiles = append(client.Profiles, p)
+	recordOp("create", nil, []string{p.UUID})
+	saveClient(client)
+	infof("created profile: %s", p)
+
+	apiReply(w, http.StatusCreated, p)
+}
+
+func apiReply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(body); err != nil {
+		infof("error writing API response: %v", err)
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	apiReply(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// archiveCommand returns the archive or unarchive command. Archived
+// profiles are left out of searches unless -archived is given, but are
+// otherwise kept and synced like any other profile.
+func archiveCommand(archive bool) func() *Client {
+	verb := "archive"
+	if !archive {
+		verb = "unarchive"
+	}
+	return func() *Client {
+		now := time.Now().Round(time.Millisecond)
+
+		// gather options
+		registerVaultFlag()
+		query := new(Query)
+		registerQueryFlags(query)
+		registerInteractiveFlag()
+		flag.Parse()
+
+		args := flag.Args()
+		if len(args) != 1 {
+			exitf(exitUsage, "Must provide exactly one search term to find the profile to %s\n", verb)
+		}
+		client := loadClient()
+
+		// unarchive looks among the archived profiles
+		query.Term = args[0]
+		query.Archived = !archive
+		if err := query.Compile(); err != nil {
+			failf("Invalid search: %v\n", err)
+		}
+		q := chooseProfile(client.Search(query), args[0], verb)
+		before := *q
+		q.Archived = archive
+		q.ModifiedAt = &now
+
+		fmt.Printf("profile %sd: %s\n", verb, q)
+		stampChanges(&before, q, now)
+		recordOp(verb, []*Profile{&before}, nil)
+
+		return client
+	}
+}
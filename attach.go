@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxAttachmentSize limits attachments to small secrets like backup codes.
+const maxAttachmentSize = 1 << 20
+
+// Attachment describes a small encrypted file stored with a profile. The
+// encrypted contents live in a sidecar directory, keyed by Blob.
+type Attachment struct {
+	Name string `json:"name"`
+	Blob string `json:"blob"`
+	Size int    `json:"size"`
+}
+
+func attachmentDir() string {
+	return filepath.Join(filepath.Dir(filename), "attachments")
+}
+
+// blobPath finds the file for a blob, refusing IDs that could name a
+// file outside the attachment directory.
+func blobPath(blob string) string {
+	if !validBlobID(blob) {
+		failf("Invalid attachment blob ID %q\n", blob)
+	}
+	return filepath.Join(attachmentDir(), blob)
+}
+
+func newBlobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("error generating blob ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// validBlobID checks that a blob ID is safe to use as a file name.
+func validBlobID(blob string) bool {
+	if len(blob) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(blob)
+	return err == nil
+}
+
+func findAttachment(p *Profile, name string) int {
+	for i, elt := range p.Attachments {
+		if elt.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func attachCommand() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	force := false
+	flag.BoolVar(&force, "force", force, "Overwrite existing files when getting an attachment")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n\n")
+		fmt.Fprintf(os.Stderr, "    letmein attach add <query> <file>\n")
+		fmt.Fprintf(os.Stderr, "    letmein attach get <query> <file>\n")
+		fmt.Fprintf(os.Stderr, "    letmein attach rm <query> <name>\n")
+		fmt.Fprintf(os.Stderr, "    letmein attach ls <query>\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 || (args[0] == "ls" && len(args) != 2) || (args[0] != "ls" && len(args) != 3) {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+	key := secretKey(master, client.Name)
+
+	query.Term = args[1]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	p := chooseProfile(client.Search(query), args[1], "attach to")
+	before := *p
+	before.Attachments = append([]*Attachment{}, p.Attachments...)
+
+	switch args[0] {
+	case "ls":
+		for _, elt := range p.Attachments {
+			fmt.Printf("    %s (%d bytes)\n", elt.Name, elt.Size)
+		}
+		return nil
+
+	case "add":
+		path := args[2]
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			failf("Error reading %s: %v\n", path, err)
+		}
+		if len(raw) > maxAttachmentSize {
+			failf("Attachments must be no larger than %d bytes\n", maxAttachmentSize)
+		}
+		a := &Attachment{Name: filepath.Base(path), Blob: newBlobID(), Size: len(raw)}
+		if err := os.MkdirAll(attachmentDir(), 0700); err != nil {
+			failf("Error creating %s: %v\n", attachmentDir(), err)
+		}
+		if err := writeFileAtomic(blobPath(a.Blob), []byte(sealSecret(key, raw)), 0600); err != nil {
+			failf("Error writing attachment: %v\n", err)
+		}
+		if i := findAttachment(p, a.Name); i >= 0 {
+			p.Attachments[i] = a
+		} else {
+			p.Attachments = append(p.Attachments, a)
+		}
+		fmt.Printf("attached %s to %s\n", a.Name, p.Name)
+
+	case "get":
+		path := args[2]
+		i := findAttachment(p, filepath.Base(path))
+		if i < 0 {
+			failf("No attachment named %s\n", filepath.Base(path))
+		}
+		plain := readAttachment(p.Attachments[i], key)
+		if _, err := os.Stat(path); err == nil && !force {
+			failf("%s already exists; use -force to overwrite\n", path)
+		}
+		if err := ioutil.WriteFile(path, plain, 0600); err != nil {
+			failf("Error writing %s: %v\n", path, err)
+		}
+		fmt.Printf("wrote %s\n", path)
+		return nil
+
+	case "rm":
+		// the blob is left in place so the removal can be undone;
+		// gc deletes blobs that are no longer referenced
+		i := findAttachment(p, args[2])
+		if i < 0 {
+			failf("No attachment named %s\n", args[2])
+		}
+		p.Attachments = append(p.Attachments[:i], p.Attachments[i+1:]...)
+		fmt.Printf("removed %s from %s\n", args[2], p.Name)
+
+	default:
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if len(p.Attachments) == 0 {
+		p.Attachments = nil
+	}
+	p.ModifiedAt = &now
+	if err := p.Validate(); err != nil {
+		failf("updated profile is invalid, canceling: %v\n", err)
+	}
+	stampChanges(&before, p, now)
+	recordOp("attach", []*Profile{&before}, nil)
+	return client
+}
+
+func readAttachment(a *Attachment, key []byte) []byte {
+	sealed, err := ioutil.ReadFile(blobPath(a.Blob))
+	if os.IsNotExist(err) {
+		failf("Attachment %s has not been downloaded yet; run sync\n", a.Name)
+	} else if err != nil {
+		failf("Error reading attachment %s: %v\n", a.Name, err)
+	}
+	plain, err := openSecret(key, string(sealed))
+	if err != nil {
+		failf("Error decrypting attachment %s: %v\n", a.Name, err)
+	}
+	return plain
+}
+
+// blobURL is where the server keeps an account's encrypted blobs.
+func blobURL(server, account, blob string) string {
+	return server + "/api/v1noauth/blob/" + account + "/" + blob
+}
+
+// uploadAttachments sends the encrypted blobs for the given profiles to
+// the server. The server only ever sees the encrypted contents.
+func uploadAttachments(server string, client *Client, secret string, profiles []*Profile) {
+	for _, p := range profiles {
+		for _, a := range p.Attachments {
+			sealed, err := ioutil.ReadFile(blobPath(a.Blob))
+			if os.IsNotExist(err) {
+				// we never had it locally, so it is already on the server
+				continue
+			} else if err != nil {
+				failf("Error reading attachment %s: %v\n", a.Name, err)
+			}
+			resp, err := sendRequest("PUT", blobURL(server, client.Name, a.Blob), "application/octet-stream", sealed, secretHeader(secret))
+			if err != nil {
+				failf("Error uploading attachment %s: %v\n", a.Name, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				failf("Server returned an error status uploading attachment %s: %s\n", a.Name, resp.Status)
+			}
+		}
+	}
+}
+
+// downloadAttachments fetches any blobs referenced by profiles that are
+// missing locally.
+func downloadAttachments(server string, client *Client, secret string) {
+	for _, p := range client.Profiles {
+		for _, a := range p.Attachments {
+			if _, err := os.Stat(blobPath(a.Blob)); err == nil {
+				continue
+			}
+			resp, err := sendRequest("GET", blobURL(server, client.Name, a.Blob), "", nil, secretHeader(secret))
+			if err != nil {
+				failf("Error downloading attachment %s: %v\n", a.Name, err)
+			}
+			sealed, err := ioutil.ReadAll(io.LimitReader(resp.Body, 2*maxAttachmentSize))
+			resp.Body.Close()
+			if err != nil {
+				failf("Error downloading attachment %s: %v\n", a.Name, err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				failf("Server returned an error status downloading attachment %s: %s\n", a.Name, resp.Status)
+			}
+			if !strings.HasPrefix(string(sealed), sealedPrefix) {
+				failf("Server returned an invalid attachment %s\n", a.Name)
+			}
+			if err := os.MkdirAll(attachmentDir(), 0700); err != nil {
+				failf("Error creating %s: %v\n", attachmentDir(), err)
+			}
+			if err := writeFileAtomic(blobPath(a.Blob), sealed, 0600); err != nil {
+				failf("Error writing attachment %s: %v\n", a.Name, err)
+			}
+		}
+	}
+}
+
+// collectBlobs removes attachment blobs no longer referenced by any
+// profile or by the undo journal. It returns the number removed.
+func collectBlobs(client *Client) int {
+	used := make(map[string]bool)
+	mark := func(profiles []*Profile) {
+		for _, p := range profiles {
+			for _, a := range p.Attachments {
+				used[a.Blob] = true
+			}
+		}
+	}
+	mark(client.Profiles)
+	for _, entry := range loadJournal() {
+		mark(entry.Before)
+		if entry.Snapshot != nil {
+			mark(entry.Snapshot.Profiles)
+		}
+	}
+
+	infos, err := ioutil.ReadDir(attachmentDir())
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, info := range infos {
+		if validBlobID(info.Name()) && !used[info.Name()] {
+			if err := os.Remove(blobPath(info.Name())); err == nil {
+				removed++
+			}
+		}
+	}
+	return removed
+}
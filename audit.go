@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one password generation, for the opt-in audit log.
+type AuditEntry struct {
+	At      time.Time `json:"at"`
+	Command string    `json:"command"`
+	UUID    string    `json:"uuid"`
+	Name    string    `json:"name"`
+}
+
+var (
+	// auditKey encrypts audit log entries. It is set once the master
+	// password is known, and only if audit_log is on.
+	auditKey     []byte
+	auditCommand string
+	auditMu      sync.Mutex
+)
+
+func auditFilename() string {
+	return filename + ".audit"
+}
+
+func auditKeyFor(master, account string) []byte {
+	return secretKey(master, "audit\t"+account)
+}
+
+// startAudit turns on logging of password generation for this run.
+func startAudit(master, account string) {
+	if config.AuditLog {
+		auditKey = auditKeyFor(master, account)
+	}
+}
+
+// auditGeneration appends an entry to the audit log. Each entry is
+// sealed separately, so the log can be appended to without reading it.
+func auditGeneration(p *Profile) {
+	if auditKey == nil || p.UUID == "" {
+		return
+	}
+	entry := &AuditEntry{At: time.Now().Round(time.Second), Command: auditCommand, UUID: p.UUID, Name: p.Name}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		failf("Error encoding audit entry: %v\n", err)
+	}
+	line := sealSecret(auditKey, raw) + "\n"
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	fp, err := os.OpenFile(auditFilename(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		failf("Error opening audit log: %v\n", err)
+	}
+	defer fp.Close()
+	if _, err := fp.WriteString(line); err != nil {
+		failf("Error writing audit log: %v\n", err)
+	}
+}
+
+func readAudit(key []byte) []*AuditEntry {
+	fp, err := os.Open(auditFilename())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		failf("Error opening audit log: %v\n", err)
+	}
+	defer fp.Close()
+	var entries []*AuditEntry
+	scanner := bufio.NewScanner(fp)
+	for n := 1; scanner.Scan(); n++ {
+		raw, err := openSecret(key, scanner.Text())
+		if err != nil {
+			failf("Error decrypting audit log line %d: %v\n", n, err)
+		}
+		entry := new(AuditEntry)
+		if err := json.Unmarshal(raw, entry); err != nil {
+			failf("Error parsing audit log line %d: %v\n", n, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		failf("Error reading audit log: %v\n", err)
+	}
+	return entries
+}
+
+// writeAudit replaces the audit log with entries sealed under key.
+func writeAudit(key []byte, entries []*AuditEntry) {
+	var buf strings.Builder
+	for _, entry := range entries {
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			failf("Error encoding audit entry: %v\n", err)
+		}
+		buf.WriteString(sealSecret(key, raw) + "\n")
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if err := writeFileAtomic(auditFilename(), []byte(buf.String()), 0600); err != nil {
+		failf("Error writing audit log: %v\n", err)
+	}
+}
+
+// parseAuditTime accepts a date or a date and time in local time.
+func parseAuditTime(s string) time.Time {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t
+		}
+	}
+	exitf(exitUsage, "Invalid time %q; use YYYY-MM-DD or \"YYYY-MM-DD HH:MM\"\n", s)
+	return time.Time{}
+}
+
+func auditLogCommand() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	since, until := "", ""
+	flag.StringVar(&since, "since", since, "Only show entries from this date or time on")
+	flag.StringVar(&until, "until", until, "Only show entries before this date or time")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) > 1 {
+		exitf(exitUsage, "Must provide no more than one search term\n")
+	}
+	var from, to time.Time
+	if since != "" {
+		from = parseAuditTime(since)
+	}
+	if until != "" {
+		to = parseAuditTime(until)
+	}
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+	if !config.AuditLog {
+		infof("audit_log is off; no new entries are being recorded")
+	}
+
+	entries := readAudit(auditKeyFor(master, client.Name))
+	if jsonOutput {
+		dump(entries)
+		return nil
+	}
+	for _, elt := range entries {
+		if (!from.IsZero() && elt.At.Before(from)) || (!to.IsZero() && !elt.At.Before(to)) {
+			continue
+		}
+		if len(args) == 1 && !containsFold(elt.Name, args[0]) && !strings.EqualFold(elt.UUID, args[0]) {
+			continue
+		}
+		fmt.Printf("%s  %-8s  %s\n", elt.At.Local().Format("2006-01-02 15:04:05"), elt.Command, elt.Name)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// defaultAutoType is the keystroke sequence used when a profile has none.
+const defaultAutoType = "{USERNAME}{TAB}{PASSWORD}{ENTER}"
+
+// autoTypeStep is one action in an auto-type sequence: either literal
+// text to type or a named key or placeholder.
+type autoTypeStep struct {
+	text string
+	key  string
+}
+
+// parseAutoType splits a sequence like "{USERNAME}{TAB}{PASSWORD}{ENTER}"
+// into steps. Recognized placeholders are {USERNAME}, {PASSWORD}, {TAB},
+// {ENTER}, and {DELAY} (a one-second pause); anything else is typed literally.
+func parseAutoType(seq string) ([]autoTypeStep, error) {
+	var steps []autoTypeStep
+	for seq != "" {
+		open := strings.IndexByte(seq, '{')
+		if open < 0 {
+			steps = append(steps, autoTypeStep{text: seq})
+			break
+		}
+		if open > 0 {
+			steps = append(steps, autoTypeStep{text: seq[:open]})
+		}
+		end := strings.IndexByte(seq[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in auto-type sequence")
+		}
+		key := strings.ToUpper(seq[open+1 : open+end])
+		switch key {
+		case "USERNAME", "PASSWORD", "TAB", "ENTER", "DELAY":
+		default:
+			return nil, fmt.Errorf("unknown auto-type placeholder {%s}", key)
+		}
+		steps = append(steps, autoTypeStep{key: key})
+		seq = seq[open+end+1:]
+	}
+	return steps, nil
+}
+
+// autoType types a profile's sequence into the focused window.
+func autoType(p *Profile, password string) error {
+	seq := p.AutoType
+	if seq == "" {
+		seq = config.AutoType
+	}
+	steps, err := parseAutoType(seq)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		switch step.key {
+		case "":
+			err = typeText(step.text)
+		case "USERNAME":
+			err = typeText(p.Username)
+		case "PASSWORD":
+			err = typeText(password)
+		case "TAB":
+			err = typeKey("Tab")
+		case "ENTER":
+			err = typeKey("Return")
+		case "DELAY":
+			time.Sleep(time.Second)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func typeProfile() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	delay := 0
+	sequence := ""
+	flag.IntVar(&delay, "delay", delay, "Seconds to wait before typing")
+	flag.StringVar(&sequence, "sequence", sequence, "Override the auto-type sequence, e.g. {PASSWORD}{ENTER}")
+	flag.Parse()
+	master = getAndVerifyMaster(master)
+
+	// get search string
+	args := flag.Args()
+	if len(args) != 1 {
+		failf("Must provide exactly one search term to find profile to type\n")
+	}
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	client := getMatches(now, master, query)
+	p := chooseProfile(client.Search(query), args[0], "type")
+	password := p.Generate(master)
+	recordUse(p)
+	if sequence != "" {
+		copied := *p
+		copied.AutoType = sequence
+		p = &copied
+	}
+
+	// when run from a terminal, the terminal has focus; switch back to
+	// the window the user was in before
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		if err := focusPreviousWindow(); err != nil {
+			failf("Error switching windows: %v\n", err)
+		}
+	}
+	time.Sleep(time.Duration(delay) * time.Second)
+
+	if err := autoType(p, password); err != nil {
+		failf("Error typing: %v\n", err)
+	}
+
+	return client
+}
@@ -0,0 +1,97 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ydotool works with raw Linux key codes
+var ydotoolKeys = map[string]string{
+	"Tab":    "15",
+	"Return": "28",
+}
+
+// keyInjector picks the key-injection tool for the current display.
+func keyInjector() (string, error) {
+	switch {
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		if _, err := exec.LookPath("wtype"); err == nil {
+			return "wtype", nil
+		}
+		if _, err := exec.LookPath("ydotool"); err == nil {
+			return "ydotool", nil
+		}
+		return "", fmt.Errorf("typing on Wayland requires wtype or ydotool")
+	case os.Getenv("DISPLAY") != "":
+		return "xdotool", nil
+	}
+	return "", fmt.Errorf("no supported display found for typing")
+}
+
+// typeText sends text as keystrokes to the focused window.
+func typeText(text string) error {
+	tool, err := keyInjector()
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	switch tool {
+	case "wtype":
+		cmd = exec.Command("wtype", "-")
+	case "ydotool":
+		cmd = exec.Command("ydotool", "type", "--file", "-")
+	default:
+		cmd = exec.Command("xdotool", "type", "--clearmodifiers", "--file", "-")
+	}
+	cmd.Stdin = strings.NewReader(text)
+	return runInjector(cmd)
+}
+
+// typeKey presses and releases a named key (X11 keysym names).
+func typeKey(key string) error {
+	tool, err := keyInjector()
+	if err != nil {
+		return err
+	}
+	switch tool {
+	case "wtype":
+		return runInjector(exec.Command("wtype", "-k", key))
+	case "ydotool":
+		code := ydotoolKeys[key]
+		return runInjector(exec.Command("ydotool", "key", code+":1", code+":0"))
+	default:
+		return runInjector(exec.Command("xdotool", "key", "--clearmodifiers", key))
+	}
+}
+
+// focusPreviousWindow switches to the most recently used window.
+func focusPreviousWindow() error {
+	tool, err := keyInjector()
+	if err != nil {
+		return err
+	}
+	switch tool {
+	case "xdotool":
+		err = runInjector(exec.Command("xdotool", "key", "--clearmodifiers", "alt+Tab"))
+	case "wtype":
+		err = runInjector(exec.Command("wtype", "-M", "alt", "-k", "Tab", "-m", "alt"))
+	default:
+		return fmt.Errorf("%s cannot switch windows; use -delay and switch manually", tool)
+	}
+	// give the window manager a moment to move focus
+	time.Sleep(300 * time.Millisecond)
+	return err
+}
+
+func runInjector(cmd *exec.Cmd) error {
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s: %v", cmd.Args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	inputKeyboard    = 1
+	keyeventfKeyUp   = 0x0002
+	keyeventfUnicode = 0x0004
+
+	vkTab    = 0x09
+	vkReturn = 0x0D
+	vkMenu   = 0x12
+)
+
+var procSendInput = windows.NewLazySystemDLL("user32.dll").NewProc("SendInput")
+
+// keyboardInput mirrors the Win32 INPUT structure for keyboard events,
+// padded to the size of its largest union member.
+type keyboardInput struct {
+	typ uint32
+	ki  struct {
+		vk    uint16
+		scan  uint16
+		flags uint32
+		time  uint32
+		extra uintptr
+	}
+	_ [8]byte
+}
+
+func sendInput(inputs []keyboardInput) error {
+	if len(inputs) == 0 {
+		return nil
+	}
+	n, _, err := procSendInput.Call(uintptr(len(inputs)), uintptr(unsafe.Pointer(&inputs[0])), unsafe.Sizeof(inputs[0]))
+	if int(n) != len(inputs) {
+		return fmt.Errorf("SendInput: %v", err)
+	}
+	return nil
+}
+
+func keyEvent(vk uint16, up bool) keyboardInput {
+	var in keyboardInput
+	in.typ = inputKeyboard
+	in.ki.vk = vk
+	if up {
+		in.ki.flags = keyeventfKeyUp
+	}
+	return in
+}
+
+// typeText sends text as Unicode keystrokes to the focused window.
+func typeText(text string) error {
+	var inputs []keyboardInput
+	for _, unit := range utf16.Encode([]rune(text)) {
+		for _, flags := range []uint32{keyeventfUnicode, keyeventfUnicode | keyeventfKeyUp} {
+			var in keyboardInput
+			in.typ = inputKeyboard
+			in.ki.scan = unit
+			in.ki.flags = flags
+			inputs = append(inputs, in)
+		}
+	}
+	return sendInput(inputs)
+}
+
+// typeKey presses and releases a named key.
+func typeKey(key string) error {
+	var vk uint16
+	switch key {
+	case "Tab":
+		vk = vkTab
+	case "Return":
+		vk = vkReturn
+	default:
+		return fmt.Errorf("unknown key %s", key)
+	}
+	return sendInput([]keyboardInput{keyEvent(vk, false), keyEvent(vk, true)})
+}
+
+// focusPreviousWindow switches to the most recently used window.
+func focusPreviousWindow() error {
+	err := sendInput([]keyboardInput{
+		keyEvent(vkMenu, false),
+		keyEvent(vkTab, false),
+		keyEvent(vkTab, true),
+		keyEvent(vkMenu, true),
+	})
+	time.Sleep(300 * time.Millisecond)
+	return err
+}
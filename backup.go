@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const backupTimeFormat = "20060102T150405.000Z"
+
+func backupDir() string {
+	return filepath.Join(filepath.Dir(filename), "backups")
+}
+
+// listBackups returns the timestamps of all backups, oldest first.
+func listBackups() []string {
+	infos, err := ioutil.ReadDir(backupDir())
+	if err != nil && !os.IsNotExist(err) {
+		failf("Error reading %s: %v\n", backupDir(), err)
+	}
+	var stamps []string
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() && strings.HasSuffix(name, ".json") {
+			stamps = append(stamps, strings.TrimSuffix(name, ".json"))
+		}
+	}
+	sort.Strings(stamps)
+	return stamps
+}
+
+// backupStore snapshots the current store (if any) and prunes old backups
+// beyond the configured retention count.
+func backupStore() {
+	if config.Backups <= 0 {
+		return
+	}
+	raw, err := openStore().Export()
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		failf("Error reading %s for backup: %v\n", filename, err)
+	}
+	if err := os.MkdirAll(backupDir(), 0700); err != nil {
+		failf("Error creating backup directory %s: %v\n", backupDir(), err)
+	}
+	stamp := time.Now().UTC().Format(backupTimeFormat)
+	path := filepath.Join(backupDir(), stamp+".json")
+	if err := writeFileAtomic(path, raw, 0600); err != nil {
+		failf("Error writing backup %s: %v\n", path, err)
+	}
+
+	// prune the oldest backups
+	stamps := listBackups()
+	for len(stamps) > config.Backups {
+		old := filepath.Join(backupDir(), stamps[0]+".json")
+		if err := os.Remove(old); err != nil {
+			failf("Error removing old backup %s: %v\n", old, err)
+		}
+		stamps = stamps[1:]
+	}
+}
+
+func restoreBackup() {
+	registerVaultFlag()
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: letmein restore [timestamp]\n\nWith no timestamp, lists available backups.\n")
+	}
+	flag.Parse()
+	lockStore()
+	stamps := listBackups()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		if len(stamps) == 0 {
+			fmt.Printf("No backups found in %s\n", backupDir())
+		}
+		for _, stamp := range stamps {
+			fmt.Printf("    %s\n", stamp)
+		}
+		return
+	} else if len(args) > 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	// find the requested backup
+	stamp := args[0]
+	found := false
+	for _, elt := range stamps {
+		if elt == stamp {
+			found = true
+		}
+	}
+	if !found {
+		failf("No backup found with timestamp %s\n", stamp)
+	}
+	path := filepath.Join(backupDir(), stamp+".json")
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		failf("Error reading backup %s: %v\n", path, err)
+	}
+	if _, err := decodeClient(raw); err != nil {
+		failf("Backup %s is not a valid profile store: %v\n", path, err)
+	}
+
+	// back up the current state so the restore can itself be undone
+	backupStore()
+	if err := openStore().Import(raw); err != nil {
+		failf("Error writing %s: %v\n", filename, err)
+	}
+	fmt.Printf("restored profile data from backup %s\n", stamp)
+}
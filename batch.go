@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// batchRequest is one line of input to the batch command.
+type batchRequest struct {
+	Op      string                     `json:"op"`
+	UUID    string                     `json:"uuid,omitempty"`
+	Query   string                     `json:"query,omitempty"`
+	Profile *Profile                   `json:"profile,omitempty"`
+	Set     map[string]json.RawMessage `json:"set,omitempty"`
+}
+
+// batchResult is one line of output from the batch command.
+type batchResult struct {
+	Line     int      `json:"line"`
+	OK       bool     `json:"ok"`
+	Error    string   `json:"error,omitempty"`
+	Profile  *Profile `json:"profile,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// batchState tracks changes across a batch so they are saved and
+// journaled once at the end.
+type batchState struct {
+	client  *Client
+	master  string
+	now     time.Time
+	before  []*Profile
+	created []string
+	touched map[string]bool
+}
+
+func batchCommand() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	stopOnError := false
+	flag.BoolVar(&stopOnError, "stop-on-error", stopOnError, "Stop at the first failed command (earlier changes are still saved)")
+	flag.Parse()
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+
+	s := &batchState{client: client, master: master, now: now, touched: make(map[string]bool)}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), maxNativeMessage)
+	failed := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		res := new(batchResult)
+		req := new(batchRequest)
+		if err := json.Unmarshal(scanner.Bytes(), req); err != nil {
+			res.Error = "invalid JSON: " + err.Error()
+		} else if err := s.run(req, res); err != nil {
+			res.Error = err.Error()
+		} else {
+			res.OK = true
+		}
+		res.Line = line
+		if err := encoder.Encode(res); err != nil {
+			failf("Error writing output: %v\n", err)
+		}
+		if !res.OK {
+			failed++
+			if stopOnError {
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		failf("Error reading input: %v\n", err)
+	}
+	debugf("batch finished: %d changed, %d created, %d failed", len(s.before), len(s.created), failed)
+
+	if len(s.before) > 0 || len(s.created) > 0 {
+		recordOp("batch", s.before, s.created)
+		saveClient(client)
+	}
+	if failed > 0 {
+		exitf(exitError, "%d commands failed\n", failed)
+	}
+	return nil
+}
+
+func (s *batchState) run(req *batchRequest, res *batchResult) error {
+	switch req.Op {
+	case "create":
+		if req.Profile == nil {
+			return fmt.Errorf("create needs a profile")
+		}
+		p := &Profile{
+			Length:      config.Length,
+			Lower:       config.Lower,
+			Upper:       config.Upper,
+			Digits:      config.Digits,
+			Punctuation: config.Punctuation,
+			Spaces:      config.Spaces,
+		}
+		raw, _ := json.Marshal(req.Profile)
+		if err := json.Unmarshal(raw, p); err != nil {
+			return err
+		}
+		if p.Secret != "" || len(p.Fields) > 0 || len(p.Attachments) > 0 || p.Codes != "" || p.Scheme == schemeStored {
+			return fmt.Errorf("batch can only create generated-password profiles")
+		}
+		if p.Signature != "" || p.Vault != "" {
+			return fmt.Errorf("batch cannot set a signature or a shared vault")
+		}
+		p.UUID = newUUID()
+		p.Scheme = defaultScheme()
+		p.FieldTimes, p.SyncHashes, p.DeletedAt = nil, nil, nil
+		p.ModifiedAt = &s.now
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("invalid profile: %v", err)
+		}
+		if err := p.checkMaxLength(0); err != nil {
+			return fmt.Errorf("invalid profile: %v", err)
+		}
+		if matches := s.client.Matches(p.Name); len(matches) != 0 {
+			return fmt.Errorf("profile matches existing profile %s", matches[0].Name)
+		}
+		s.client.Profiles = append(s.client.Profiles, p)
+		s.created = append(s.created, p.UUID)
+		s.touched[p.UUID] = true
+		res.Profile, res.Password = p, p.Generate(s.master)
+
+	case "update":
+		p, err := s.find(req)
+		if err != nil {
+			return err
+		}
+		if len(req.Set) == 0 {
+			return fmt.Errorf("update needs settings in set")
+		}
+		after := *p
+		for key, raw := range req.Set {
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				value = string(raw)
+			}
+			if p.Scheme == schemeStored && derivationFlags[key] {
+				return fmt.Errorf("%s does not apply to stored-password profiles", key)
+			}
+			if key == "name" {
+				after.Name = value
+			} else if err := applySetting(&after, key, value); err != nil {
+				return err
+			}
+		}
+		after.ModifiedAt = &s.now
+		if err := after.Validate(); err != nil {
+			return fmt.Errorf("updated profile is invalid: %v", err)
+		}
+		if err := after.checkMaxLength(p.Length); err != nil {
+			return fmt.Errorf("updated profile is invalid: %v", err)
+		}
+		old := *p
+		if !s.touched[p.UUID] {
+			s.before = append(s.before, &old)
+			s.touched[p.UUID] = true
+		}
+		*p = after
+		stampChanges(&old, p, s.now)
+		res.Profile, res.Password = p, p.Generate(s.master)
+
+	case "generate":
+		p, err := s.find(req)
+		if err != nil {
+			return err
+		}
+		res.Profile, res.Password = p, p.Generate(s.master)
+
+	default:
+		return fmt.Errorf("unknown op %q: must be create, update, or generate", req.Op)
+	}
+	return nil
+}
+
+// find resolves a request's uuid or query to exactly one profile.
+func (s *batchState) find(req *batchRequest) (*Profile, error) {
+	if req.UUID != "" {
+		for _, p := range s.client.Profiles {
+			if !p.IsDeleted() && p.UUID == req.UUID {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("no profile with uuid %s", req.UUID)
+	}
+	if req.Query == "" {
+		return nil, fmt.Errorf("%s needs a uuid or query", req.Op)
+	}
+	matches := s.client.Search(&Query{Term: req.Query})
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no profile matches %q", req.Query)
+	}
+	if p := uniqueMatch(matches, req.Query); p != nil {
+		return p, nil
+	}
+	return nil, fmt.Errorf("%q matches %d profiles", req.Query, len(matches))
+}
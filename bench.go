@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dchest/scrypt"
+)
+
+const defaultBenchTarget = 250 * time.Millisecond
+
+// timeScrypt measures a single derivation with the given parameters.
+func timeScrypt(n, r, p int) time.Duration {
+	start := time.Now()
+	if _, err := scrypt.Key([]byte("letmein bench"), []byte("salt"), n, r, p, 32); err != nil {
+		failf("scrypt error: %v\n", err)
+	}
+	return time.Since(start)
+}
+
+func benchCommand() {
+	target := defaultBenchTarget
+	r, p := config.ScryptR, config.ScryptP
+	write := false
+	flag.DurationVar(&target, "target", target, "Desired time for one password derivation")
+	flag.IntVar(&r, "r", r, "scrypt block size")
+	flag.IntVar(&p, "p", p, "scrypt parallelism")
+	flag.BoolVar(&write, "write", write, "Save the recommended parameters to the config file")
+	flag.Parse()
+	if err := checkScryptParams(minScryptN, r, p); err != nil {
+		failf("%v\n", err)
+	}
+
+	// double N until a derivation takes longer than the target
+	best := 0
+	fmt.Printf("%10s %4s %4s %8s %10s\n", "N", "r", "p", "memory", "time")
+	for n := minScryptN; checkScryptParams(n, r, p) == nil; n *= 2 {
+		elapsed := timeScrypt(n, r, p)
+		fmt.Printf("%10d %4d %4d %6dMiB %10v\n", n, r, p, 128*n*r>>20, elapsed.Round(time.Millisecond))
+		if elapsed > target {
+			break
+		}
+		best = n
+	}
+	if best == 0 {
+		fmt.Fprintf(os.Stderr, "even the minimum cost takes longer than %v; keeping N=%d\n", target, minScryptN)
+		best = minScryptN
+	}
+	fmt.Printf("recommended for %v: scrypt_n = %d, scrypt_r = %d, scrypt_p = %d\n", target, best, r, p)
+
+	if write {
+		c := loadConfig()
+		c.ScryptN, c.ScryptR, c.ScryptP = best, r, p
+		saveConfig(c)
+		fmt.Printf("saved to %s; new profiles will use these parameters\n", configFilename)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// bootstrapFromServer fills a brand-new client with every profile the
+// server holds for the account, and checks that the master password is
+// the one they were made with before anything is written.
+func bootstrapFromServer(now time.Time, client *Client, master, server string) *Client {
+	if server == "" {
+		exitf(exitUsage, "-from-server needs a server; use -server or set server\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		failf("Error creating directory for %s: %v\n", filename, err)
+	}
+	client = runSync(now, client, master, server, true, true)
+
+	// a wrong master password looks like an unknown account to the
+	// server, so an empty answer is treated as a mismatch
+	live := 0
+	for _, p := range client.Profiles {
+		if !p.IsDeleted() {
+			live++
+		}
+	}
+	if live == 0 {
+		os.Remove(historyFilename())
+		exitf(exitBadMaster, "%s has no profiles for account %s with this master password\n", server, client.Name)
+	}
+	if err := checkSealedProfiles(client, master); err != nil {
+		os.Remove(historyFilename())
+		exitf(exitBadMaster, "Master password does not match the account on %s: %v\n", server, err)
+	}
+	fmt.Printf("downloaded %d profiles for account %s from %s\n", live, client.Name, server)
+	return client
+}
+
+// checkSealedProfiles opens the first value sealed with the master
+// password it can find, which fails if the password is wrong. Stored
+// passwords have a key of their own; fields and codes use the
+// account's.
+func checkSealedProfiles(client *Client, master string) error {
+	password := []byte(master)
+	defer wipe(password)
+	for _, p := range client.Profiles {
+		if p.Vault != "" {
+			continue
+		}
+		var key []byte
+		sealed := p.Secret
+		if sealed != "" {
+			key = storedKey(password, p)
+		} else {
+			key = secretKey(master, client.Name)
+			sealed = p.Codes
+		}
+		for _, value := range p.Fields {
+			if sealed == "" {
+				sealed = value
+			}
+		}
+		if sealed == "" {
+			continue
+		}
+		if _, err := openSecret(key, sealed); err != nil {
+			return fmt.Errorf("cannot decrypt profile %s", p.Name)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+// TestCheckSealedProfiles checks that a store downloaded by init
+// -from-server opens with the right master password whichever kind of
+// sealed value comes first, and not with a wrong one.
+func TestCheckSealedProfiles(t *testing.T) {
+	const master, account = "correct horse battery staple", "bob"
+	stored := &Profile{Scheme: schemeStored, UUID: "00000000-0000-4000-8000-000000000001", Name: "wifi", Length: 8}
+	stored.Secret = sealSecret(storedKey([]byte(master), stored), []byte("hunter22"))
+	fields := &Profile{Scheme: schemeScrypt, UUID: "00000000-0000-4000-8000-000000000002", Name: "bank", Length: 16, Lower: true}
+	fields.Fields = map[string]string{"pin": sealSecret(secretKey(master, account), []byte("1234"))}
+
+	for _, p := range []*Profile{stored, fields} {
+		client := &Client{Name: account, Profiles: []*Profile{p}}
+		if err := checkSealedProfiles(client, master); err != nil {
+			t.Errorf("%s with the right master: %v", p.Name, err)
+		}
+		if err := checkSealedProfiles(client, "wrong"); err == nil {
+			t.Errorf("%s opened with the wrong master", p.Name)
+		}
+	}
+}
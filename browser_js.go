@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"syscall/js"
+)
+
+// The browser build (GOOS=js GOARCH=wasm) runs no command. It puts a
+// letmein object on the page for extensions and web pages, so they
+// generate passwords with the same code as the command line:
+//
+//	letmein.version
+//	letmein.generate(profile, master)     resolves to the password
+//	letmein.checkMaster(store, master)    resolves to true or false
+//
+// A profile or store is its JSON, as a string or a parsed object. The
+// calls return promises, since scrypt takes a moment.
+
+func browserMain() {
+	config = defaultConfig()
+	api := js.Global().Get("Object").New()
+	api.Set("version", version)
+	api.Set("generate", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return jsPromise(args, 2, func() (interface{}, error) {
+			p := new(Profile)
+			if err := json.Unmarshal([]byte(jsJSON(args[0])), p); err != nil {
+				return nil, fmt.Errorf("parsing profile: %v", err)
+			}
+			if err := p.Validate(); err != nil {
+				return nil, err
+			}
+			return p.generate(args[1].String())
+		})
+	}))
+	api.Set("checkMaster", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return jsPromise(args, 2, func() (interface{}, error) {
+			client, err := decodeClient([]byte(jsJSON(args[0])))
+			if err != nil {
+				return nil, fmt.Errorf("parsing store: %v", err)
+			}
+			return client.checkMaster(args[1].String()) == nil, nil
+		})
+	}))
+	js.Global().Set("letmein", api)
+
+	// stay alive to answer calls
+	select {}
+}
+
+// jsJSON accepts either JSON text or a value to turn into JSON.
+func jsJSON(v js.Value) string {
+	if v.Type() == js.TypeString {
+		return v.String()
+	}
+	return js.Global().Get("JSON").Call("stringify", v).String()
+}
+
+// jsPromise runs f off the JavaScript event loop and settles a promise
+// with its result.
+func jsPromise(args []js.Value, want int, f func() (interface{}, error)) js.Value {
+	var handler js.Func
+	handler = js.FuncOf(func(this js.Value, settle []js.Value) interface{} {
+		resolve, reject := settle[0], settle[1]
+		go func() {
+			defer handler.Release()
+			if len(args) != want {
+				reject.Invoke(js.Global().Get("Error").New(fmt.Sprintf("expected %d arguments, got %d", want, len(args))))
+				return
+			}
+			result, err := f()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(result)
+		}()
+		return nil
+	})
+	return js.Global().Get("Promise").New(handler)
+}
@@ -0,0 +1,6 @@
+//go:build !js
+
+package main
+
+// browserMain is only used by the browser build; see browser_js.go.
+func browserMain() {}
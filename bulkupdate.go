@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setFlag collects -set key=value options in order.
+type setFlag [][2]string
+
+func (f *setFlag) String() string {
+	var pairs []string
+	for _, kv := range *f {
+		pairs = append(pairs, kv[0]+"="+kv[1])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f *setFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 1 {
+		return fmt.Errorf("setting must be given as key=value")
+	}
+	key := strings.TrimSpace(s[:i])
+	if err := applySetting(new(Profile), key, s[i+1:]); err != nil {
+		return err
+	}
+	*f = append(*f, [2]string{key, s[i+1:]})
+	return nil
+}
+
+// applySetting changes one profile setting, named as on the command line.
+func applySetting(p *Profile, key, value string) error {
+	var err error
+	boolValue := func(dst *bool) {
+		*dst, err = strconv.ParseBool(value)
+	}
+	switch key {
+	case "username":
+		p.Username = value
+	case "url":
+		p.URL = value
+	case "folder":
+		p.Folder = value
+	case "generation":
+		p.Generation, err = strconv.Atoi(value)
+	case "length":
+		p.Length, err = strconv.Atoi(value)
+	case "lower":
+		boolValue(&p.Lower)
+	case "upper":
+		boolValue(&p.Upper)
+	case "digits":
+		boolValue(&p.Digits)
+	case "punctuation":
+		boolValue(&p.Punctuation)
+	case "spaces":
+		boolValue(&p.Spaces)
+	case "latin1":
+		boolValue(&p.Latin1)
+	case "include":
+		p.Include = value
+	case "exclude":
+		p.Exclude = value
+	case "no-ambiguous":
+		var on bool
+		if boolValue(&on); on {
+			p.Exclude += ambiguousChars
+		}
+	case "autotype":
+		p.AutoType = value
+	case "archived":
+		boolValue(&p.Archived)
+	case "fav":
+		boolValue(&p.Favorite)
+	default:
+		return fmt.Errorf("cannot set %q; use username, url, folder, generation, length, lower, upper, digits, punctuation, spaces, latin1, include, exclude, no-ambiguous, autotype, archived, or fav", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %q", key, value)
+	}
+	return nil
+}
+
+// applyTemplate applies a template's settings from the config file.
+func applyTemplate(p *Profile, settings map[string]interface{}) error {
+	for key, value := range settings {
+		if err := applySetting(p, key, fmt.Sprint(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bulkUpdate() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	registerInteractiveFlag()
+	query := new(Query)
+	registerQueryFlags(query)
+	var settings setFlag
+	flag.Var(&settings, "set", "Setting to change, as key=value (repeatable)")
+	flag.StringVar(&query.URL, "url", "", "Only change profiles for this site")
+	flag.StringVar(&query.Folder, "folder", "", "Only change profiles in this folder")
+	all, force := false, false
+	flag.BoolVar(&all, "all", all, "Change every profile when no search term is given")
+	registerForceFlag(&force)
+	flag.Parse()
+	args := flag.Args()
+	if len(settings) == 0 {
+		exitf(exitUsage, "Give at least one -set key=value\n")
+	}
+	if len(args) > 1 {
+		exitf(exitUsage, "Must provide no more than one search term\n")
+	}
+	if len(args) == 0 && query.URL == "" && query.Folder == "" && !all {
+		exitf(exitUsage, "Give a search term, -url, or -folder, or -all to change every profile\n")
+	}
+	if len(args) == 1 {
+		query.Term = args[0]
+	}
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+
+	// work out every change before touching anything
+	type change struct {
+		p     *Profile
+		after Profile
+	}
+	var changes []change
+	for _, p := range client.Search(query) {
+		after := *p
+		skip := false
+		for _, kv := range settings {
+			if p.Scheme == schemeStored && derivationFlags[kv[0]] {
+				skip = true
+				break
+			}
+			applySetting(&after, kv[0], kv[1])
+		}
+		if skip {
+			fmt.Printf("skipping stored-password profile %s\n", p.Name)
+			continue
+		}
+		if err := after.Validate(); err != nil {
+			failf("%s would be invalid, canceling: %v\n", p.Name, err)
+		}
+		if err := after.checkMaxLength(p.Length); err != nil {
+			failf("%s would be invalid, canceling: %v\n", p.Name, err)
+		}
+		if sameProfile(p, &after) {
+			continue
+		}
+		changes = append(changes, change{p, after})
+	}
+	if len(changes) == 0 {
+		fmt.Printf("No profiles need changing\n")
+		return nil
+	}
+
+	// preview and confirm
+	for _, c := range changes {
+		fmt.Printf("    %s\n -> %s\n", c.p, &c.after)
+	}
+	confirm(force, "Change %d profiles? (passwords may change)", len(changes))
+
+	var before []*Profile
+	for _, c := range changes {
+		old := *c.p
+		before = append(before, &old)
+		*c.p = c.after
+		c.p.ModifiedAt = &now
+		stampChanges(&old, c.p, now)
+	}
+	recordOp("bulk-update", before, nil)
+	fmt.Printf("updated %d profiles\n", len(changes))
+
+	return client
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheSeconds = 300
+	maxCacheEntries     = 256
+)
+
+// kdfCache remembers recent scrypt results so long-running commands
+// (serve-api, native-host) and lists with repeated profiles do not pay
+// for the same derivation twice. Entries are keyed by a hash of the
+// inputs, so the master password itself is never held as a map key,
+// and nothing in the cache is ever written to disk.
+type kdfCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+type kdfEntry struct {
+	key     [sha256.Size]byte
+	value   []byte
+	expires time.Time
+}
+
+var derivedCache = &kdfCache{
+	order:   list.New(),
+	entries: make(map[[sha256.Size]byte]*list.Element),
+}
+
+func kdfCacheKey(password []byte, salt string, n, r, p, length int) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(password)
+	h.Write([]byte{0})
+	for _, s := range []string{salt, strconv.Itoa(n), strconv.Itoa(r), strconv.Itoa(p), strconv.Itoa(length)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// get returns a copy of a cached result, dropping it if it has expired.
+func (c *kdfCache) get(key [sha256.Size]byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elt, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elt.Value.(*kdfEntry)
+	if time.Now().After(e.expires) {
+		c.remove(elt)
+		return nil, false
+	}
+	c.order.MoveToFront(elt)
+	return append([]byte(nil), e.value...), true
+}
+
+// put stores a result, evicting the least recently used entry when full.
+func (c *kdfCache) put(key [sha256.Size]byte, value []byte) {
+	if config.CacheSeconds <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(time.Duration(config.CacheSeconds) * time.Second)
+	if elt, ok := c.entries[key]; ok {
+		elt.Value.(*kdfEntry).expires = expires
+		c.order.MoveToFront(elt)
+		return
+	}
+	value = append([]byte(nil), value...)
+	lockMemory(value)
+	c.entries[key] = c.order.PushFront(&kdfEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > maxCacheEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// clear wipes and drops every entry.
+func (c *kdfCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *kdfCache) remove(elt *list.Element) {
+	e := c.order.Remove(elt).(*kdfEntry)
+	unlockMemory(e.value)
+	delete(c.entries, e.key)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+)
+
+// Servers identify accounts by name, so two people who pick the same
+// name on a shared server would otherwise see each other's profiles.
+// Every request also carries an account secret in a header: the server
+// records it on the account's first sync and refuses the name to anyone
+// who cannot present it. It is never put in a request body, so it stays
+// out of traced requests. The secret is derived from the master password,
+// so each device of the account arrives at the same one, and is kept
+// in the store once established.
+
+// accountSecretHeader carries the secret.
+const accountSecretHeader = "X-Letmein-Account-Secret"
+
+// deriveAccountSecret is the secret an account starts with.
+func deriveAccountSecret(master, account string) string {
+	key := secretKey(master, "account secret\t"+account)
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// accountSecret returns the secret to present to the server, deriving
+// and keeping one the first time.
+func accountSecret(client *Client, master string) string {
+	key := secretKey(master, client.Name)
+	if client.AccountSecret != "" {
+		plain, err := openSecret(key, client.AccountSecret)
+		if err != nil {
+			failf("Error decrypting the account secret: %v\n", err)
+		}
+		return string(plain)
+	}
+	secret := deriveAccountSecret(master, client.Name)
+	client.AccountSecret = sealSecret(key, []byte(secret))
+	return secret
+}
+
+// secretHeader returns the headers that present the secret.
+func secretHeader(secret string) http.Header {
+	header := make(http.Header)
+	header.Set(accountSecretHeader, secret)
+	return header
+}
+
+// checkClaimed exits with a clear message if the server refused the
+// request because the account name belongs to someone else.
+func checkClaimed(resp *http.Response, server, account string) {
+	if resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		exitf(exitNetwork, "The account name %s on %s belongs to someone else (or to a different master password).\n"+
+			"Choose another name with letmein account move -server %s -name NEW\n", account, server, server)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// copyToClipboard places text on the clipboard. If timeout is positive,
+// it then waits and clears the clipboard, unless something else has been
+// copied in the meantime.
+func copyToClipboard(text string, timeout time.Duration) {
+	if err := writeClipboard(text); err != nil {
+		failf("Error copying to clipboard: %v\n", err)
+	}
+	if timeout <= 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Copied to clipboard; clearing in %v\n", timeout)
+	time.Sleep(timeout)
+	if current, err := readClipboard(); err == nil && current == text {
+		writeClipboard("")
+	}
+}
+
+func clipboardTimeout() time.Duration {
+	return time.Duration(config.ClipboardTimeout) * time.Second
+}
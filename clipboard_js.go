@@ -0,0 +1,12 @@
+package main
+
+import "fmt"
+
+// A browser page has its own clipboard API; the CLI's is not used.
+func writeClipboard(text string) error {
+	return fmt.Errorf("no clipboard in the browser build")
+}
+
+func readClipboard() (string, error) {
+	return "", fmt.Errorf("no clipboard in the browser build")
+}
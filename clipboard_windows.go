@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/atotto/clipboard"
+	"golang.org/x/sys/windows"
+)
+
+// On Windows the clipboard is written directly, so a copied password
+// can be marked to stay out of clipboard history (Win+V), cloud
+// clipboard sync, and clipboard monitors.
+
+var (
+	user32 = windows.NewLazySystemDLL("user32.dll")
+	kernel = windows.NewLazySystemDLL("kernel32.dll")
+
+	procOpenClipboard           = user32.NewProc("OpenClipboard")
+	procCloseClipboard          = user32.NewProc("CloseClipboard")
+	procEmptyClipboard          = user32.NewProc("EmptyClipboard")
+	procSetClipboardData        = user32.NewProc("SetClipboardData")
+	procRegisterClipboardFormat = user32.NewProc("RegisterClipboardFormatW")
+	procGlobalAlloc             = kernel.NewProc("GlobalAlloc")
+	procGlobalFree              = kernel.NewProc("GlobalFree")
+	procGlobalLock              = kernel.NewProc("GlobalLock")
+	procGlobalUnlock            = kernel.NewProc("GlobalUnlock")
+	procRtlMoveMemory           = kernel.NewProc("RtlMoveMemory")
+)
+
+const (
+	cfUnicodeText = 13
+	gmemMoveable  = 0x0002
+)
+
+// privateClipboardFormats mark clipboard contents as not for history,
+// cloud sync, or monitoring.
+var privateClipboardFormats = []string{
+	"ExcludeClipboardContentFromMonitorProcessing",
+	"CanIncludeInClipboardHistory",
+	"CanUploadToCloudClipboard",
+}
+
+func writeClipboard(text string) error {
+	utf16, err := windows.UTF16FromString(text)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 2*len(utf16))
+	for i, c := range utf16 {
+		data[2*i], data[2*i+1] = byte(c), byte(c>>8)
+	}
+	defer wipe(data)
+
+	// another program may have the clipboard open for a moment
+	opened := false
+	for i := 0; i < 10 && !opened; i++ {
+		r, _, _ := procOpenClipboard.Call(0)
+		if opened = r != 0; !opened {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	if !opened {
+		return fmt.Errorf("the clipboard is in use by another program")
+	}
+	defer procCloseClipboard.Call()
+	if r, _, err := procEmptyClipboard.Call(); r == 0 {
+		return err
+	}
+	if err := setClipboardData(cfUnicodeText, data); err != nil {
+		return err
+	}
+	for _, name := range privateClipboardFormats {
+		p, err := windows.UTF16PtrFromString(name)
+		if err != nil {
+			continue
+		}
+		if format, _, _ := procRegisterClipboardFormat.Call(uintptr(unsafe.Pointer(p))); format != 0 {
+			setClipboardData(format, []byte{0, 0, 0, 0})
+		}
+	}
+	return nil
+}
+
+// setClipboardData hands the clipboard a copy of data in global memory.
+func setClipboardData(format uintptr, data []byte) error {
+	h, _, err := procGlobalAlloc.Call(gmemMoveable, uintptr(len(data)))
+	if h == 0 {
+		return err
+	}
+	p, _, err := procGlobalLock.Call(h)
+	if p == 0 {
+		procGlobalFree.Call(h)
+		return err
+	}
+	procRtlMoveMemory.Call(p, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)))
+	procGlobalUnlock.Call(h)
+	if r, _, err := procSetClipboardData.Call(format, h); r == 0 {
+		procGlobalFree.Call(h)
+		return err
+	}
+	return nil
+}
+
+func readClipboard() (string, error) {
+	return clipboard.ReadAll()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// maxClockSkew is how far this device's clock may be from the server's
+// before sync warns. Edits changed on two devices are settled by their
+// timestamps, so a clock that is far off can make the wrong edit win.
+const maxClockSkew = 2 * time.Minute
+
+// clockSkew estimates how far the local clock is ahead of the server's
+// (negative if it is behind) from the Date header of a response
+// received at the given local time. The header is only accurate to
+// the second, and ok is false if there is none.
+func clockSkew(resp *http.Response, received time.Time) (skew time.Duration, ok bool) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	return received.Sub(date).Round(time.Second), true
+}
+
+// describeSkew says which way and how far the clock is off.
+func describeSkew(skew time.Duration) string {
+	if skew < 0 {
+		return fmt.Sprintf("%v behind", -skew)
+	}
+	return fmt.Sprintf("%v ahead of", skew)
+}
+
+func skewTooLarge(skew time.Duration) bool {
+	return skew > maxClockSkew || skew < -maxClockSkew
+}
+
+// checkClockSkew warns if the local clock is far from the server's, and
+// returns the skew to record with the sync.
+func checkClockSkew(resp *http.Response, received time.Time) time.Duration {
+	skew, ok := clockSkew(resp, received)
+	if !ok {
+		debugf("the server sent no usable Date header; cannot check the clock")
+		return 0
+	}
+	debugf("clock skew: %s the server", describeSkew(skew))
+	if skewTooLarge(skew) {
+		fmt.Fprintf(os.Stderr, "Warning: this device's clock is %s the server's.\n", describeSkew(skew))
+		fmt.Fprintf(os.Stderr, "Sync uses timestamps to settle edits made on more than one device; set the clock right.\n")
+	}
+	return skew
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cloneProfile creates a new profile with the same settings as an
+// existing one, for sites that need several similar accounts.
+func cloneProfile() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	p := new(Profile)
+	registerProfileFlags(p)
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	registerAliasFlag()
+	registerRevealFlags()
+	flag.Parse()
+	args := trailingFlags()
+	if p.Name == "" {
+		exitf(exitUsage, "-name is required\n")
+	}
+	if len(args) != 1 {
+		exitf(exitUsage, "Must provide exactly one search term to find the profile to clone\n")
+	}
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	src := chooseProfile(client.Search(query), args[0], "clone")
+	if src.Scheme == schemeStored {
+		failf("Stored passwords cannot be cloned; use letmein create -stored\n")
+	}
+	if matches := client.Matches(p.Name); len(matches) != 0 {
+		failf("Cannot create new profile that matches existing profile %s\n", matches[0].Name)
+	}
+
+	// copy the settings but not the identity, fields, or attachments
+	q := &Profile{
+		Scheme:      defaultScheme(),
+		UUID:        newUUID(),
+		URL:         src.URL,
+		Folder:      src.Folder,
+		Length:      src.Length,
+		Lower:       src.Lower,
+		Upper:       src.Upper,
+		Digits:      src.Digits,
+		Punctuation: src.Punctuation,
+		Spaces:      src.Spaces,
+		Latin1:      src.Latin1,
+		Include:     src.Include,
+		Exclude:     src.Exclude,
+		AutoType:    src.AutoType,
+		ModifiedAt:  &now,
+	}
+	switch {
+	case strings.HasPrefix(src.Scheme, schemeScryptV2+"("):
+		q.Scheme = scryptV2Scheme(config.ScryptN, config.ScryptR, config.ScryptP)
+	case strings.HasPrefix(src.Scheme, schemeScryptV3+"("):
+		q.Scheme = scryptV3Scheme(config.ScryptN, config.ScryptR, config.ScryptP)
+	case !strings.HasPrefix(src.Scheme, "scrypt("):
+		// other managers' schemes stay as they are
+		q.Scheme = src.Scheme
+	}
+	applyProfileFlags(q, p)
+	applyAlias(q, master, client.Name, true)
+	if err := q.Validate(); err != nil {
+		failf("invalid profile: %v\n", err)
+	}
+	if err := q.checkMaxLength(src.Length); err != nil {
+		failf("invalid profile: %v\n", err)
+	}
+
+	password := q.Generate(master)
+	fmt.Printf("profile created: %s --> %s\n", q, maskPassword(password))
+	revealPassword(password)
+	client.Profiles = append(client.Profiles, q)
+	recordOp("create", nil, []string{q.UUID})
+
+	return client
+}
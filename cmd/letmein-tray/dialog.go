@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// The tray has no windows of its own, so it asks questions and reports
+// errors with the desktop's dialog tools: zenity or kdialog on Linux
+// and the BSDs, AppleScript on macOS, and PowerShell on Windows. Text
+// for scripts is passed in the environment so none of it needs quoting.
+
+// ask shows a one-line text box and returns what was typed, or false
+// if it was cancelled. A hidden box is for passwords.
+func ask(prompt string, hidden bool) (string, bool) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := `display dialog (system attribute "LETMEIN_PROMPT") default answer "" with title "letmein"`
+		if hidden {
+			script += " with hidden answer"
+		}
+		cmd = exec.Command("osascript", "-e", script, "-e", "text returned of result")
+	case "windows":
+		script := `Add-Type -AssemblyName Microsoft.VisualBasic; ` +
+			`$s = [Microsoft.VisualBasic.Interaction]::InputBox($env:LETMEIN_PROMPT, 'letmein'); ` +
+			`if ($s -eq '') { exit 1 }; $s`
+		if hidden {
+			script = `$c = $Host.UI.PromptForCredential('letmein', $env:LETMEIN_PROMPT, 'letmein', ''); ` +
+				`if ($c -eq $null) { exit 1 }; $c.GetNetworkCredential().Password`
+		}
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	default:
+		_, err := exec.LookPath("zenity")
+		switch {
+		case err == nil && hidden:
+			cmd = exec.Command("zenity", "--password", "--title", "letmein")
+		case err == nil:
+			cmd = exec.Command("zenity", "--entry", "--title", "letmein", "--text", prompt)
+		case hidden:
+			cmd = exec.Command("kdialog", "--title", "letmein", "--password", prompt)
+		default:
+			cmd = exec.Command("kdialog", "--title", "letmein", "--inputbox", prompt)
+		}
+	}
+	cmd.Env = append(os.Environ(), "LETMEIN_PROMPT="+prompt)
+	hideConsole(cmd)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimRight(string(out), "\r\n"), true
+}
+
+func showInfo(msg string) {
+	message(msg, false)
+}
+
+func showError(msg string) {
+	message(msg, true)
+}
+
+func message(msg string, isError bool) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := `display alert "letmein" message (system attribute "LETMEIN_MESSAGE")`
+		if isError {
+			script += " as critical"
+		}
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		icon := "Information"
+		if isError {
+			icon = "Error"
+		}
+		cmd = exec.Command("powershell", "-NoProfile", "-Command",
+			`Add-Type -AssemblyName System.Windows.Forms; `+
+				`[System.Windows.Forms.MessageBox]::Show($env:LETMEIN_MESSAGE, 'letmein', 'OK', '`+icon+`') | Out-Null`)
+	default:
+		_, err := exec.LookPath("zenity")
+		switch {
+		case err == nil && isError:
+			cmd = exec.Command("zenity", "--error", "--title", "letmein", "--no-markup", "--text", msg)
+		case err == nil:
+			cmd = exec.Command("zenity", "--info", "--title", "letmein", "--no-markup", "--text", msg)
+		case isError:
+			cmd = exec.Command("kdialog", "--title", "letmein", "--error", msg)
+		default:
+			cmd = exec.Command("kdialog", "--title", "letmein", "--msgbox", msg)
+		}
+	}
+	cmd.Env = append(os.Environ(), "LETMEIN_MESSAGE="+msg)
+	hideConsole(cmd)
+	cmd.Run()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"runtime"
+)
+
+const iconSize = 32
+
+// trayIcon draws a key: a ring with a shaft and two teeth. Windows
+// wants an ICO file, which may hold a PNG as its only image.
+func trayIcon() []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, iconSize, iconSize))
+	ink := color.NRGBA{R: 0x2b, G: 0x6c, B: 0xb0, A: 0xff}
+	for y := 0; y < iconSize; y++ {
+		for x := 0; x < iconSize; x++ {
+			dx, dy := x-9, y-16
+			ring := dx*dx+dy*dy <= 8*8 && dx*dx+dy*dy >= 4*4
+			shaft := x >= 15 && x <= 30 && y >= 14 && y <= 17
+			teeth := (x >= 22 && x <= 24 || x >= 27 && x <= 29) && y > 17 && y <= 22
+			if ring || shaft || teeth {
+				img.Set(x, y, ink)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	if runtime.GOOS != "windows" {
+		return buf.Bytes()
+	}
+
+	// ICONDIR with one ICONDIRENTRY pointing at the PNG
+	var ico bytes.Buffer
+	binary.Write(&ico, binary.LittleEndian, []uint16{0, 1, 1})
+	ico.Write([]byte{iconSize, iconSize, 0, 0})
+	binary.Write(&ico, binary.LittleEndian, []uint16{1, 32})
+	binary.Write(&ico, binary.LittleEndian, []uint32{uint32(buf.Len()), 6 + 16})
+	ico.Write(buf.Bytes())
+	return ico.Bytes()
+}
@@ -0,0 +1,299 @@
+// letmein-tray puts letmein in the system tray, for people who would
+// rather not use a terminal. It searches profiles, copies passwords to
+// the clipboard, locks and unlocks, and syncs.
+//
+// letmein is a single command rather than a library, so the tray runs
+// the letmein binary for everything it does. The master password is
+// asked for once by Unlock and handed to letmein unlock, whose session
+// the other commands then use; the tray itself never keeps it.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/systray"
+	"github.com/atotto/clipboard"
+)
+
+// exitBadMaster is letmein's exit code for a missing or wrong master
+// password, which here means the session has run out.
+const exitBadMaster = 5
+
+// maxResults is how many search results the menu shows.
+const maxResults = 10
+
+const defaultClipboardTimeout = 45
+
+var (
+	letmeinPath string
+	vaultPath   string
+)
+
+// result is one profile found by a search.
+type result struct {
+	uuid     string
+	name     string
+	username string
+}
+
+// tray holds the menu and the results it is showing.
+type tray struct {
+	mu      sync.Mutex
+	results []result
+
+	slots []*systray.MenuItem
+}
+
+func main() {
+	// gather options
+	flag.StringVar(&letmeinPath, "letmein", "", "Path to the letmein command (default: next to this one, or on the PATH)")
+	flag.StringVar(&vaultPath, "vault", "", "Path to profile store (or set LETMEIN_VAULT)")
+	flag.Parse()
+	if letmeinPath == "" {
+		letmeinPath = findLetmein()
+	}
+	if _, err := exec.LookPath(letmeinPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot find the letmein command: %v\n", err)
+		os.Exit(1)
+	}
+
+	t := new(tray)
+	systray.Run(t.ready, func() {})
+}
+
+// findLetmein prefers a letmein installed alongside the tray.
+func findLetmein() string {
+	name := "letmein"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	if exe, err := os.Executable(); err == nil {
+		path := filepath.Join(filepath.Dir(exe), name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return name
+}
+
+func (t *tray) ready() {
+	systray.SetIcon(trayIcon())
+	systray.SetTooltip("letmein")
+
+	search := systray.AddMenuItem("Search…", "Find a profile and copy its password")
+	for i := 0; i < maxResults; i++ {
+		slot := systray.AddMenuItem("", "Copy this password to the clipboard")
+		slot.Hide()
+		t.slots = append(t.slots, slot)
+		go func(i int) {
+			for range slot.ClickedCh {
+				t.copy(i)
+			}
+		}(i)
+	}
+	systray.AddSeparator()
+	unlock := systray.AddMenuItem("Unlock…", "Enter the master password for the next few minutes")
+	lock := systray.AddMenuItem("Lock", "Forget the master password now")
+	syncNow := systray.AddMenuItem("Sync", "Sync profiles with the server")
+	systray.AddSeparator()
+	quit := systray.AddMenuItem("Quit", "Quit letmein-tray")
+
+	go func() {
+		for {
+			select {
+			case <-search.ClickedCh:
+				t.search()
+			case <-unlock.ClickedCh:
+				t.unlock()
+			case <-lock.ClickedCh:
+				if _, err := run(nil, "lock"); err != nil {
+					showError(err.Error())
+				}
+			case <-syncNow.ClickedCh:
+				if _, err := t.runUnlocked("sync"); err != nil {
+					showError(err.Error())
+				} else {
+					showInfo("Sync complete")
+				}
+			case <-quit.ClickedCh:
+				systray.Quit()
+				return
+			}
+		}
+	}()
+}
+
+// run runs letmein with the given input and returns what it printed.
+// A failure carries what letmein said on standard error.
+func run(stdin []byte, args ...string) ([]byte, error) {
+	if vaultPath != "" {
+		args = append([]string{"-vault", vaultPath}, args...)
+	}
+	cmd := exec.Command(letmeinPath, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	hideConsole(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out, &letmeinError{err: err, msg: msg}
+		}
+		return out, err
+	}
+	return out, nil
+}
+
+type letmeinError struct {
+	err error
+	msg string
+}
+
+func (e *letmeinError) Error() string { return e.msg }
+
+func exitCode(err error) int {
+	if e, ok := err.(*letmeinError); ok {
+		err = e.err
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode()
+	}
+	return -1
+}
+
+// runUnlocked runs a command that needs the master password, asking
+// for it first if the session has run out.
+func (t *tray) runUnlocked(args ...string) ([]byte, error) {
+	out, err := run(nil, args...)
+	if exitCode(err) != exitBadMaster {
+		return out, err
+	}
+	if !t.unlock() {
+		return nil, fmt.Errorf("letmein is locked")
+	}
+	return run(nil, args...)
+}
+
+// unlock asks for the master password and starts a letmein session.
+func (t *tray) unlock() bool {
+	master, ok := ask("Master password:", true)
+	if !ok {
+		return false
+	}
+	_, err := run([]byte(master+"\n"), "unlock", "-master-stdin")
+	if err != nil {
+		showError(err.Error())
+		return false
+	}
+	return true
+}
+
+func (t *tray) search() {
+	term, ok := ask("Search for:", false)
+	if !ok {
+		return
+	}
+
+	// listing needs no master password
+	out, err := run(nil, "list", "-no-generate", "-format", "{{.UUID}}\t{{.Name}}\t{{.Username}}", "--", term)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+	var results []result
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		results = append(results, result{uuid: fields[0], name: fields[1], username: fields[2]})
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.results = results
+	for i, slot := range t.slots {
+		if i >= len(results) {
+			slot.Hide()
+			continue
+		}
+		title := "    " + results[i].name
+		if results[i].username != "" {
+			title += " (" + results[i].username + ")"
+		}
+		slot.SetTitle(title)
+		slot.Show()
+	}
+	switch {
+	case len(results) == 0:
+		showInfo(fmt.Sprintf("No profiles match %q", term))
+	case len(results) > maxResults:
+		showInfo(fmt.Sprintf("%d profiles match %q; showing the first %d", len(results), term, maxResults))
+	}
+}
+
+// copy puts the password of a search result on the clipboard, and
+// clears it again after clipboard_timeout unless something else has
+// been copied since.
+func (t *tray) copy(i int) {
+	t.mu.Lock()
+	if i >= len(t.results) {
+		t.mu.Unlock()
+		return
+	}
+	r := t.results[i]
+	t.mu.Unlock()
+
+	args := []string{"list", "-regex", "-format", "{{.UUID}}\t{{.Password}}"}
+	if r.username != "" {
+		args = append(args, "-user", r.username)
+	}
+	out, err := t.runUnlocked(append(args, "^"+regexp.QuoteMeta(r.name)+"$")...)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+	password := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.SplitN(line, "\t", 2); len(fields) == 2 && fields[0] == r.uuid {
+			password = strings.TrimRight(fields[1], "\r")
+		}
+	}
+	if password == "" {
+		showError(fmt.Sprintf("%s has no password to copy", r.name))
+		return
+	}
+	if err := clipboard.WriteAll(password); err != nil {
+		showError(fmt.Sprintf("Error copying to clipboard: %v", err))
+		return
+	}
+	time.AfterFunc(clipboardTimeout(), func() {
+		if current, err := clipboard.ReadAll(); err == nil && current == password {
+			clipboard.WriteAll("")
+		}
+	})
+}
+
+// clipboardTimeout follows letmein's clipboard_timeout setting.
+func clipboardTimeout() time.Duration {
+	seconds := defaultClipboardTimeout
+	if out, err := run(nil, "config", "clipboard_timeout"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(out))); err == nil && n > 0 {
+			seconds = n
+		}
+	}
+	return time.Duration(seconds) * time.Second
+}
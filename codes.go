@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxRecoveryCodes limits how many codes one profile can hold.
+const maxRecoveryCodes = 100
+
+// RecoveryCode is a one-time 2FA backup code. The list of codes is
+// stored encrypted in Profile.Codes.
+type RecoveryCode struct {
+	Code   string     `json:"code"`
+	UsedAt *time.Time `json:"used_at,omitempty"`
+}
+
+func openCodes(key []byte, p *Profile) []*RecoveryCode {
+	if p.Codes == "" {
+		return nil
+	}
+	raw, err := openSecret(key, p.Codes)
+	if err != nil {
+		failf("Error decrypting recovery codes for %s: %v\n", p.Name, err)
+	}
+	defer wipe(raw)
+	var codes []*RecoveryCode
+	if err := json.Unmarshal(raw, &codes); err != nil {
+		failf("Error parsing recovery codes for %s: %v\n", p.Name, err)
+	}
+	return codes
+}
+
+func sealCodes(key []byte, p *Profile, codes []*RecoveryCode) {
+	if len(codes) == 0 {
+		p.Codes = ""
+		return
+	}
+	raw, err := json.Marshal(codes)
+	if err != nil {
+		failf("Error encoding recovery codes: %v\n", err)
+	}
+	p.Codes = sealSecret(key, raw)
+	wipe(raw)
+}
+
+// readCodes reads codes from standard input, separated by spaces or
+// newlines, as sites usually present them.
+func readCodes() []string {
+	if interactive() {
+		fmt.Fprintf(os.Stderr, "Enter the recovery codes, then an empty line:\n")
+	}
+	var codes []string
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" && interactive() {
+			break
+		}
+		codes = append(codes, strings.Fields(line)...)
+	}
+	if err := scanner.Err(); err != nil {
+		failf("Error reading recovery codes: %v\n", err)
+	}
+	return codes
+}
+
+func codesCommand() *Client {
+	now := time.Now().Round(time.Millisecond)
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n\n")
+		fmt.Fprintf(os.Stderr, "    letmein codes add [-replace] <query>   (reads codes from standard input)\n")
+		fmt.Fprintf(os.Stderr, "    letmein codes use <query>\n")
+		fmt.Fprintf(os.Stderr, "    letmein codes ls <query>\n\n")
+		flag.PrintDefaults()
+	}
+	if len(os.Args) < 2 || (os.Args[1] != "add" && os.Args[1] != "use" && os.Args[1] != "ls") {
+		usage()
+		osExit(exitUsage)
+	}
+	sub := os.Args[1]
+	os.Args = os.Args[1:]
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	replace := false
+	flag.BoolVar(&replace, "replace", replace, "With add, discard the existing codes (for a freshly generated set)")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+	key := secretKey(master, client.Name)
+
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	p := chooseProfile(client.Search(query), args[0], "use codes of")
+	before := *p
+	codes := openCodes(key, p)
+
+	switch sub {
+	case "ls":
+		unused := 0
+		for _, elt := range codes {
+			if elt.UsedAt == nil {
+				unused++
+			} else {
+				fmt.Printf("    used %s\n", elt.UsedAt.Local().Format(time.Stamp))
+			}
+		}
+		fmt.Printf("%d of %d recovery codes unused\n", unused, len(codes))
+		return nil
+
+	case "add":
+		if replace {
+			codes = nil
+		}
+		added := readCodes()
+		if len(added) == 0 {
+			failf("No recovery codes given\n")
+		}
+		for _, code := range added {
+			codes = append(codes, &RecoveryCode{Code: code})
+		}
+		if len(codes) > maxRecoveryCodes {
+			failf("A profile can hold at most %d recovery codes\n", maxRecoveryCodes)
+		}
+		sealCodes(key, p, codes)
+		fmt.Printf("stored %d recovery codes for %s\n", len(added), p.Name)
+
+	case "use":
+		var code *RecoveryCode
+		unused := 0
+		for _, elt := range codes {
+			if elt.UsedAt == nil {
+				if code == nil {
+					code = elt
+				} else {
+					unused++
+				}
+			}
+		}
+		if code == nil {
+			failf("No unused recovery codes for %s\n", p.Name)
+		}
+		used := now
+		code.UsedAt = &used
+		sealCodes(key, p, codes)
+		fmt.Println(code.Code)
+		fmt.Fprintf(os.Stderr, "%d recovery codes left for %s\n", unused, p.Name)
+		if unused == 0 {
+			fmt.Fprintf(os.Stderr, "That was the last one; generate new codes on the site\n")
+		}
+	}
+
+	p.ModifiedAt = &now
+	if err := p.Validate(); err != nil {
+		failf("updated profile is invalid, canceling: %v\n", err)
+	}
+	stampChanges(&before, p, now)
+	recordOp("codes", []*Profile{&before}, nil)
+	return client
+}
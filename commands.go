@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A command is one letmein subcommand. The run function parses its own
+// flags from os.Args (with os.Args[0] set to the command name) and
+// returns the client record it loaded, if any.
+type command struct {
+	name    string
+	summary string
+	run     func() *Client
+
+	// saves means a returned client is written back to the store
+	saves bool
+
+	// writes means the command always modifies the store, so it is
+	// refused up front in read-only mode
+	writes bool
+}
+
+// commands lists every subcommand in the order shown by help.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "init", summary: "create a new client instance", run: initProfile, saves: true, writes: true},
+		{name: "list", summary: "list all matching profiles with passwords", run: listProfiles},
+		{name: "show", summary: "show one profile with its password and custom fields", run: showProfile},
+		{name: "create", summary: "create a new profile", run: createProfile, saves: true, writes: true},
+		{name: "clone", summary: "create a new profile with the settings of an existing one", run: cloneProfile, saves: true, writes: true},
+		{name: "update", summary: "update an existing profile", run: updateProfile, saves: true, writes: true},
+		{name: "bulk-update", summary: "change settings on every matching profile", run: bulkUpdate, saves: true, writes: true},
+		{name: "rename", summary: "rename a profile without changing its password", run: renameProfile, saves: true, writes: true},
+		{name: "archive", summary: "hide a profile from searches without deleting it", run: archiveCommand(true), saves: true, writes: true},
+		{name: "unarchive", summary: "bring back an archived profile", run: archiveCommand(false), saves: true, writes: true},
+		{name: "import", summary: "import profiles from another deterministic password manager", run: importCommand, saves: true, writes: true},
+		{name: "delete", summary: "delete a profile", run: deleteProfile, saves: true, writes: true},
+		{name: "batch", summary: "run JSON commands from standard input, one per line", run: batchCommand},
+		{name: "sync", summary: "sync profiles with server", run: syncProfiles, saves: true, writes: true},
+		{name: "account", summary: "move the account to another server or name", run: accountCommand, saves: true, writes: true},
+		{name: "share", summary: "share stored-password profiles with other accounts", run: shareCommand, saves: true},
+		{name: "pair", summary: "send a snapshot of the profiles to a phone that scans a QR code", run: noClient(pairCommand)},
+		{name: "unlock", summary: "ask for the master password once for the next few minutes", run: noClient(unlockCommand)},
+		{name: "lock", summary: "end a session started by unlock", run: noClient(lockCommand)},
+		{name: "log", summary: "show when passwords were generated, if audit_log is on", run: auditLogCommand},
+		{name: "history", summary: "show recent syncs", run: noClient(historyCommand)},
+		{name: "devices", summary: "list devices that sync this account, or revoke one", run: devicesCommand, saves: true},
+		{name: "sshkey", summary: "derive an SSH key from a profile", run: sshKeyProfile},
+		{name: "agekey", summary: "derive an age encryption identity from a profile", run: ageKeyProfile},
+		{name: "emergency-kit", summary: "print a recovery sheet to store offline", run: noClient(emergencyKit)},
+		{name: "recovery", summary: "split the master password into shares, or combine them", run: noClient(recoveryCommand)},
+		{name: "bench", summary: "measure scrypt speed and recommend cost parameters", run: noClient(benchCommand)},
+		{name: "analyze", summary: "check how evenly a profile's passwords use its characters", run: analyzeCommand},
+		{name: "vectors", summary: "print known answers for each password scheme, or check this build against them", run: noClient(vectorsCommand)},
+		{name: "upgrade-scheme", summary: "switch a profile to stronger scrypt parameters", run: upgradeScheme, saves: true, writes: true},
+		{name: "attach", summary: "add, get, or remove files attached to a profile", run: attachCommand, saves: true},
+		{name: "codes", summary: "store 2FA recovery codes with a profile and use them one at a time", run: codesCommand, saves: true},
+		{name: "type", summary: "type a username and password into another window", run: typeProfile},
+		{name: "menu", summary: "pick a profile and copy or type its password", run: menuProfile},
+		{name: "native-host", summary: "browser extension native messaging host", run: noClient(nativeHost)},
+		{name: "serve-api", summary: "serve a local HTTP API for integrations", run: noClient(serveAPI)},
+		{name: "gc", summary: "remove tombstones of deleted profiles", run: gcProfiles, saves: true, writes: true},
+		{name: "undo", summary: "revert the most recent change", run: undoOp, saves: true, writes: true},
+		{name: "nuke", summary: "delete the local store, and optionally the server's copy", run: noClient(nukeCommand), writes: true},
+		{name: "trash", summary: "list deleted profiles, or restore one", run: trashCommand, saves: true},
+		{name: "git", summary: "run git in a store kept as one file per profile", run: noClient(gitCommand)},
+		{name: "restore", summary: "restore profile data from a backup", run: noClient(restoreBackup), writes: true},
+		{name: "doctor", summary: "check the store and setup for problems", run: noClient(doctorCommand)},
+		{name: "config", summary: "get or set default settings", run: noClient(configCommand)},
+		{name: "self-update", summary: "install the latest signed release of letmein", run: noClient(selfUpdate)},
+		{name: "completion", summary: "print a shell completion script", run: noClient(completionCommand)},
+		{name: "gen-docs", summary: "write man pages and a markdown reference for every command", run: noClient(genDocs)},
+		{name: "help", summary: "show help for letmein or one of its commands", run: noClient(helpCommand)},
+	}
+}
+
+func noClient(f func()) func() *Client {
+	return func() *Client {
+		f()
+		return nil
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// commandNames lists the subcommands offered by shell completion.
+func commandNames() []string {
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+// global options, given before the command
+var (
+	verbose     bool
+	veryVerbose bool
+	jsonOutput  bool
+	globalVault string
+)
+
+// globalFlags parses the options that precede the command.
+func globalFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("letmein", flag.ExitOnError)
+	fs.Usage = func() { usage(os.Stderr) }
+	fs.StringVar(&globalVault, "vault", "", "Path to profile store (or set LETMEIN_VAULT)")
+	fs.BoolVar(&jsonOutput, "json", false, "Print results as JSON")
+	fs.BoolVar(&verbose, "v", false, "Print extra detail about what is happening")
+	fs.BoolVar(&veryVerbose, "vv", false, "Print trace detail, including redacted sync traffic")
+	fs.StringVar(&logFile, "log", "", "Write log messages to a file instead of standard error")
+	fs.BoolVar(&readOnly, "read-only", false, "Refuse any change to the profile store")
+	return fs
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `letmein is a password generator
+
+Usage:
+
+        letmein [global options] command [arguments] <searchterm>
+
+The commands are:
+
+`)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "    %-15s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprint(w, `
+The global options are:
+
+    -vault path     use a different profile store
+    -json           print results as JSON
+    -v              print extra detail about what is happening
+    -vv             also trace sync traffic (with secrets redacted)
+    -log file       write log messages to a file for bug reports
+    -read-only      refuse any change to the profile store
+
+Use "letmein help command" for more information about a command.
+`)
+}
+
+// helpCommand shows the general usage or a command's flags.
+func helpCommand() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil || cmd.name == "help" {
+		unknownCommand(args[0])
+	}
+
+	// every command prints its flags and exits when given -help
+	os.Args = []string{cmd.name, "-help"}
+	cmd.run()
+}
+
+// unknownCommand fails, suggesting the closest command names.
+func unknownCommand(name string) {
+	var close []string
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd.name, name) || editDistance(name, cmd.name) <= 2 {
+			close = append(close, cmd.name)
+		}
+	}
+	msg := fmt.Sprintf("Unknown command %q", name)
+	if len(close) > 0 {
+		msg += "; did you mean " + strings.Join(close, " or ") + "?"
+	}
+	exitf(exitUsage, "%s\nRun \"letmein help\" for a list of commands.\n", msg)
+}
+
+// editDistance is the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
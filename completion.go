@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const bashCompletion = `# letmein bash completion
+_letmein() {
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        COMPREPLY=( $(compgen -W "%s" -- "$cur") )
+    elif [[ "$cur" != -* ]]; then
+        local IFS=$'\n'
+        COMPREPLY=( $(compgen -W "$(letmein completion -names 2>/dev/null)" -- "$cur") )
+    fi
+}
+complete -F _letmein letmein
+`
+
+const zshCompletion = `#compdef letmein
+_letmein() {
+    if (( CURRENT == 2 )); then
+        compadd -- %s
+    elif [[ "$PREFIX" != -* ]]; then
+        local -a names
+        names=("${(@f)$(letmein completion -names 2>/dev/null)}")
+        compadd -a names
+    fi
+}
+compdef _letmein letmein
+`
+
+const fishCompletion = `# letmein fish completion
+complete -c letmein -f
+complete -c letmein -n __fish_use_subcommand -a "%s"
+complete -c letmein -n "not __fish_use_subcommand" -a "(letmein completion -names 2>/dev/null)"
+`
+
+const powershellCompletion = `# letmein PowerShell completion
+Register-ArgumentCompleter -Native -CommandName letmein -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $count = $commandAst.CommandElements.Count
+    if ($count -eq 1 -or ($count -eq 2 -and $wordToComplete)) {
+        $candidates = @(%s)
+    } else {
+        $candidates = @(letmein completion -names 2>$null)
+    }
+    $candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        $text = if ($_ -match '\s') { "'$_'" } else { $_ }
+        [System.Management.Automation.CompletionResult]::new($text, $_, 'ParameterValue', $_)
+    }
+}
+`
+
+func completionCommand() {
+	names := false
+	registerVaultFlag()
+	flag.BoolVar(&names, "names", false, "Print profile names for dynamic completion")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: letmein completion bash|zsh|fish|powershell\n")
+	}
+	flag.Parse()
+
+	if names {
+		printProfileNames()
+		return
+	}
+
+	args := flag.Args()
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Printf(bashCompletion, strings.Join(commandNames(), " "))
+	case "zsh":
+		fmt.Printf(zshCompletion, strings.Join(commandNames(), " "))
+	case "fish":
+		fmt.Printf(fishCompletion, strings.Join(commandNames(), " "))
+	case "powershell":
+		fmt.Printf(powershellCompletion, "'"+strings.Join(commandNames(), "', '")+"'")
+	default:
+		failf("Unknown shell %q: must be bash, zsh, fish, or powershell\n", args[0])
+	}
+}
+
+// printProfileNames lists profile names without requiring the master
+// password. Errors are silently ignored, since the output is consumed by
+// a shell completion function or picker.
+func printProfileNames() {
+	raw, _ := readProfileNames()
+	os.Stdout.Write(raw)
+}
+
+// readProfileNames returns the names of all live, unarchived profiles,
+// one per line, with favorites and frequently used profiles first.
+func readProfileNames() ([]byte, error) {
+	client, err := readClient()
+	if err != nil {
+		return nil, err
+	}
+	var profiles []*Profile
+	for _, elt := range client.Profiles {
+		if !elt.IsDeleted() && !elt.Archived {
+			profiles = append(profiles, elt)
+		}
+	}
+	sortForPicker(profiles, loadUsage())
+	buf := new(bytes.Buffer)
+	for _, elt := range profiles {
+		fmt.Fprintln(buf, elt.Name)
+	}
+	return buf.Bytes(), nil
+}
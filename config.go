@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var configFilename = filepath.Join(configDir(), "config.toml")
+
+// Config holds user defaults that override the built-in constants.
+type Config struct {
+	Server           string `toml:"server"`
+	Length           int    `toml:"length"`
+	MaxLength        int    `toml:"max_length"`
+	Lower            bool   `toml:"lower"`
+	Upper            bool   `toml:"upper"`
+	Digits           bool   `toml:"digits"`
+	Punctuation      bool   `toml:"punctuation"`
+	Spaces           bool   `toml:"spaces"`
+	ClipboardTimeout int    `toml:"clipboard_timeout"`
+	ShowPasswords    bool   `toml:"show_passwords"`
+	RevealSeconds    int    `toml:"reveal_seconds"`
+	AuditLog         bool   `toml:"audit_log"`
+	Vault            string `toml:"vault"`
+	Backend          string `toml:"backend"`
+	Alias            string `toml:"alias"`
+	Backups          int    `toml:"backups"`
+	TombstoneDays    int    `toml:"tombstone_days"`
+	TrashDays        int    `toml:"trash_days"`
+	AutoType         string `toml:"autotype"`
+	ScryptN          int    `toml:"scrypt_n"`
+	ScryptR          int    `toml:"scrypt_r"`
+	ScryptP          int    `toml:"scrypt_p"`
+	CacheSeconds     int    `toml:"cache_seconds"`
+	ReadOnly         bool   `toml:"read_only"`
+	ConnectTimeout   int    `toml:"connect_timeout"`
+	HTTPTimeout      int    `toml:"http_timeout"`
+	HTTPRetries      int    `toml:"http_retries"`
+	CAFile           string `toml:"ca_file"`
+	PinSHA256        string `toml:"pin_sha256"`
+	CompressSync     bool   `toml:"compress_sync"`
+	SyncSignatures   string `toml:"sync_signatures"`
+	Pinentry         string `toml:"pinentry"`
+	SessionMinutes   int    `toml:"session_minutes"`
+
+	AllowInsecurePermissions bool `toml:"allow_insecure_permissions"`
+	AllowInsecureMaster      bool `toml:"allow_insecure_master"`
+
+	// Templates are named sets of profile settings for create -template,
+	// using the same keys as bulk-update -set.
+	Templates map[string]map[string]interface{} `toml:"templates"`
+}
+
+// config is the active configuration, loaded at startup.
+var config = defaultConfig()
+
+func defaultConfig() *Config {
+	return &Config{
+		Server:           defaultServer,
+		Length:           defaultLength,
+		MaxLength:        maxLength,
+		Lower:            true,
+		Upper:            true,
+		Digits:           true,
+		Punctuation:      true,
+		Spaces:           false,
+		ClipboardTimeout: defaultClipboardTimeout,
+		RevealSeconds:    defaultRevealSeconds,
+		Vault:            "",
+		Backend:          backendJSON,
+		Backups:          defaultBackups,
+		TombstoneDays:    defaultTombstoneDays,
+		TrashDays:        defaultTrashDays,
+		AutoType:         defaultAutoType,
+		ScryptN:          scryptN,
+		ScryptR:          scryptR,
+		ScryptP:          scryptP,
+		CacheSeconds:     defaultCacheSeconds,
+		SessionMinutes:   defaultSessionMinutes,
+		ConnectTimeout:   defaultConnectTimeout,
+		HTTPTimeout:      defaultHTTPTimeout,
+		HTTPRetries:      defaultHTTPRetries,
+		SyncSignatures:   signaturesSign,
+	}
+}
+
+// loadConfig reads the config file (if any) on top of the defaults.
+func loadConfig() *Config {
+	c := defaultConfig()
+	if _, err := toml.DecodeFile(configFilename, c); err != nil && !os.IsNotExist(err) {
+		failf("Error reading %s: %v\n", configFilename, err)
+	}
+	if err := c.Validate(); err != nil {
+		failf("Error in %s: %v\n", configFilename, err)
+	}
+	return c
+}
+
+// Validate checks settings that would otherwise fail later in confusing ways.
+func (c *Config) Validate() error {
+	if c.MaxLength < minLength || c.MaxLength > maxLengthLimit {
+		return fmt.Errorf("max_length must be between %d and %d", minLength, maxLengthLimit)
+	}
+	if err := checkScryptParams(c.ScryptN, c.ScryptR, c.ScryptP); err != nil {
+		return err
+	}
+	if c.SessionMinutes < 1 {
+		return fmt.Errorf("session_minutes must be at least 1")
+	}
+	if c.ConnectTimeout < 1 || c.HTTPTimeout < 1 {
+		return fmt.Errorf("connect_timeout and http_timeout must be at least 1 second")
+	}
+	if c.Backend != backendJSON && c.Backend != backendDir && c.Backend != backendSQLite {
+		return fmt.Errorf("backend must be %s, %s, or %s", backendJSON, backendDir, backendSQLite)
+	}
+	if c.Alias != "" {
+		if err := checkAliasBase(c.Alias); err != nil {
+			return err
+		}
+	}
+	if c.RevealSeconds < 0 {
+		return fmt.Errorf("reveal_seconds cannot be negative")
+	}
+	if c.TrashDays < 0 {
+		return fmt.Errorf("trash_days cannot be negative")
+	}
+	if c.HTTPRetries < 0 {
+		return fmt.Errorf("http_retries cannot be negative")
+	}
+	switch c.SyncSignatures {
+	case signaturesOff, signaturesSign, signaturesRequire:
+	default:
+		return fmt.Errorf("sync_signatures must be %s, %s, or %s", signaturesOff, signaturesSign, signaturesRequire)
+	}
+	for name, settings := range c.Templates {
+		if err := applyTemplate(new(Profile), settings); err != nil {
+			return fmt.Errorf("template %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// saveConfig writes the config file, creating its directory if necessary.
+func saveConfig(c *Config) {
+	buf := new(bytes.Buffer)
+	if err := toml.NewEncoder(buf).Encode(c); err != nil {
+		failf("Error encoding %s: %v\n", configFilename, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(configFilename), 0700); err != nil {
+		failf("Error creating directory for %s: %v\n", configFilename, err)
+	}
+	if err := writeFileAtomic(configFilename, buf.Bytes(), 0600); err != nil {
+		failf("Error writing %s: %v\n", configFilename, err)
+	}
+}
+
+// applyConfig installs config-file and environment overrides for global settings.
+func applyConfig(c *Config) {
+	if c.ReadOnly {
+		readOnly = true
+	}
+	if c.Vault != "" {
+		filename = expandHome(c.Vault)
+	}
+	if s := os.Getenv("LETMEIN_VAULT"); s != "" {
+		filename = expandHome(s)
+	}
+	migrateLegacyStore()
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		return filepath.Join(homeDir(), path[1:])
+	}
+	return path
+}
+
+// configField finds the struct field for a config key.
+func configField(c *Config, key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("toml") == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func configKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, t.Field(i).Tag.Get("toml"))
+	}
+	return keys
+}
+
+func configCommand() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: letmein config [key [value]]\n\nKeys: %s\n", strings.Join(configKeys(), ", "))
+	}
+	flag.Parse()
+	args := flag.Args()
+	c := loadConfig()
+
+	switch len(args) {
+	case 0:
+		// list all settings
+		for _, key := range configKeys() {
+			field, _ := configField(c, key)
+			fmt.Printf("%s = %v\n", key, field.Interface())
+		}
+
+	case 1:
+		// get one setting
+		field, ok := configField(c, args[0])
+		if !ok {
+			failf("Unknown config key: %s\n", args[0])
+		}
+		fmt.Printf("%v\n", field.Interface())
+
+	case 2:
+		// set one setting
+		field, ok := configField(c, args[0])
+		if !ok {
+			failf("Unknown config key: %s\n", args[0])
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(args[1])
+		case reflect.Int:
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				failf("Value for %s must be an integer: %v\n", args[0], err)
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(args[1])
+			if err != nil {
+				failf("Value for %s must be true or false: %v\n", args[0], err)
+			}
+			field.SetBool(b)
+		default:
+			failf("Edit %s to change %s\n", configFilename, args[0])
+		}
+		if err := c.Validate(); err != nil {
+			failf("Invalid setting: %v\n", err)
+		}
+		saveConfig(c)
+
+	default:
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+const cpUTF8 = 65001
+
+// setupConsole switches the Windows console to UTF-8 output and turns
+// on the escape sequences used for colors, which older consoles leave
+// off. Output that is not a console is left alone.
+func setupConsole() {
+	windows.SetConsoleOutputCP(cpUTF8)
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		h := windows.Handle(f.Fd())
+		var mode uint32
+		if windows.GetConsoleMode(h, &mode) == nil {
+			windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+		}
+	}
+}
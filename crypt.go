@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dchest/scrypt"
+)
+
+// sealedPrefix marks values encrypted by sealSecret.
+const sealedPrefix = "sealed1:"
+
+// secretKeys caches derived encryption keys, since scrypt is slow. Like
+// derivedCache it is keyed by a hash of the inputs, and wipeSecrets
+// clears it before the process exits.
+var (
+	secretKeys   = make(map[[sha256.Size]byte][]byte)
+	secretKeysMu sync.Mutex
+)
+
+// secretKey derives the key used to encrypt stored secrets. The salt is
+// tied to the account name, so every device derives the same key from
+// the same master password without any extra state to sync.
+func secretKey(master, account string) []byte {
+	password := []byte(master)
+	defer wipe(password)
+	return accountKey(password, account)
+}
+
+// accountKey is secretKey for a master password already in a byte
+// slice. The key is shared through the cache, so callers must not wipe
+// it.
+func accountKey(password []byte, account string) []byte {
+	salt := "letmein secrets\t" + account
+	id := kdfCacheKey(password, salt, scryptN, scryptR, scryptP, 32)
+	secretKeysMu.Lock()
+	key, ok := secretKeys[id]
+	secretKeysMu.Unlock()
+	if ok {
+		return key
+	}
+	key, err := scrypt.Key(password, []byte(salt), scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		failf("scrypt error: %v\n", err)
+	}
+	lockMemory(key)
+	secretKeysMu.Lock()
+	secretKeys[id] = key
+	secretKeysMu.Unlock()
+	return key
+}
+
+// storedKey derives the key for a profile's stored password. It is tied
+// to the profile's UUID rather than the account, so Generate needs only
+// the master password.
+func storedKey(master []byte, p *Profile) []byte {
+	return accountKey(master, "stored\t"+p.UUID)
+}
+
+// sealSecret encrypts plaintext with AES-GCM under the given key.
+func sealSecret(key, plaintext []byte) string {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err.Error())
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err.Error())
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("error generating nonce: %v", err))
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// openSecret decrypts a value produced by sealSecret.
+func openSecret(key []byte, sealed string) ([]byte, error) {
+	if !strings.HasPrefix(sealed, sealedPrefix) {
+		return nil, fmt.Errorf("value is not encrypted")
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed[len(sealedPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("decoding encrypted value: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted value is truncated")
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: wrong master password or corrupted data")
+	}
+	return plain, nil
+}
@@ -0,0 +1,80 @@
+//go:build cshared
+
+package main
+
+// #include <stdlib.h>
+// #include <string.h>
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+)
+
+// Built as a C shared library, letmein exports its generator so apps in
+// other languages use the same code as the command line:
+//
+//	go build -buildmode=c-shared -tags cshared -o libletmein.so
+//
+// Go writes libletmein.h alongside it. The functions take and return
+// UTF-8 C strings. letmein_generate and letmein_check_master return
+// JSON: {"password": ...} or {"ok": ...} on success, {"error": ...} on
+// failure. Every string returned must be handed back to letmein_free,
+// which wipes it.
+
+// cResult encodes a result for C, in memory C owns.
+func cResult(result map[string]interface{}, err error) *C.char {
+	if err != nil {
+		result = map[string]interface{}{"error": err.Error()}
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		raw = []byte(`{"error":"encoding the result failed"}`)
+	}
+	defer wipe(raw)
+	out := (*C.char)(C.malloc(C.size_t(len(raw) + 1)))
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(out)), len(raw)+1)
+	copy(buf, raw)
+	buf[len(raw)] = 0
+	return out
+}
+
+//export letmein_generate
+func letmein_generate(profileJSON, master *C.char) *C.char {
+	p := new(Profile)
+	if err := json.Unmarshal([]byte(C.GoString(profileJSON)), p); err != nil {
+		return cResult(nil, fmt.Errorf("parsing profile: %v", err))
+	}
+	if err := p.Validate(); err != nil {
+		return cResult(nil, err)
+	}
+	password, err := p.generate(C.GoString(master))
+	return cResult(map[string]interface{}{"password": password}, err)
+}
+
+//export letmein_check_master
+func letmein_check_master(storeJSON, master *C.char) *C.char {
+	client, err := decodeClient([]byte(C.GoString(storeJSON)))
+	if err != nil {
+		return cResult(nil, fmt.Errorf("parsing store: %v", err))
+	}
+	return cResult(map[string]interface{}{"ok": client.checkMaster(C.GoString(master)) == nil}, nil)
+}
+
+//export letmein_version
+func letmein_version() *C.char {
+	return C.CString(version)
+}
+
+//export letmein_free
+func letmein_free(s *C.char) {
+	if s != nil {
+		C.memset(unsafe.Pointer(s), 0, C.strlen(s))
+		C.free(unsafe.Pointer(s))
+	}
+}
+
+func init() {
+	config = defaultConfig()
+}
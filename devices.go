@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Device is one client instance as reported by the sync server.
+type Device struct {
+	ID         string     `json:"device_id"`
+	Name       string     `json:"device_name"`
+	LastSyncAt *time.Time `json:"last_sync_at,omitempty"`
+	Revoked    bool       `json:"revoked,omitempty"`
+}
+
+// deviceRequest identifies the account (and optionally a device to
+// revoke) to the server the same way a sync does.
+type deviceRequest struct {
+	Name     string `json:"name"`
+	Verify   string `json:"verify"`
+	DeviceID string `json:"device_id"`
+	Revoke   string `json:"revoke,omitempty"`
+}
+
+// ensureDevice gives this client a device ID the first time it syncs.
+func ensureDevice(client *Client) {
+	if client.DeviceID != "" {
+		return
+	}
+	client.DeviceID = newUUID()
+	client.DeviceName, _ = os.Hostname()
+}
+
+func devicesCommand() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	server := config.Server
+	revoke := ""
+	flag.StringVar(&server, "server", server, "Server URL")
+	flag.StringVar(&revoke, "revoke", revoke, "Device ID to revoke, so it can no longer sync")
+	flag.Parse()
+	if isSSHServer(server) {
+		exitf(exitUsage, "Devices are only tracked by sync servers, not over ssh\n")
+	}
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+	ensureDevice(client)
+	if revoke != "" && revoke == client.DeviceID {
+		failf("Cannot revoke this device\n")
+	}
+
+	raw, err := json.Marshal(&deviceRequest{
+		Name:     client.Name,
+		Verify:   VerifyProfile.Generate(master),
+		DeviceID: client.DeviceID,
+		Revoke:   revoke,
+	})
+	if err != nil {
+		failf("Error JSON-encoding request: %v\n", err)
+	}
+	resp, err := sendRequest("POST", server+"/api/v1noauth/devices", "application/json", raw, secretHeader(accountSecret(client, master)))
+	if err != nil {
+		exitf(exitNetwork, "Error contacting server: %v\n", err)
+	}
+	checkClaimed(resp, server, client.Name)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		exitf(exitNetwork, "This server does not support device management\n")
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		exitf(exitNetwork, "Server returned an error status: %s\n%s\n", resp.Status, body)
+	}
+	var devices []*Device
+	if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		exitf(exitNetwork, "Error decoding server response JSON: %v\n", err)
+	}
+
+	if jsonOutput {
+		dump(devices)
+		return client
+	}
+	if revoke != "" {
+		fmt.Printf("revoked device %s\n", revoke)
+	}
+	for _, d := range devices {
+		last := "never synced"
+		if d.LastSyncAt != nil {
+			last = "last sync " + d.LastSyncAt.Local().Format(time.Stamp)
+		}
+		note := ""
+		if d.ID == client.DeviceID {
+			note = " (this device)"
+		} else if d.Revoked {
+			note = " (revoked)"
+		}
+		fmt.Printf("    %s  %-20s %s%s\n", d.ID, d.Name, last, note)
+	}
+	return client
+}
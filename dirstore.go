@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The dir store keeps each profile in its own small JSON file, laid out
+// by folder and name the way pass lays out its password files, so the
+// store can live in a git repository with diffs that say what changed.
+// The rest of the client record is in .letmein.json at the top, and
+// deleted profiles are kept in .deleted by UUID. Names beginning with a
+// dot are reserved for letmein and git.
+//
+// If the directory is a git repository, every save is committed.
+
+const backendDir = "dir"
+
+const (
+	dirRecordFile = ".letmein.json"
+	dirDeleted    = ".deleted"
+)
+
+type dirStore struct {
+	path string
+}
+
+func (s dirStore) Read() (*Client, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(s.path, dirRecordFile))
+	if err != nil {
+		return nil, err
+	}
+	client, err := decodeClient(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", filepath.Join(s.path, dirRecordFile), err)
+	}
+	files, err := s.profileFiles()
+	if err != nil {
+		return nil, err
+	}
+	client.Profiles = []*Profile{}
+	for _, rel := range files {
+		raw, err := ioutil.ReadFile(filepath.Join(s.path, rel))
+		if err != nil {
+			return nil, err
+		}
+		p := new(Profile)
+		if err := json.Unmarshal(raw, p); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", filepath.Join(s.path, rel), err)
+		}
+		client.Profiles = append(client.Profiles, p)
+	}
+	debugf("read %d profile files from %s", len(files), s.path)
+	return client, nil
+}
+
+// profileFiles lists the profile files, relative to the store and in
+// lexical order.
+func (s dirStore) profileFiles() ([]string, error) {
+	var files []string
+	err := filepath.Walk(s.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.path, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if rel != "." && strings.HasPrefix(info.Name(), ".") && rel != dirDeleted {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(rel) == ".json" && rel != dirRecordFile && !strings.HasPrefix(info.Name(), ".") {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// dirComponent makes one part of a folder or profile name safe to use
+// as a file name on any system.
+func dirComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "" || strings.HasPrefix(s, ".") {
+		s = "_" + s
+	}
+	return s
+}
+
+// layout picks a file for each profile. Where two profiles would share
+// a file, the later one gets part of its UUID added.
+func (s dirStore) layout(profiles []*Profile) []string {
+	taken := make(map[string]bool)
+	paths := make([]string, len(profiles))
+	for i, p := range profiles {
+		var dir, base string
+		if p.IsDeleted() {
+			dir, base = dirDeleted, p.UUID
+		} else {
+			var parts []string
+			for _, part := range strings.Split(p.Folder, "/") {
+				if part != "" {
+					parts = append(parts, dirComponent(part))
+				}
+			}
+			dir, base = filepath.Join(parts...), dirComponent(p.Name)
+		}
+		rel := filepath.Join(dir, base+".json")
+		if taken[strings.ToLower(rel)] {
+			rel = filepath.Join(dir, base+" ("+shortUUID(p.UUID)+").json")
+		}
+		taken[strings.ToLower(rel)] = true
+		paths[i] = rel
+	}
+	return paths
+}
+
+func shortUUID(uuid string) string {
+	if len(uuid) > 8 {
+		return uuid[:8]
+	}
+	return uuid
+}
+
+// Write rewrites the files whose contents changed and removes those of
+// profiles that are gone.
+func (s dirStore) Write(client *Client) error {
+	if err := os.MkdirAll(s.path, 0700); err != nil {
+		return err
+	}
+	old, err := s.profileFiles()
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	written := 0
+	for i, rel := range s.layout(client.Profiles) {
+		keep[rel] = true
+		raw, err := json.MarshalIndent(client.Profiles[i], "", "    ")
+		if err != nil {
+			return err
+		}
+		changed, err := writeIfChanged(filepath.Join(s.path, rel), append(raw, '\n'))
+		if err != nil {
+			return err
+		}
+		if changed {
+			written++
+		}
+	}
+
+	record := *client
+	record.Profiles = nil
+	raw, err := json.MarshalIndent(&record, "", "    ")
+	if err != nil {
+		return err
+	}
+	if _, err := writeIfChanged(filepath.Join(s.path, dirRecordFile), append(raw, '\n')); err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, rel := range old {
+		if keep[rel] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.path, rel)); err != nil {
+			return err
+		}
+		removed++
+
+		// drop folders left empty
+		for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+			if os.Remove(filepath.Join(s.path, dir)) != nil {
+				break
+			}
+		}
+	}
+	debugf("wrote %d and removed %d profile files in %s", written, removed, s.path)
+	gitCommitStore(s.path)
+	return nil
+}
+
+// writeIfChanged writes a file unless it already holds data, so
+// unchanged profiles keep their timestamps and stay out of diffs.
+func writeIfChanged(path string, data []byte) (bool, error) {
+	if old, err := ioutil.ReadFile(path); err == nil && string(old) == string(data) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return false, err
+	}
+	return true, writeFileAtomic(path, data, 0600)
+}
+
+// Export assembles the JSON store format from the files.
+func (s dirStore) Export() ([]byte, error) {
+	client, err := s.Read()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.MarshalIndent(client, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(raw, '\n'), nil
+}
+
+func (s dirStore) Import(raw []byte) error {
+	client, err := decodeClient(raw)
+	if err != nil {
+		return err
+	}
+	return s.Write(client)
+}
+
+// isDirStore reports whether path holds a dir store.
+func isDirStore(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// gitCommitStore commits everything in a dir store that is a git
+// repository. A failure is reported but does not undo the save.
+func gitCommitStore(path string) {
+	if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+		return
+	}
+	status, err := exec.Command("git", "-C", path, "status", "--porcelain").Output()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: git status in %s failed: %v\n", path, err)
+		return
+	}
+	if len(status) == 0 {
+		return
+	}
+	msg := "letmein " + auditCommand
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "-q", "-m", msg}} {
+		cmd := exec.Command("git", append([]string{"-C", path}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: git %s in %s failed: %v\n%s", args[0], path, err, out)
+			return
+		}
+	}
+	debugf("committed %s: %s", path, msg)
+}
+
+// gitCommand runs git in a dir store, passing its arguments through.
+func gitCommand() {
+	if len(os.Args) > 1 && (os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "--help") {
+		fmt.Fprintf(os.Stderr, "Usage: letmein git ARGS...\n\n")
+		fmt.Fprintf(os.Stderr, "Runs git in the store directory (backend dir), e.g. letmein git init,\n")
+		fmt.Fprintf(os.Stderr, "letmein git log, or letmein git push. Once the store is a git\n")
+		fmt.Fprintf(os.Stderr, "repository, letmein commits every change to it.\n")
+		return
+	}
+	if !isDirStore(filename) {
+		failf("%s is not a directory; letmein git needs a store created with init -backend dir\n", filename)
+	}
+	cmd := exec.Command("git", append([]string{"-C", filename}, os.Args[1:]...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		if e, ok := err.(*exec.ExitError); ok {
+			os.Exit(e.ExitCode())
+		}
+		failf("Error running git: %v\n", err)
+	}
+
+	// start the history with the store as it is
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		auditCommand = "git init"
+		gitCommitStore(filename)
+	}
+}
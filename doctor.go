@@ -0,0 +1,198 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// A diagnosis is the result of one doctor check.
+type diagnosis struct {
+	Check  string `json:"check"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+func doctorCommand() {
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	offline := false
+	flag.BoolVar(&offline, "offline", offline, "Skip the server reachability check")
+	reseal := false
+	flag.BoolVar(&reseal, "reseal", reseal, "Accept the store as it is after an integrity warning and seal it again")
+	flag.Parse()
+
+	if reseal {
+		resealStore(master)
+		return
+	}
+
+	var results []*diagnosis
+	report := func(check string, ok bool, detail, fix string) {
+		results = append(results, &diagnosis{Check: check, OK: ok, Detail: detail, Fix: fix})
+	}
+
+	report("config", true, configFilename, "")
+	client := doctorStore(report)
+	if client != nil {
+		doctorProfiles(client, report)
+	}
+	if pending := loadPendingSync(); pending != nil {
+		report("sync queue", false,
+			fmt.Sprintf("a sync to %s has been queued since %s: %s", pending.Server, pending.Since.Local().Format(time.Stamp), pending.LastError),
+			"run letmein sync -pending")
+	}
+	if !offline {
+		doctorServer(report)
+	}
+
+	problems := 0
+	for _, r := range results {
+		if !r.OK {
+			problems++
+		}
+	}
+	if jsonOutput {
+		dump(results)
+	} else {
+		for _, r := range results {
+			status := "ok  "
+			if !r.OK {
+				status = "FAIL"
+			}
+			fmt.Printf("%s  %-12s %s\n", status, r.Check, r.Detail)
+			if r.Fix != "" {
+				fmt.Printf("                   fix: %s\n", r.Fix)
+			}
+		}
+	}
+	if problems > 0 {
+		exitf(exitError, "%d problems found\n", problems)
+	}
+}
+
+// resealStore loads the store even if it fails the integrity check, and
+// saves it with a fresh checksum.
+func resealStore(master string) {
+	now := time.Now().Round(time.Millisecond)
+	master = getAndVerifyMaster(master)
+	acceptIntegrity = true
+	client := getClient(now, master)
+	saveClient(client)
+	fmt.Printf("%s sealed again with %d profiles\n", filename, len(client.Profiles))
+}
+
+// doctorStore checks that the store can be read and parsed, and that
+// its permissions are safe. It returns the parsed client, if any.
+func doctorStore(report func(check string, ok bool, detail, fix string)) *Client {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		report("store", false, filename+" does not exist", "run letmein init, or letmein restore")
+		return nil
+	} else if err != nil {
+		report("store", false, err.Error(), "")
+		return nil
+	}
+	want := storeMode(info)
+	if mode := info.Mode().Perm(); runtime.GOOS != "windows" && mode != want {
+		report("permissions", false, fmt.Sprintf("%s has mode %04o", filename, mode), fmt.Sprintf("chmod %o %s", want, filename))
+	} else {
+		report("permissions", true, fmt.Sprintf("mode %04o", want), "")
+	}
+
+	raw, err := openStore().Export()
+	if err != nil {
+		report("store", false, err.Error(), "")
+		return nil
+	}
+	version, _ := storeVersionOf(raw)
+	client, err := decodeClient(raw)
+	if err != nil {
+		report("store", false, fmt.Sprintf("cannot parse %s: %v", filename, err), "run letmein restore to list backups, then letmein restore TIMESTAMP")
+		return nil
+	}
+	report("store", true, fmt.Sprintf("%s, account %s, %d profiles", filename, client.Name, len(client.Profiles)), "")
+	if version < storeVersion {
+		report("schema", true, fmt.Sprintf("format %d, upgraded to %d on the next change", version, storeVersion), "")
+	} else {
+		report("schema", true, fmt.Sprintf("format %d", version), "")
+	}
+	switch {
+	case client.Integrity == "" && (client.Sealed || storeSealedHere()):
+		report("integrity", false, "checksum missing from a store that had one", "check your profiles (letmein list -l) and backups (letmein restore), then letmein doctor -reseal")
+	case client.Integrity == "":
+		report("integrity", true, "no checksum yet; one is added the next time a command with the master password saves", "")
+	case len(client.IntegrityBase) > 0 || client.IntegrityHeader != nil:
+		report("integrity", true, fmt.Sprintf("checksum present; %s changed without the master password since it was set", strings.Join(changedWithoutMaster(client), ", ")), "")
+	default:
+		report("integrity", true, "checksum present; checked whenever the master password is entered", "")
+	}
+	return client
+}
+
+// doctorProfiles validates every profile and looks for duplicates.
+func doctorProfiles(client *Client, report func(check string, ok bool, detail, fix string)) {
+	bad := 0
+	uuids := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, p := range client.Profiles {
+		label := p.Name
+		if label == "" {
+			label = p.UUID
+		}
+
+		// Validate normalizes fields, so check a copy
+		q := *p
+		if err := q.Validate(); err != nil {
+			report("profile", false, fmt.Sprintf("%s: %v", label, err), "letmein update "+label+", or letmein delete "+label)
+			bad++
+		}
+		if uuids[p.UUID] {
+			report("profile", false, fmt.Sprintf("%s: duplicate uuid %s", label, p.UUID), "delete one copy with letmein delete")
+			bad++
+		}
+		uuids[p.UUID] = true
+		if !p.IsDeleted() {
+			if names[p.Name] {
+				report("profile", false, fmt.Sprintf("%s: more than one profile has this name", label), "rename one with letmein update -name")
+				bad++
+			}
+			names[p.Name] = true
+		}
+	}
+	if bad == 0 {
+		report("profiles", true, fmt.Sprintf("%d profiles valid", len(client.Profiles)), "")
+	}
+}
+
+// doctorServer checks that the sync server answers at all, and that
+// this device's clock agrees with it.
+func doctorServer(report func(check string, ok bool, detail, fix string)) {
+	if config.Server == "" {
+		report("server", true, "no server configured", "")
+		return
+	}
+	if isSSHServer(config.Server) {
+		report("server", true, "syncs over ssh with "+config.Server, "")
+		return
+	}
+	resp, err := httpClient().Get(config.Server)
+	if err != nil {
+		report("server", false, fmt.Sprintf("%s: %v", config.Server, err), "check the server setting and your network, or use -offline")
+		return
+	}
+	resp.Body.Close()
+	report("server", true, fmt.Sprintf("%s answered %s", config.Server, resp.Status), "")
+	if skew, ok := clockSkew(resp, time.Now()); !ok {
+		report("clock", true, "the server did not say what time it is", "")
+	} else if skewTooLarge(skew) {
+		report("clock", false, fmt.Sprintf("this device's clock is %s the server's", describeSkew(skew)), "set the clock, or turn on network time")
+	} else {
+		report("clock", true, fmt.Sprintf("within %v of the server", maxClockSkew), "")
+	}
+}
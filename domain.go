@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// hostOf extracts the host name from a URL, with or without a scheme.
+func hostOf(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+3:]
+	}
+	if i := strings.IndexAny(s, "/?#"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		s = s[i+1:]
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return strings.TrimSuffix(s, ".")
+}
+
+// registrableDomain reduces a URL to its registrable domain using the
+// public suffix list, so https://accounts.google.com/login and
+// google.com compare equal. IP addresses and single-label hosts are
+// returned unchanged.
+func registrableDomain(s string) string {
+	host := hostOf(s)
+	if host == "" || net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// SameSite reports whether two URLs share a registrable domain.
+func SameSite(a, b string) bool {
+	da := registrableDomain(a)
+	return da != "" && da == registrableDomain(b)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emergencyKit prints a plain-text document to keep with a paper copy
+// of the master password. It lists what exists but never a password,
+// so it needs no master password to produce.
+func emergencyKit() {
+	registerVaultFlag()
+	output := ""
+	flag.StringVar(&output, "o", output, "Write the kit to a file instead of standard output")
+	flag.Parse()
+	client := loadClient()
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "LETMEIN EMERGENCY KIT\n")
+	fmt.Fprintf(b, "printed %s\n\n", time.Now().Format("2 January 2006"))
+	fmt.Fprintf(b, "account name:   %s\n", client.Name)
+	fmt.Fprintf(b, "sync server:    %s\n", config.Server)
+	switch {
+	case client.NoVerify:
+		fmt.Fprintf(b, "verify code:    (none)\n")
+	case client.Verifier != nil:
+		fmt.Fprintf(b, "verify code:    %s\n", client.Verifier.Code)
+	default:
+		fmt.Fprintf(b, "verify code:    %s\n", client.Verify)
+	}
+	if client.FIDO2 != nil {
+		fmt.Fprintf(b, "security key:   required (FIDO2)\n")
+	}
+	fmt.Fprintf(b, "master password: ________________________________\n\n")
+
+	fmt.Fprintf(b, "TO RECOVER\n\n")
+	fmt.Fprintf(b, "1. Install letmein on any computer.\n")
+	fmt.Fprintf(b, "2. Run: letmein init -name %s -server %s\n", client.Name, config.Server)
+	fmt.Fprintf(b, "3. Run: letmein sync, entering the master password above.\n")
+	fmt.Fprintf(b, "4. Run: letmein list to see every password.\n")
+	fmt.Fprintf(b, "If the server is gone, recreate any profile below with\n")
+	fmt.Fprintf(b, "letmein create using the same settings; the password is the same.\n\n")
+
+	profiles := []*Profile{}
+	for _, p := range client.Profiles {
+		if !p.IsDeleted() {
+			profiles = append(profiles, p)
+		}
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	fmt.Fprintf(b, "PROFILES (%d)\n\n", len(profiles))
+	for _, p := range profiles {
+		fmt.Fprintf(b, "    %s\n", p)
+	}
+
+	if output == "" {
+		fmt.Print(b.String())
+		return
+	}
+	if err := writeFileAtomic(output, []byte(b.String()), 0600); err != nil {
+		failf("Error writing %s: %v\n", output, err)
+	}
+}
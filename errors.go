@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Exit codes, so scripts can tell failures apart.
+const (
+	exitError     = 1 // any other failure
+	exitUsage     = 2 // bad command line
+	exitNoMatch   = 3 // no profile matches the search
+	exitAmbiguous = 4 // several profiles match and none could be chosen
+	exitBadMaster = 5 // master password missing, malformed, or wrong
+	exitNoVault   = 6 // no profile store has been initialized
+	exitReadOnly  = 7 // the command would write in read-only mode
+	exitNetwork   = 8 // the sync server could not be reached or failed
+)
+
+var exitKinds = map[int]string{
+	exitError:     "error",
+	exitUsage:     "usage",
+	exitNoMatch:   "no-match",
+	exitAmbiguous: "ambiguous",
+	exitBadMaster: "bad-master",
+	exitNoVault:   "no-vault",
+	exitReadOnly:  "read-only",
+	exitNetwork:   "network",
+}
+
+// osExit is os.Exit, except while gen-docs runs commands to read their
+// flags.
+var osExit = os.Exit
+
+// exitf reports an error and exits with the given code. Under -json the
+// error is written as a JSON object instead of plain text.
+func exitf(code int, f string, args ...interface{}) {
+	msg := fmt.Sprintf(f, args...)
+	if jsonOutput {
+		raw, _ := json.Marshal(map[string]interface{}{
+			"error": strings.TrimSpace(msg),
+			"kind":  exitKinds[code],
+			"exit":  code,
+		})
+		fmt.Fprintf(os.Stderr, "%s\n", raw)
+	} else {
+		fmt.Fprint(os.Stderr, msg)
+	}
+	wipeSecrets()
+	osExit(code)
+}
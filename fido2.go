@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const fido2RelyingParty = "letmein"
+
+// FIDO2Token records a hardware key whose hmac-secret extension is mixed
+// into the master password, so generating anything requires the key.
+// The salt is random per vault; the key itself never leaves the device.
+// letmein talks to the device through the libfido2 command-line tools
+// (fido2-token, fido2-cred, fido2-assert), which also cover YubiKeys.
+type FIDO2Token struct {
+	CredentialID string `json:"credential_id"`
+	Salt         string `json:"salt"`
+}
+
+// fido2Device finds the first attached FIDO2 authenticator.
+func fido2Device() (string, error) {
+	out, err := exec.Command("fido2-token", "-L").Output()
+	if err != nil {
+		return "", fmt.Errorf("listing FIDO2 devices (is libfido2 installed?): %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if i := strings.Index(line, ": "); i > 0 {
+			return line[:i], nil
+		}
+	}
+	return "", fmt.Errorf("no FIDO2 device found")
+}
+
+// runFIDO2 runs one of the libfido2 tools with the given input lines
+// and returns its output lines. The tools prompt for a PIN or touch on
+// the terminal themselves.
+func runFIDO2(tool string, input []string, args ...string) ([]string, error) {
+	cmd := exec.Command(tool, args...)
+	cmd.Stdin = strings.NewReader(strings.Join(input, "\n") + "\n")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s: %v", tool, err)
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n"), nil
+}
+
+func randomBase64(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("error generating random bytes: %v", err))
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// enrollFIDO2 creates a credential with the hmac-secret extension on
+// the attached device.
+func enrollFIDO2(name string) (*FIDO2Token, error) {
+	device, err := fido2Device()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "touch your security key to register it\n")
+	input := []string{randomBase64(32), fido2RelyingParty, name, randomBase64(16)}
+	lines, err := runFIDO2("fido2-cred", input, "-M", "-h", device)
+	if err != nil {
+		return nil, err
+	}
+
+	// output is client data hash, rp id, format, auth data, credential id, ...
+	if len(lines) < 5 {
+		return nil, fmt.Errorf("unexpected output from fido2-cred")
+	}
+	return &FIDO2Token{CredentialID: lines[4], Salt: randomBase64(32)}, nil
+}
+
+// unlock mixes the token's hmac-secret into the master password.
+func (t *FIDO2Token) unlock(master string) (string, error) {
+	device, err := fido2Device()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(os.Stderr, "touch your security key to unlock\n")
+	input := []string{randomBase64(32), fido2RelyingParty, t.CredentialID, t.Salt}
+	lines, err := runFIDO2("fido2-assert", input, "-G", "-h", device)
+	if err != nil {
+		return "", err
+	}
+
+	// the hmac-secret is the last line of the assertion
+	secret, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil || len(secret) != 32 {
+		return "", fmt.Errorf("fido2-assert did not return an hmac-secret")
+	}
+	mac := hmac.New(sha256.New, secret)
+	password := []byte(master)
+	mac.Write(password)
+	wipe(password)
+	wipe(secret)
+	sum := mac.Sum(nil)
+	defer wipe(sum)
+
+	// the result stands in for the master password everywhere
+	return "fido2:" + base64.StdEncoding.EncodeToString(sum), nil
+}
+
+// applyToken replaces master with the token-mixed secret if the vault
+// requires a hardware key.
+func applyToken(client *Client, master string) string {
+	if client.FIDO2 == nil {
+		return master
+	}
+	effective, err := client.FIDO2.unlock(master)
+	if err != nil {
+		failf("Security key required: %v\n", err)
+	}
+	return effective
+}
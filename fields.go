@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	maxFieldKeyLength   = 64
+	maxFieldValueLength = 4096
+)
+
+// fieldFlag collects -field key=value options. An empty value removes
+// the field.
+type fieldFlag map[string]string
+
+func (f fieldFlag) String() string {
+	var pairs []string
+	for k := range f {
+		pairs = append(pairs, k+"=...")
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f fieldFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 1 {
+		return fmt.Errorf("field must be given as key=value")
+	}
+	key := strings.TrimSpace(s[:i])
+	if len(key) > maxFieldKeyLength {
+		return fmt.Errorf("field name must be no more than %d characters", maxFieldKeyLength)
+	}
+	if len(s)-i-1 > maxFieldValueLength {
+		return fmt.Errorf("field value must be no more than %d characters", maxFieldValueLength)
+	}
+	f[key] = s[i+1:]
+	return nil
+}
+
+// applyFields encrypts and stores custom field changes on a profile.
+func applyFields(p *Profile, fields fieldFlag, key []byte) {
+	for k, v := range fields {
+		if v == "" {
+			delete(p.Fields, k)
+			continue
+		}
+		if p.Fields == nil {
+			p.Fields = make(map[string]string)
+		}
+		p.Fields[k] = sealSecret(key, []byte(v))
+	}
+	if len(p.Fields) == 0 {
+		p.Fields = nil
+	}
+}
+
+// fieldNames returns a profile's custom field names in order.
+func fieldNames(p *Profile) []string {
+	var names []string
+	for k := range p.Fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func copyFields(fields map[string]string) map[string]string {
+	if fields == nil {
+		return nil
+	}
+	out := make(map[string]string)
+	for k, v := range fields {
+		out[k] = v
+	}
+	return out
+}
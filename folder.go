@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxFolderLength = 256
+
+// cleanFolder normalizes a folder path: segments are trimmed and empty
+// ones dropped, so " work//aws/ " becomes "work/aws".
+func cleanFolder(folder string) string {
+	var parts []string
+	for _, elt := range strings.Split(norm.NFC.String(folder), "/") {
+		if elt = strings.TrimSpace(elt); elt != "" {
+			parts = append(parts, elt)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// inFolder reports whether a profile's folder is folder or one of its
+// subfolders. Folder names are matched without regard to case.
+func inFolder(profileFolder, folder string) bool {
+	folder = strings.ToLower(cleanFolder(folder))
+	if folder == "" {
+		return true
+	}
+	profileFolder = strings.ToLower(profileFolder)
+	return profileFolder == folder || strings.HasPrefix(profileFolder, folder+"/")
+}
+
+// listTree prints profiles grouped under their folders, indented by
+// depth, for list -tree.
+func listTree(profiles []*Profile, passwords []string) {
+	order := make([]int, len(profiles))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := profiles[order[i]], profiles[order[j]]
+		if fa, fb := strings.ToLower(a.Folder), strings.ToLower(b.Folder); fa != fb {
+			return fa < fb
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+
+	var open []string
+	for _, i := range order {
+		p := profiles[i]
+		var parts []string
+		if p.Folder != "" {
+			parts = strings.Split(p.Folder, "/")
+		}
+
+		// print the folders not shared with the previous profile
+		shared := 0
+		for shared < len(open) && shared < len(parts) && strings.EqualFold(open[shared], parts[shared]) {
+			shared++
+		}
+		for depth := shared; depth < len(parts); depth++ {
+			fmt.Printf("%s%s/\n", strings.Repeat("    ", depth+1), parts[depth])
+		}
+		open = parts
+
+		line := strings.Repeat("    ", len(parts)+1) + p.Name
+		if p.Username != "" {
+			line += "  " + p.Username
+		}
+		if p.URL != "" {
+			line += "  " + p.URL
+		}
+		if passwords != nil && passwords[i] != "" {
+			line += "  " + passwords[i]
+		}
+		fmt.Println(line)
+	}
+}
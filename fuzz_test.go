@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// These fuzz targets check the invariants that keep users' passwords
+// stable: validation settles on one form of a profile, generation is
+// deterministic and stays inside the character set, the character set
+// mapping is uniform, and the sync merge is idempotent. Generation runs
+// scrypt at its real cost, so fuzz it with a time limit, e.g.
+//
+//	go test -run XXX -fuzz FuzzGenerate -fuzztime 5m
+
+func init() {
+	config = defaultConfig()
+}
+
+// FuzzValidate checks that a profile Validate accepts survives a trip
+// through JSON and Validate again unchanged.
+func FuzzValidate(f *testing.F) {
+	for _, v := range testVectors {
+		raw, _ := json.Marshal(v.Profile)
+		f.Add(raw)
+	}
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"uuid":"00000000-0000-4000-8000-000000000001","deleted_at":"2020-01-01T00:00:00Z","name":"gone"}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := new(Profile)
+		if json.Unmarshal(data, p) != nil || p.Validate() != nil {
+			return
+		}
+		first, err := json.Marshal(p)
+		if err != nil {
+			t.Fatalf("encoding a valid profile: %v", err)
+		}
+		q := new(Profile)
+		if err := json.Unmarshal(first, q); err != nil {
+			t.Fatalf("decoding a valid profile: %v", err)
+		}
+		if err := q.Validate(); err != nil {
+			t.Fatalf("valid profile %s fails validation after a round trip: %v", first, err)
+		}
+		second, _ := json.Marshal(q)
+		if string(first) != string(second) {
+			t.Fatalf("validation is not stable:\n%s\n%s", first, second)
+		}
+	})
+}
+
+// FuzzGenerate checks that passwords have the profile's length, use
+// only its characters, and come out the same every time.
+func FuzzGenerate(f *testing.F) {
+	for _, v := range testVectors {
+		if v.Words == 0 && !strings.HasPrefix(v.Profile.Scheme, schemeSpectre) {
+			p := v.Profile
+			f.Add(v.Master, p.URL, p.Username, p.Generation, p.Length, classBits(p), p.Include, p.Exclude, p.Scheme == schemeLessPass)
+		}
+	}
+	f.Fuzz(func(t *testing.T, master, url, username string, generation, length int, classes uint8, include, exclude string, lessPass bool) {
+		p := &Profile{
+			Scheme:      schemeScrypt,
+			UUID:        "00000000-0000-4000-8000-000000000001",
+			Name:        "fuzz",
+			URL:         url,
+			Username:    username,
+			Generation:  generation,
+			Length:      length,
+			Lower:       classes&1 != 0,
+			Upper:       classes&2 != 0,
+			Digits:      classes&4 != 0,
+			Punctuation: classes&8 != 0,
+			Spaces:      classes&16 != 0,
+			Latin1:      classes&32 != 0,
+			Include:     include,
+			Exclude:     exclude,
+		}
+		if lessPass {
+			p.Scheme = schemeLessPass
+		}
+		if p.Validate() != nil {
+			return
+		}
+		password, err := p.generate(master)
+		if err != nil {
+			t.Fatalf("generating for a valid profile %s: %v", p, err)
+		}
+		if n := utf8.RuneCountInString(password); n != p.Length {
+			t.Fatalf("password %q has %d characters, want %d", password, n, p.Length)
+		}
+		if !lessPass {
+			chars := p.GetCharacterSet()
+			for _, r := range password {
+				if !strings.ContainsRune(chars, r) {
+					t.Fatalf("password %q has %q, which is not in %q", password, r, chars)
+				}
+			}
+		}
+		again, _ := p.generate(master)
+		if again != password {
+			t.Fatalf("password changed from %q to %q", password, again)
+		}
+	})
+}
+
+func classBits(p *Profile) uint8 {
+	var bits uint8
+	for i, on := range []bool{p.Lower, p.Upper, p.Digits, p.Punctuation, p.Spaces, p.Latin1} {
+		if on {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits
+}
+
+// FuzzEncodeCharset checks the mapping from derived bytes to characters
+// for any bytes and character set.
+func FuzzEncodeCharset(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0}, "abc", uint8(4))
+	f.Add([]byte{0xff, 0xff}, "0123456789", uint8(2))
+	f.Add([]byte("0123456789abcdef"), "ab¡¢£ ", uint8(16))
+	f.Fuzz(func(t *testing.T, hash []byte, set string, length uint8) {
+		var chars []rune
+		seen := make(map[rune]bool)
+		for _, r := range set {
+			if !seen[r] {
+				seen[r] = true
+				chars = append(chars, r)
+			}
+		}
+		if len(chars) == 0 || len(hash) == 0 {
+			return
+		}
+		out := string(encodeCharset(hash, chars, int(length)))
+		if n := utf8.RuneCountInString(out); n != int(length) {
+			t.Fatalf("got %d characters, want %d", n, length)
+		}
+		for _, r := range out {
+			if !seen[r] {
+				t.Fatalf("%q is not in the character set", r)
+			}
+		}
+	})
+}
+
+// TestEncodeCharsetUniform checks every two-byte input: each pair of
+// characters must come up equally often, give or take one, or some
+// passwords would be likelier than others.
+func TestEncodeCharsetUniform(t *testing.T) {
+	for _, k := range []int{1, 2, 3, 10, 26, 62, 94, 95, 112, 256} {
+		var chars []rune
+		for i := 0; i < k; i++ {
+			chars = append(chars, rune(0x100+i))
+		}
+		counts := make(map[string]int)
+		for h := 0; h < 1<<16; h++ {
+			counts[string(encodeCharset([]byte{byte(h >> 8), byte(h)}, chars, 2))]++
+		}
+		if len(counts) != k*k {
+			t.Errorf("%d characters: only %d of %d pairs appear", k, len(counts), k*k)
+			continue
+		}
+		low := (1 << 16) / (k * k)
+		for pair, n := range counts {
+			if n != low && n != low+1 {
+				t.Errorf("%d characters: %q appears %d times, want %d or %d", k, pair, n, low, low+1)
+				break
+			}
+		}
+	}
+}
+
+// TestSampleCharsetUniform checks that scrypt-v2 keeps the same number
+// of byte values for every character and skips the rest.
+func TestSampleCharsetUniform(t *testing.T) {
+	for _, k := range []int{1, 2, 3, 10, 26, 62, 94, 95, 112, 256} {
+		var chars []rune
+		for i := 0; i < k; i++ {
+			chars = append(chars, rune(0x100+i))
+		}
+		counts := make(map[string]int)
+		for b := 0; b < 256; b++ {
+			if out, ok := sampleCharset([]byte{byte(b)}, chars, 1); ok {
+				counts[string(out)]++
+			}
+		}
+		if len(counts) != k {
+			t.Errorf("%d characters: only %d appear", k, len(counts))
+			continue
+		}
+		for c, n := range counts {
+			if n != 256/k {
+				t.Errorf("%d characters: %q comes from %d bytes, want %d", k, c, n, 256/k)
+				break
+			}
+		}
+	}
+}
+
+// FuzzMerge edits two fields of a profile on two devices at given times
+// and checks the merge: every field comes from one side, a field changed
+// on only one side keeps that change when the last sync is known, and
+// merging again changes nothing.
+func FuzzMerge(f *testing.F) {
+	f.Add("github", "github", "GitHub", 0, 0, 1, int64(1000), int64(2000), true)
+	f.Add("github", "gh", "GitHub", 0, 1, 0, int64(2000), int64(1000), false)
+	f.Add("a", "b", "c", 1, 2, 3, int64(5), int64(5), true)
+	f.Fuzz(func(t *testing.T, baseName, localName, remoteName string, baseGen, localGen, remoteGen int, localAt, remoteAt int64, hashed bool) {
+		base := &Profile{Scheme: schemeScrypt, UUID: "00000000-0000-4000-8000-000000000001", Name: baseName, Generation: baseGen, Length: 16, Lower: true}
+		local, remote := *base, *base
+		local.Name, local.Generation = localName, localGen
+		remote.Name, remote.Generation = remoteName, remoteGen
+		stampChanges(base, &local, time.UnixMilli(localAt))
+		stampChanges(base, &remote, time.UnixMilli(remoteAt))
+		if hashed {
+			local.SyncHashes = fieldHashes(base)
+		}
+
+		merged, _ := mergeProfiles(&local, &remote)
+		l, r, m := reflect.ValueOf(local), reflect.ValueOf(remote), reflect.ValueOf(*merged)
+		for _, field := range mergeFields {
+			mv := m.FieldByName(field).Interface()
+			if !reflect.DeepEqual(mv, l.FieldByName(field).Interface()) && !reflect.DeepEqual(mv, r.FieldByName(field).Interface()) {
+				t.Fatalf("merged %s is %v, from neither side", field, mv)
+			}
+		}
+		if hashed {
+			if localName != baseName && remoteName == baseName && merged.Name != localName {
+				t.Fatalf("name changed only here to %q, but merged to %q", localName, merged.Name)
+			}
+			if remoteName != baseName && localName == baseName && merged.Name != remoteName {
+				t.Fatalf("name changed only on the server to %q, but merged to %q", remoteName, merged.Name)
+			}
+		}
+
+		again, _ := mergeProfiles(&local, merged)
+		if contentHash(again) != contentHash(merged) {
+			t.Fatalf("merging again changed %s to %s", merged, again)
+		}
+		if self, n := mergeProfiles(merged, merged); n != 0 || contentHash(self) != contentHash(merged) {
+			t.Fatalf("merging a profile with itself changed it or found %d conflicts", n)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// collectTombstones removes deleted profiles that were deleted at least
+// days ago. Tombstones that have not yet been uploaded by a sync are
+// kept unless force is set, so the deletion is not lost. It returns the
+// number of tombstones removed.
+func collectTombstones(client *Client, days int, force bool, now time.Time) int {
+	cutoff := now.AddDate(0, 0, -days)
+	kept := []*Profile{}
+	removed := 0
+	for _, elt := range client.Profiles {
+		switch {
+		case !elt.IsDeleted():
+			kept = append(kept, elt)
+		case elt.ModifiedAt != nil && !force:
+			// not yet acknowledged by the server
+			kept = append(kept, elt)
+		case elt.DeletedAt != nil && elt.DeletedAt.After(cutoff):
+			kept = append(kept, elt)
+		default:
+			removed++
+		}
+	}
+	client.Profiles = kept
+	return removed
+}
+
+func gcProfiles() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	registerVaultFlag()
+	days := 0
+	force := false
+	flag.IntVar(&days, "days", days, "Only remove tombstones older than this many days")
+	flag.BoolVar(&force, "force", force, "Also remove deletions that have not been synced")
+	flag.Parse()
+	client := loadClient()
+
+	pending := 0
+	for _, elt := range client.Profiles {
+		if elt.IsDeleted() && elt.ModifiedAt != nil {
+			pending++
+		}
+	}
+
+	recordSnapshot("gc", client)
+	removed := collectTombstones(client, days, force, now)
+	if removed == 0 {
+		// nothing worth undoing
+		pendingOp = nil
+	}
+	fmt.Printf("removed %d tombstones\n", removed)
+	if blobs := collectBlobs(client); blobs > 0 {
+		fmt.Printf("removed %d unused attachments\n", blobs)
+	}
+	if pending > 0 && !force {
+		fmt.Printf("kept %d deletions that have not been synced yet; run sync first or use -force\n", pending)
+	}
+
+	return client
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// letmein gen-docs writes man pages and a markdown reference built from
+// the commands and flags themselves, so the documentation cannot drift
+// from what letmein accepts. Each command is run with -help and stopped
+// as soon as it parses its flags, which every command does before it
+// touches the store.
+
+// commandDoc is what gen-docs learns about one command.
+type commandDoc struct {
+	cmd   *command
+	flags []*flag.Flag
+
+	// usage is what a command printed instead of its flags, for those
+	// with subcommands of their own
+	usage string
+}
+
+// flagsParsed stops a command once it has parsed its flags.
+type flagsParsed struct{}
+
+// exited stops a command that tried to exit.
+type exited struct{}
+
+// inspectCommand runs cmd with -help and records its flags.
+func inspectCommand(cmd *command) (doc *commandDoc) {
+	doc = &commandDoc{cmd: cmd}
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Usage = func() { panic(flagsParsed{}) }
+	savedFlags, savedArgs, savedStderr, savedExit := flag.CommandLine, os.Args, os.Stderr, osExit
+	flag.CommandLine, os.Args = fs, []string{cmd.name, "-help"}
+	osExit = func(int) { panic(exited{}) }
+
+	// catch whatever the command prints instead
+	out, err := ioutil.TempFile("", "letmein-docs")
+	if err != nil {
+		failf("Error creating a temporary file: %v\n", err)
+	}
+	defer os.Remove(out.Name())
+	os.Stderr = out
+
+	defer func() {
+		r := recover()
+		flag.CommandLine, os.Args, os.Stderr, osExit = savedFlags, savedArgs, savedStderr, savedExit
+		switch r.(type) {
+		case nil, flagsParsed, exited:
+		default:
+			panic(r)
+		}
+		fs.VisitAll(func(f *flag.Flag) { doc.flags = append(doc.flags, f) })
+		if len(doc.flags) == 0 {
+			out.Seek(0, io.SeekStart)
+			raw, _ := ioutil.ReadAll(out)
+			doc.usage = strings.TrimSpace(string(raw))
+		}
+		out.Close()
+	}()
+	cmd.run()
+	return doc
+}
+
+// flagDefault is a flag's default as the docs show it, or "" if it has
+// none worth showing.
+func flagDefault(f *flag.Flag) string {
+	switch f.DefValue {
+	case "", "false", "0", "[]":
+		return ""
+	}
+	if home := homeDir(); home != "" && strings.HasPrefix(f.DefValue, home+string(filepath.Separator)) {
+		return "~" + f.DefValue[len(home):]
+	}
+	return f.DefValue
+}
+
+func genDocs() {
+	// gather options
+	dir := "docs"
+	flag.StringVar(&dir, "dir", dir, "Directory to write the documentation to")
+	format := "all"
+	flag.StringVar(&format, "format", format, "What to write: man, markdown, or all")
+	flag.Parse()
+	if format != "man" && format != "markdown" && format != "all" {
+		exitf(exitUsage, "Unknown format %q; use man, markdown, or all\n", format)
+	}
+
+	// document the defaults, not this user's settings
+	config = defaultConfig()
+	filename = defaultVaultPath()
+	var docs []*commandDoc
+	for _, cmd := range commands {
+		if cmd.name == "help" || cmd.name == "gen-docs" {
+			continue
+		}
+		docs = append(docs, inspectCommand(cmd))
+	}
+	var global []*flag.Flag
+	globalFlags().VisitAll(func(f *flag.Flag) { global = append(global, f) })
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		failf("Error creating %s: %v\n", dir, err)
+	}
+	files := make(map[string][]byte)
+	if format != "markdown" {
+		files["letmein.1"] = manIndex(docs, global)
+		for _, doc := range docs {
+			files["letmein-"+doc.cmd.name+".1"] = manPage(doc)
+		}
+	}
+	if format != "man" {
+		files["letmein.md"] = markdownReference(docs, global)
+	}
+	for name, raw := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+			failf("Error writing %s: %v\n", path, err)
+		}
+	}
+	fmt.Printf("wrote %d files to %s\n", len(files), dir)
+}
+
+// roff escapes text for a man page.
+func roff(s string) string {
+	s = strings.Replace(s, `\`, `\e`, -1)
+	s = strings.Replace(s, "-", `\-`, -1)
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			line = `\&` + line
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func manFlags(w io.Writer, flags []*flag.Flag) {
+	for _, f := range flags {
+		name, usage := flag.UnquoteUsage(f)
+		if name != "" {
+			fmt.Fprintf(w, ".TP\n.BI \\-%s \" %s\"\n", roff(f.Name), roff(name))
+		} else {
+			fmt.Fprintf(w, ".TP\n.B \\-%s\n", roff(f.Name))
+		}
+		fmt.Fprintf(w, "%s\n", roff(usage))
+		if def := flagDefault(f); def != "" {
+			fmt.Fprintf(w, "(default: %s)\n", roff(def))
+		}
+	}
+}
+
+func manHeader(w io.Writer, title string) {
+	fmt.Fprintf(w, ".TH %s 1 \"\" \"letmein %s\" \"letmein manual\"\n", strings.ToUpper(roff(title)), roff(version))
+}
+
+func manIndex(docs []*commandDoc, global []*flag.Flag) []byte {
+	w := new(bytes.Buffer)
+	manHeader(w, "letmein")
+	fmt.Fprintf(w, ".SH NAME\nletmein \\- a deterministic password manager\n")
+	fmt.Fprintf(w, ".SH SYNOPSIS\n.B letmein\n[global options]\n.I command\n[options] [arguments]\n")
+	fmt.Fprintf(w, ".SH COMMANDS\n")
+	for _, doc := range docs {
+		fmt.Fprintf(w, ".TP\n.B %s\n%s; see\n.BR letmein\\-%s (1)\n", roff(doc.cmd.name), roff(doc.cmd.summary), roff(doc.cmd.name))
+	}
+	fmt.Fprintf(w, ".SH GLOBAL OPTIONS\nThese come before the command.\n")
+	manFlags(w, global)
+	return w.Bytes()
+}
+
+func manPage(doc *commandDoc) []byte {
+	w := new(bytes.Buffer)
+	name := "letmein-" + doc.cmd.name
+	manHeader(w, name)
+	fmt.Fprintf(w, ".SH NAME\n%s \\- %s\n", roff(name), roff(doc.cmd.summary))
+	fmt.Fprintf(w, ".SH SYNOPSIS\n.B letmein\n[global options]\n.B %s\n", roff(doc.cmd.name))
+	if len(doc.flags) > 0 {
+		fmt.Fprintf(w, "[options] [arguments]\n.SH OPTIONS\n")
+		manFlags(w, doc.flags)
+	} else if doc.usage != "" {
+		fmt.Fprintf(w, ".SH USAGE\n.nf\n%s\n.fi\n", roff(doc.usage))
+	}
+	fmt.Fprintf(w, ".SH SEE ALSO\n.BR letmein (1)\n")
+	return w.Bytes()
+}
+
+func markdownFlags(w io.Writer, flags []*flag.Flag) {
+	for _, f := range flags {
+		name, usage := flag.UnquoteUsage(f)
+		fmt.Fprintf(w, "* `-%s", f.Name)
+		if name != "" {
+			fmt.Fprintf(w, " %s", name)
+		}
+		fmt.Fprintf(w, "`: %s", usage)
+		if def := flagDefault(f); def != "" {
+			fmt.Fprintf(w, " (default: `%s`)", def)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+func markdownReference(docs []*commandDoc, global []*flag.Flag) []byte {
+	w := new(bytes.Buffer)
+	fmt.Fprintf(w, "# letmein command reference\n\n")
+	fmt.Fprintf(w, "Generated by `letmein gen-docs` from letmein %s.\n\n", version)
+	fmt.Fprintf(w, "    letmein [global options] command [options] [arguments]\n\n")
+	fmt.Fprintf(w, "## Global options\n\nThese come before the command.\n\n")
+	markdownFlags(w, global)
+	for _, doc := range docs {
+		fmt.Fprintf(w, "\n## %s\n\n%s.\n", doc.cmd.name, strings.ToUpper(doc.cmd.summary[:1])+doc.cmd.summary[1:])
+		if len(doc.flags) > 0 {
+			fmt.Fprintf(w, "\n")
+			markdownFlags(w, doc.flags)
+		} else if doc.usage != "" {
+			fmt.Fprintf(w, "\n```\n%s\n```\n", doc.usage)
+		}
+	}
+	return w.Bytes()
+}
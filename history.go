@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// maxHistory is the number of syncs remembered by letmein history.
+const maxHistory = 200
+
+// SyncRecord describes one sync attempt.
+type SyncRecord struct {
+	At     time.Time `json:"at"`
+	Server string    `json:"server"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+
+	// what the sync sent and what it changed locally
+	Sent      int `json:"sent"`
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Conflicts int `json:"conflicts"`
+	Rejected  int `json:"rejected,omitempty"`
+
+	// ClockSkew is how many seconds this device's clock was ahead of
+	// the server's (negative if behind)
+	ClockSkew int `json:"clock_skew,omitempty"`
+}
+
+func historyFilename() string {
+	return filename + ".history"
+}
+
+func loadHistory() []*SyncRecord {
+	raw, err := ioutil.ReadFile(historyFilename())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		failf("Error reading %s: %v\n", historyFilename(), err)
+	}
+	var history []*SyncRecord
+	if err := json.Unmarshal(raw, &history); err != nil {
+		failf("Error parsing %s: %v\n", historyFilename(), err)
+	}
+	return history
+}
+
+// recordSync appends a sync to the history.
+func recordSync(rec *SyncRecord) {
+	history := append(loadHistory(), rec)
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	raw, err := json.MarshalIndent(history, "", "    ")
+	if err != nil {
+		failf("Error encoding %s: %v\n", historyFilename(), err)
+	}
+	raw = append(raw, '\n')
+	if err := writeFileAtomic(historyFilename(), raw, 0600); err != nil {
+		failf("Error writing %s: %v\n", historyFilename(), err)
+	}
+}
+
+func historyCommand() {
+	registerVaultFlag()
+	n := 20
+	flag.IntVar(&n, "n", n, "Number of recent syncs to show (0 for all)")
+	flag.Parse()
+
+	history := loadHistory()
+	if n > 0 && len(history) > n {
+		history = history[len(history)-n:]
+	}
+	if jsonOutput {
+		dump(history)
+		return
+	}
+	if len(history) == 0 {
+		fmt.Println("no syncs recorded")
+		return
+	}
+	for _, rec := range history {
+		fmt.Printf("%s  %-7s %s", rec.At.Local().Format("2006-01-02 15:04:05"), rec.Status, rec.Server)
+		if rec.Status == "ok" {
+			fmt.Printf("  sent %d, added %d, updated %d, deleted %d", rec.Sent, rec.Added, rec.Updated, rec.Deleted)
+			if rec.Conflicts > 0 {
+				fmt.Printf(", %d conflicts", rec.Conflicts)
+			}
+			if rec.Rejected > 0 {
+				fmt.Printf(", %d rejected", rec.Rejected)
+			}
+			if skew := time.Duration(rec.ClockSkew) * time.Second; skewTooLarge(skew) {
+				fmt.Printf(", clock %s the server", describeSkew(skew))
+			}
+		} else {
+			fmt.Printf("  %s", rec.Error)
+		}
+		fmt.Println()
+	}
+}
+
+// sameProfile reports whether two versions of a profile agree, ignoring
+// the sync bookkeeping in ModifiedAt and SyncHashes.
+func sameProfile(a, b *Profile) bool {
+	x, y := *a, *b
+	x.ModifiedAt, y.ModifiedAt = nil, nil
+	x.SyncHashes, y.SyncHashes = nil, nil
+	rawX, errX := json.Marshal(&x)
+	rawY, errY := json.Marshal(&y)
+	return errX == nil && errY == nil && string(rawX) == string(rawY)
+}
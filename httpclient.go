@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 10
+	defaultHTTPTimeout    = 60
+	defaultHTTPRetries    = 3
+	firstRetryDelay       = 500 * time.Millisecond
+)
+
+var syncClient *http.Client
+
+// httpClient builds the client used to talk to the sync server from the
+// timeout, proxy (HTTP_PROXY, HTTPS_PROXY, NO_PROXY), CA bundle, and
+// certificate pin settings.
+func httpClient() *http.Client {
+	if syncClient != nil {
+		return syncClient
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(expandHome(config.CAFile))
+		if err != nil {
+			failf("Error reading ca_file: %v\n", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			failf("No certificates found in %s\n", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.PinSHA256 != "" {
+		tlsConfig.VerifyPeerCertificate = verifyPin(config.PinSHA256)
+	}
+	dialer := &net.Dialer{Timeout: time.Duration(config.ConnectTimeout) * time.Second}
+	syncClient = &http.Client{
+		Timeout: time.Duration(config.HTTPTimeout) * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         dialer.DialContext,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: dialer.Timeout,
+		},
+	}
+	return syncClient
+}
+
+// verifyPin requires some certificate in the server's chain to have the
+// given base64 SHA-256 hash of its public key (the same format as HPKP
+// and curl --pinnedpubkey), on top of normal verification.
+func verifyPin(pin string) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, chains [][]*x509.Certificate) error {
+		for _, der := range raw {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				continue
+			}
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if base64.StdEncoding.EncodeToString(sum[:]) == pin {
+				return nil
+			}
+		}
+		return fmt.Errorf("server certificate does not match pin_sha256")
+	}
+}
+
+// sendRequest makes an HTTP request to the sync server, retrying with
+// exponential backoff on network errors and 5xx responses. Any extra
+// header fields are added to each attempt. The caller closes the
+// response body.
+func sendRequest(method, url, contentType string, body []byte, header http.Header) (*http.Response, error) {
+	delay := firstRetryDelay
+	for attempt := 0; ; attempt++ {
+		r, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		r.Header.Set("Accept", "application/json")
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		resp, err := httpClient().Do(r)
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if attempt >= config.HTTPRetries {
+			return resp, err
+		}
+		if err != nil {
+			debugf("%s %s failed: %v; retrying in %v", method, url, err, delay)
+		} else {
+			debugf("%s %s returned %s; retrying in %v", method, url, resp.Status, delay)
+			resp.Body.Close()
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// gzipBytes compresses a request body. Responses need no help: the
+// transport asks for gzip and decompresses it transparently.
+func gzipBytes(raw []byte) []byte {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	w.Write(raw)
+	w.Close()
+	return buf.Bytes()
+}
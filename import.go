@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// importers parse another password manager's export into profiles
+// that generate the same passwords, keyed by the -format name.
+var importers = map[string]func(raw []byte) ([]*Profile, error){
+	"lesspass": importLessPass,
+}
+
+// importLessPass reads a LessPass export: a list of profiles, or the
+// API's {"results": [...]} wrapper around one.
+func importLessPass(raw []byte) ([]*Profile, error) {
+	var exports []*lessPassExport
+	if err := json.Unmarshal(raw, &exports); err != nil {
+		var wrapped struct {
+			Results []*lessPassExport `json:"results"`
+		}
+		if err2 := json.Unmarshal(raw, &wrapped); err2 != nil {
+			return nil, err
+		}
+		exports = wrapped.Results
+	}
+	var profiles []*Profile
+	for _, elt := range exports {
+		p, err := elt.profile()
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+func importCommand() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	format := ""
+	flag.StringVar(&format, "format", format, "Export format: lesspass")
+	dryRun := false
+	flag.BoolVar(&dryRun, "n", dryRun, "Show what would be imported without saving")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 1 {
+		exitf(exitUsage, "Usage: letmein import -format lesspass <file>\n")
+	}
+	parse, ok := importers[format]
+	if !ok {
+		exitf(exitUsage, "-format must be lesspass\n")
+	}
+	raw, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		failf("Error reading %s: %v\n", args[0], err)
+	}
+	imported, err := parse(raw)
+	if err != nil {
+		failf("Error parsing %s: %v\n", args[0], err)
+	}
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+
+	taken := func(name string) bool {
+		for _, elt := range client.Profiles {
+			if !elt.IsDeleted() && strings.EqualFold(elt.Name, name) {
+				return true
+			}
+		}
+		return false
+	}
+	var created []string
+	for _, p := range imported {
+		// sites with several logins need distinct names
+		if taken(p.Name) && p.Username != "" {
+			p.Name = fmt.Sprintf("%s (%s)", p.Name, p.Username)
+		}
+		if taken(p.Name) {
+			fmt.Fprintf(os.Stderr, "skipping %s: a profile with that name exists\n", p.Name)
+			continue
+		}
+		p.UUID = newUUID()
+		p.ModifiedAt = &now
+		if err := p.Validate(); err != nil {
+			failf("Cannot import %s: %v\n", p.Name, err)
+		}
+		fmt.Printf("imported: %s\n", p)
+		client.Profiles = append(client.Profiles, p)
+		created = append(created, p.UUID)
+	}
+	if dryRun {
+		return nil
+	}
+	recordOp("import", nil, created)
+	return client
+}
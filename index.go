@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Large JSON stores keep an index next to them with the searchable
+// fields of each profile and where its JSON sits in the file, so a
+// lookup can decode just the profiles it matches instead of all of
+// them. The index is only a cache: it is rebuilt on every save and
+// ignored whenever it does not match the store.
+
+// indexMinProfiles is the store size below which no index is kept,
+// since decoding a small store is already fast.
+const indexMinProfiles = 1000
+
+type storeIndex struct {
+	// Sum is the SHA-256 of the store the index describes
+	Sum string `json:"sum"`
+
+	// Start and End locate the profiles array in the store
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+
+	Profiles []*indexEntry `json:"profiles"`
+}
+
+type indexEntry struct {
+	UUID     string `json:"uuid"`
+	Name     string `json:"name"`
+	Username string `json:"username,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Folder   string `json:"folder,omitempty"`
+	Favorite bool   `json:"favorite,omitempty"`
+	Archived bool   `json:"archived,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+
+	// Offset and Length locate the profile's JSON in the store
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+func indexFile(path string) string {
+	return path + ".index"
+}
+
+// stub returns a profile with just the fields Query.Score looks at
+// (apart from those only searched with -any).
+func (e *indexEntry) stub() *Profile {
+	p := &Profile{
+		Name:     e.Name,
+		Username: e.Username,
+		URL:      e.URL,
+		Folder:   e.Folder,
+		Favorite: e.Favorite,
+		Archived: e.Archived,
+		Length:   1,
+	}
+	if e.Deleted {
+		p.Length = 0
+	}
+	return p
+}
+
+// writeIndex rebuilds the index for a store just written as raw. A
+// failure only costs speed, so it is logged rather than reported.
+func writeIndex(path string, raw []byte, client *Client) {
+	if len(client.Profiles) < indexMinProfiles {
+		if err := os.Remove(indexFile(path)); err != nil && !os.IsNotExist(err) {
+			debugf("removing %s: %v", indexFile(path), err)
+		}
+		return
+	}
+	index, err := buildIndex(raw, client)
+	if err == nil {
+		var out []byte
+		if out, err = json.Marshal(index); err == nil {
+			err = writeFileAtomic(indexFile(path), out, 0600)
+		}
+	}
+	if err != nil {
+		debugf("indexing %s: %v", path, err)
+		os.Remove(indexFile(path))
+		return
+	}
+	debugf("indexed %d profiles in %s", len(index.Profiles), indexFile(path))
+}
+
+// buildIndex finds each profile's JSON in raw, which must be client
+// as just encoded.
+func buildIndex(raw []byte, client *Client) (*storeIndex, error) {
+	sum := sha256.Sum256(raw)
+	index := &storeIndex{Sum: hex.EncodeToString(sum[:])}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("store is not a JSON object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if tok != "profiles" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return nil, fmt.Errorf("profiles is not a JSON array")
+		}
+		index.Start = dec.InputOffset() - 1
+		for dec.More() {
+			var elt json.RawMessage
+			if err := dec.Decode(&elt); err != nil {
+				return nil, err
+			}
+			i := len(index.Profiles)
+			if i >= len(client.Profiles) {
+				return nil, fmt.Errorf("more profiles in the store than in memory")
+			}
+			p := client.Profiles[i]
+			end := dec.InputOffset()
+			index.Profiles = append(index.Profiles, &indexEntry{
+				UUID:     p.UUID,
+				Name:     p.Name,
+				Username: p.Username,
+				URL:      p.URL,
+				Folder:   p.Folder,
+				Favorite: p.Favorite,
+				Archived: p.Archived,
+				Deleted:  p.IsDeleted(),
+				Offset:   end - int64(len(elt)),
+				Length:   int64(len(elt)),
+			})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		index.End = dec.InputOffset()
+	}
+	if len(index.Profiles) != len(client.Profiles) {
+		return nil, fmt.Errorf("found %d profiles in the store, expected %d", len(index.Profiles), len(client.Profiles))
+	}
+	return index, nil
+}
+
+// readIndexed reads the store at path with only the profiles that may
+// match q (none if q is nil), using the index. It returns nil if there
+// is no usable index, and the caller should read the whole store.
+func readIndexed(path string, q *Query) (*Client, error) {
+	if q != nil && q.Any {
+		return nil, nil
+	}
+	rawIndex, err := ioutil.ReadFile(indexFile(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	index := new(storeIndex)
+	if err := json.Unmarshal(rawIndex, index); err != nil {
+		debugf("ignoring %s: %v", indexFile(path), err)
+		return nil, nil
+	}
+	sum := sha256.Sum256(raw)
+	if index.Sum != hex.EncodeToString(sum[:]) {
+		debugf("ignoring %s: the store has changed since it was written", indexFile(path))
+		return nil, nil
+	}
+	size := int64(len(raw))
+	if index.Start < 0 || index.Start > index.End || index.End > size {
+		debugf("ignoring %s: it is damaged", indexFile(path))
+		return nil, nil
+	}
+	for _, e := range index.Profiles {
+		if e.Offset < index.Start || e.Length < 0 || e.Offset+e.Length > index.End {
+			debugf("ignoring %s: it is damaged", indexFile(path))
+			return nil, nil
+		}
+	}
+
+	// decode everything but the profiles, then only those that match
+	header := make([]byte, 0, len(raw)-int(index.End-index.Start)+2)
+	header = append(header, raw[:index.Start]...)
+	header = append(header, "[]"...)
+	header = append(header, raw[index.End:]...)
+	client, err := decodeClient(header)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	client.Profiles = []*Profile{}
+	for _, e := range index.Profiles {
+		if q == nil || q.Score(e.stub()) == 0 {
+			continue
+		}
+		p := new(Profile)
+		if err := json.Unmarshal(raw[e.Offset:e.Offset+e.Length], p); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", path, err)
+		}
+		client.Profiles = append(client.Profiles, p)
+	}
+	client.partial = true
+	debugf("read %d of %d profiles from %s using its index", len(client.Profiles), len(index.Profiles), path)
+	return client, nil
+}
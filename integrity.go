@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// integrityKey authenticates the whole client record. It is only known
+// once the master password has been checked; stores saved by commands
+// that run without it (like rename) carry the old checksum forward,
+// along with the sealed copies of whatever they changed, until the next
+// command that has it.
+var integrityKey []byte
+
+// acceptIntegrity lets doctor -reseal load a store that failed the
+// integrity check so it can be sealed again as it is.
+var acceptIntegrity bool
+
+// integrityPrefix marks checksums over the whole record. Older ones
+// cover only the account name and the profiles.
+const integrityPrefix = "v2:"
+
+func integrityKeyFor(master, account string) []byte {
+	return secretKey(master, "integrity\t"+account)
+}
+
+// integrityHeader is the canonical encoding of everything in the record
+// except the profiles, the store format, and the checksum itself.
+func integrityHeader(c *Client) []byte {
+	h := *c
+	h.Version = 0
+	h.Profiles = nil
+	h.Integrity, h.IntegrityBase, h.IntegrityHeader = "", nil, nil
+	raw, err := json.Marshal(&h)
+	if err != nil {
+		failf("Error encoding the store for the integrity check: %v\n", err)
+	}
+	return raw
+}
+
+// integrityMAC computes the store checksum over the header and the
+// profiles in UUID order, so reordering alone does not change it.
+func integrityMAC(key, header []byte, profiles []*Profile) string {
+	profiles = append([]*Profile{}, profiles...)
+	sort.SliceStable(profiles, func(i, j int) bool { return profiles[i].UUID < profiles[j].UUID })
+	raw, err := json.Marshal(struct {
+		Header   json.RawMessage `json:"header"`
+		Profiles []*Profile      `json:"profiles"`
+	}{header, profiles})
+	if err != nil {
+		failf("Error encoding profiles for the integrity check: %v\n", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(raw)
+	return integrityPrefix + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// legacyIntegrityMAC is the older checksum over the name and profiles.
+func legacyIntegrityMAC(key []byte, name string, profiles []*Profile) string {
+	profiles = append([]*Profile{}, profiles...)
+	sort.SliceStable(profiles, func(i, j int) bool { return profiles[i].UUID < profiles[j].UUID })
+	raw, err := json.Marshal(struct {
+		Name     string     `json:"name"`
+		Profiles []*Profile `json:"profiles"`
+	}{name, profiles})
+	if err != nil {
+		failf("Error encoding profiles for the integrity check: %v\n", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(raw)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sealedProfiles rebuilds the profile set the checksum was computed
+// over: the current profiles, with those changed since then swapped for
+// their sealed copies.
+func sealedProfiles(c *Client) []*Profile {
+	if len(c.IntegrityBase) == 0 {
+		return c.Profiles
+	}
+	var out []*Profile
+	for _, p := range c.Profiles {
+		if _, changed := c.IntegrityBase[p.UUID]; !changed {
+			out = append(out, p)
+		}
+	}
+	for _, p := range c.IntegrityBase {
+		if p != nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// integrityIntact reports whether a store's checksum matches. A store
+// with no checksum does not match.
+func integrityIntact(key []byte, c *Client) bool {
+	if c.Integrity == "" {
+		return false
+	}
+	profiles := sealedProfiles(c)
+	if !strings.HasPrefix(c.Integrity, integrityPrefix) {
+		return hmac.Equal([]byte(c.Integrity), []byte(legacyIntegrityMAC(key, c.Name, profiles)))
+	}
+	header := integrityHeader(c)
+	if c.IntegrityHeader != nil {
+		// the store is written indented, so undo that first
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, c.IntegrityHeader); err != nil {
+			return false
+		}
+		header = buf.Bytes()
+	}
+	return hmac.Equal([]byte(c.Integrity), []byte(integrityMAC(key, header, profiles)))
+}
+
+// checkIntegrity verifies the store checksum once the master password
+// is known. A store that was sealed, as recorded in the store and on
+// this device, and now has no checksum or a wrong one is refused until
+// doctor -reseal accepts it.
+func checkIntegrity(c *Client, master string) {
+	integrityKey = integrityKeyFor(master, c.Name)
+	sealed := c.Sealed || storeSealedHere()
+	switch {
+	case c.Integrity == "" && !sealed:
+		debugf("%s has no integrity checksum yet; one is added when it is next saved", filename)
+		return
+	case c.Integrity == "":
+		integrityFailed("has lost its integrity checksum: it was modified outside letmein or is corrupted")
+	case !integrityIntact(integrityKey, c):
+		integrityFailed("was modified outside letmein or is corrupted")
+	case len(c.IntegrityBase) > 0 || c.IntegrityHeader != nil:
+		fmt.Fprintf(os.Stderr, "Changed without the master password since the store was last checked: %s\n", strings.Join(changedWithoutMaster(c), ", "))
+		fmt.Fprintf(os.Stderr, "Check these profiles if you did not change them yourself.\n")
+	}
+	if !readOnly {
+		markStoreSealed()
+	}
+}
+
+func integrityFailed(what string) {
+	fmt.Fprintf(os.Stderr, "WARNING: %s %s.\n", filename, what)
+	fmt.Fprintf(os.Stderr, "WARNING: Check your profiles (letmein list -l) and backups (letmein restore) before trusting it.\n")
+	if !acceptIntegrity {
+		exitf(exitError, "Refusing to use %s; if you made these changes yourself, run letmein doctor -reseal to accept it as it is\n", filename)
+	}
+}
+
+// changedWithoutMaster names the profiles listed in IntegrityBase, and
+// the store settings if they changed too.
+func changedWithoutMaster(c *Client) []string {
+	var names []string
+	for uuid, old := range c.IntegrityBase {
+		name := uuid
+		if old != nil {
+			name = old.Name
+		}
+		for _, p := range c.Profiles {
+			if p.UUID == uuid {
+				name = p.Name
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if c.IntegrityHeader != nil {
+		names = append(names, "(store settings)")
+	}
+	return names
+}
+
+// snapshotIntegrity records the store as it was read, so a save without
+// the master password can tell what it changed.
+func snapshotIntegrity(c *Client) {
+	c.loadedHeader = integrityHeader(c)
+	c.loaded = make(map[string][]byte)
+	for _, p := range c.Profiles {
+		raw, err := json.Marshal(p)
+		if err != nil {
+			continue
+		}
+		c.loaded[p.UUID] = raw
+	}
+}
+
+// sealIntegrity sets the checksum before the store is written. Without
+// the key it keeps the old checksum and records the sealed copy of the
+// header and of each profile changed since the store was read.
+func sealIntegrity(c *Client) {
+	if integrityKey != nil {
+		c.Sealed = true
+		c.IntegrityBase, c.IntegrityHeader = nil, nil
+		c.Integrity = integrityMAC(integrityKey, integrityHeader(c), c.Profiles)
+		return
+	}
+	if c.Integrity == "" || c.loaded == nil {
+		return
+	}
+	if c.IntegrityHeader == nil && strings.HasPrefix(c.Integrity, integrityPrefix) && !bytes.Equal(integrityHeader(c), c.loadedHeader) {
+		c.IntegrityHeader = c.loadedHeader
+	}
+	base := c.IntegrityBase
+	if base == nil {
+		base = make(map[string]*Profile)
+	}
+	keep := func(uuid string) {
+		if _, done := base[uuid]; done {
+			return
+		}
+		var old *Profile
+		if raw, ok := c.loaded[uuid]; ok {
+			old = new(Profile)
+			if err := json.Unmarshal(raw, old); err != nil {
+				failf("Error decoding profile %s for the integrity check: %v\n", uuid, err)
+			}
+		}
+		base[uuid] = old
+	}
+	present := make(map[string]bool)
+	for _, p := range c.Profiles {
+		present[p.UUID] = true
+		raw, err := json.Marshal(p)
+		if err != nil {
+			failf("Error encoding profiles for the integrity check: %v\n", err)
+		}
+		if old, ok := c.loaded[p.UUID]; !ok || string(old) != string(raw) {
+			keep(p.UUID)
+		}
+	}
+	for uuid := range c.loaded {
+		if !present[uuid] {
+			keep(uuid)
+		}
+	}
+	if len(base) > 0 {
+		c.IntegrityBase = base
+	}
+}
+
+// sealedStoresFilename lists the stores this device has seen sealed.
+// It lives with the config rather than next to the store, so editing
+// the store alone cannot make a sealed store look like one that never
+// had a checksum.
+func sealedStoresFilename() string {
+	return filepath.Join(configDir(), "sealed-stores")
+}
+
+func storeSealedHere() bool {
+	raw, err := ioutil.ReadFile(sealedStoresFilename())
+	if err != nil {
+		return false
+	}
+	path, _ := filepath.Abs(filename)
+	for _, line := range strings.Split(string(raw), "\n") {
+		if line == path {
+			return true
+		}
+	}
+	return false
+}
+
+// markStoreSealed records that the store has a checksum. Failing to is
+// not fatal: the flag in the store still covers the common case.
+func markStoreSealed() {
+	if storeSealedHere() {
+		return
+	}
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return
+	}
+	raw, _ := ioutil.ReadFile(sealedStoresFilename())
+	raw = append(raw, path+"\n"...)
+	if err := os.MkdirAll(configDir(), 0700); err != nil {
+		debugf("cannot record %s as sealed: %v", filename, err)
+		return
+	}
+	if err := writeFileAtomic(sealedStoresFilename(), raw, 0600); err != nil {
+		debugf("cannot record %s as sealed: %v", filename, err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// maxJournal is the number of operations that can be undone.
+const maxJournal = 20
+
+// JournalEntry records enough about one operation to revert it.
+type JournalEntry struct {
+	Op string    `json:"op"`
+	At time.Time `json:"at"`
+
+	// Before holds copies of profiles as they were before the operation.
+	Before []*Profile `json:"before,omitempty"`
+
+	// Created lists UUIDs of profiles the operation added.
+	Created []string `json:"created,omitempty"`
+
+	// Snapshot holds the whole client record for operations (like sync)
+	// that touch too much to track profile-by-profile.
+	Snapshot *Client `json:"snapshot,omitempty"`
+}
+
+// pendingOp is written to the journal when the client is saved.
+var pendingOp *JournalEntry
+
+func journalFilename() string {
+	return filename + ".journal"
+}
+
+// recordOp notes the current operation so it can be undone later.
+func recordOp(op string, before []*Profile, created []string) {
+	pendingOp = &JournalEntry{
+		Op:      op,
+		At:      time.Now().Round(time.Millisecond),
+		Before:  before,
+		Created: created,
+	}
+}
+
+// recordSnapshot notes the current operation along with a copy of the
+// entire client record as it stands now.
+func recordSnapshot(op string, client *Client) {
+	raw, err := json.Marshal(client)
+	if err != nil {
+		failf("Error encoding journal snapshot: %v\n", err)
+	}
+	snapshot := new(Client)
+	if err := json.Unmarshal(raw, snapshot); err != nil {
+		failf("Error decoding journal snapshot: %v\n", err)
+	}
+	recordOp(op, nil, nil)
+	pendingOp.Snapshot = snapshot
+}
+
+func loadJournal() []*JournalEntry {
+	raw, err := ioutil.ReadFile(journalFilename())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		failf("Error reading %s: %v\n", journalFilename(), err)
+	}
+	var journal []*JournalEntry
+	if err := json.Unmarshal(raw, &journal); err != nil {
+		failf("Error parsing %s: %v\n", journalFilename(), err)
+	}
+	return journal
+}
+
+func saveJournal(journal []*JournalEntry) {
+	if len(journal) > maxJournal {
+		journal = journal[len(journal)-maxJournal:]
+	}
+	raw, err := json.MarshalIndent(journal, "", "    ")
+	if err != nil {
+		failf("Error encoding %s: %v\n", journalFilename(), err)
+	}
+	raw = append(raw, '\n')
+	if err := writeFileAtomic(journalFilename(), raw, 0600); err != nil {
+		failf("Error writing %s: %v\n", journalFilename(), err)
+	}
+}
+
+// commitJournal appends the pending operation (if any) to the journal.
+func commitJournal() {
+	if pendingOp == nil {
+		return
+	}
+	saveJournal(append(loadJournal(), pendingOp))
+	pendingOp = nil
+}
+
+func undoOp() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	registerVaultFlag()
+	flag.Parse()
+	client := loadClient()
+
+	journal := loadJournal()
+	if len(journal) == 0 {
+		failf("Nothing to undo\n")
+	}
+	entry := journal[len(journal)-1]
+
+	if entry.Snapshot != nil {
+		// restore the whole record, keeping the identity of this client
+		entry.Snapshot.Name = client.Name
+		entry.Snapshot.Verify = client.Verify
+		entry.Snapshot.Verifier = client.Verifier
+		entry.Snapshot.NoVerify = client.NoVerify
+		entry.Snapshot.FIDO2 = client.FIDO2
+		entry.Snapshot.DeviceID = client.DeviceID
+		entry.Snapshot.DeviceName = client.DeviceName
+		client = entry.Snapshot
+	}
+
+	byuuid := make(map[string]*Profile)
+	for _, elt := range client.Profiles {
+		byuuid[elt.UUID] = elt
+	}
+
+	// delete created profiles, leaving a tombstone to sync
+	for _, uuid := range entry.Created {
+		if p, exists := byuuid[uuid]; exists {
+			p.Length = 0
+			p.ModifiedAt = &now
+			p.DeletedAt = &now
+			if err := p.Validate(); err != nil {
+				failf("Error deleting profile %s: %v\n", uuid, err)
+			}
+		}
+	}
+
+	// restore earlier versions, including profiles that were tombstoned
+	for _, elt := range entry.Before {
+		elt.ModifiedAt = &now
+		if p, exists := byuuid[elt.UUID]; exists {
+			*p = *elt
+		} else {
+			client.Profiles = append(client.Profiles, elt)
+		}
+	}
+
+	saveJournal(journal[:len(journal)-1])
+	fmt.Printf("undid %s from %s\n", entry.Op, entry.At.Local().Format(time.RFC1123))
+
+	return client
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// schemeLessPass generates the same passwords as LessPass version 2, so
+// LessPass users can move to letmein without changing passwords. The
+// site is the profile's URL, the login its username, and the counter
+// its generation.
+const schemeLessPass = "lesspass-v2"
+
+const (
+	lessPassIterations = 100000
+	lessPassKeyLength  = 32
+	lessPassMinLength  = 5
+	lessPassMaxLength  = 35
+
+	lessPassLower   = "abcdefghijklmnopqrstuvwxyz"
+	lessPassUpper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	lessPassDigits  = "0123456789"
+	lessPassSymbols = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+)
+
+func init() {
+	registerScheme(schemeLessPass, func(string) (Scheme, error) {
+		return lessPassScheme{}, nil
+	})
+}
+
+type lessPassScheme struct{}
+
+// rules lists the character classes a profile uses, in LessPass order.
+func (lessPassScheme) rules(p *Profile) []string {
+	var rules []string
+	if p.Lower {
+		rules = append(rules, lessPassLower)
+	}
+	if p.Upper {
+		rules = append(rules, lessPassUpper)
+	}
+	if p.Digits {
+		rules = append(rules, lessPassDigits)
+	}
+	if p.Punctuation {
+		rules = append(rules, lessPassSymbols)
+	}
+	return rules
+}
+
+// Password follows LessPass's renderPassword: the PBKDF2 output is one
+// big number that is divided down to pick the characters, then one
+// character from each class, then where to insert each of those. The
+// characters are inserted in place, so the password is never copied.
+func (s lessPassScheme) Password(p *Profile, master []byte) ([]byte, error) {
+	salt := p.URL + p.Username + strconv.FormatInt(int64(p.Generation), 16)
+	key := pbkdf2.Key(master, []byte(salt), lessPassIterations, lessPassKeyLength, sha256.New)
+	entropy := new(big.Int).SetBytes(key)
+	wipe(key)
+
+	rules := s.rules(p)
+	all := ""
+	for _, elt := range rules {
+		all += elt
+	}
+	password := lessPassConsume(make([]byte, 0, p.Length), entropy, all, p.Length-len(rules))
+	extra := make([]byte, 0, len(rules))
+	for _, elt := range rules {
+		extra = lessPassConsume(extra, entropy, elt, 1)
+	}
+	defer wipe(extra)
+	for _, c := range extra {
+		rem := new(big.Int)
+		entropy.QuoRem(entropy, big.NewInt(int64(len(password))), rem)
+		i := int(rem.Int64())
+		password = password[:len(password)+1]
+		copy(password[i+1:], password[i:])
+		password[i] = c
+	}
+	return password, nil
+}
+
+// lessPassConsume appends n characters from chars to out, dividing
+// entropy down as it goes.
+func lessPassConsume(out []byte, entropy *big.Int, chars string, n int) []byte {
+	size := big.NewInt(int64(len(chars)))
+	rem := new(big.Int)
+	for i := 0; i < n; i++ {
+		entropy.QuoRem(entropy, size, rem)
+		out = append(out, chars[rem.Int64()])
+	}
+	return out
+}
+
+func (lessPassScheme) Key(p *Profile, master []byte, purpose string, n int) ([]byte, error) {
+	return nil, errors.New("LessPass profiles cannot derive keys")
+}
+
+// exactInputs reports that the site and login must be used as typed,
+// since LessPass does not normalize them.
+func (lessPassScheme) exactInputs() bool {
+	return true
+}
+
+func (lessPassScheme) setDefaults(p *Profile) {
+	if p.Generation == 0 {
+		p.Generation = 1
+	}
+}
+
+func (s lessPassScheme) checkProfile(p *Profile) error {
+	switch {
+	case p.Generation < 1 || p.Generation > maxGeneration:
+		return fmt.Errorf("LessPass counter (generation) must be between 1 and %d", maxGeneration)
+	case p.Length < lessPassMinLength || p.Length > lessPassMaxLength:
+		return fmt.Errorf("LessPass length must be between %d and %d", lessPassMinLength, lessPassMaxLength)
+	case p.Spaces || p.Latin1 || p.Include != "" || p.Exclude != "":
+		return fmt.Errorf("LessPass profiles only support lower, upper, digits, and punctuation")
+	case len(s.rules(p)) == 0:
+		return fmt.Errorf("LessPass profiles need at least one character class")
+	}
+	return nil
+}
+
+// lessPassExport is one profile in a LessPass JSON export.
+type lessPassExport struct {
+	Site      string `json:"site"`
+	Login     string `json:"login"`
+	Lowercase bool   `json:"lowercase"`
+	Uppercase bool   `json:"uppercase"`
+	Digits    bool   `json:"digits"`
+	Symbols   bool   `json:"symbols"`
+	Length    int    `json:"length"`
+	Counter   int    `json:"counter"`
+	Version   int    `json:"version"`
+}
+
+func (e *lessPassExport) profile() (*Profile, error) {
+	if e.Version != 0 && e.Version != 2 {
+		return nil, fmt.Errorf("%s: only LessPass version 2 profiles can be imported", e.Site)
+	}
+	return &Profile{
+		Scheme:      schemeLessPass,
+		Name:        e.Site,
+		Username:    e.Login,
+		URL:         e.Site,
+		Generation:  e.Counter,
+		Length:      e.Length,
+		Lower:       e.Lowercase,
+		Upper:       e.Uppercase,
+		Digits:      e.Digits,
+		Punctuation: e.Symbols,
+	}, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/term"
+	"golang.org/x/text/width"
+)
+
+// ANSI escapes used to highlight search matches.
+const (
+	colorMatch = "\x1b[1;33m"
+	colorReset = "\x1b[0m"
+)
+
+// noColor disables highlighting in list output.
+var noColor bool
+
+func useColor() bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// lastChanged is the most recent time anything in a profile changed,
+// or the zero time if that was never recorded.
+func lastChanged(p *Profile) time.Time {
+	var t time.Time
+	if p.ModifiedAt != nil {
+		t = *p.ModifiedAt
+	}
+	for _, elt := range p.FieldTimes {
+		if elt != nil && elt.After(t) {
+			t = *elt
+		}
+	}
+	return t
+}
+
+// sortProfiles orders profiles by name, url, folder, or most recently
+// modified.
+// An empty order keeps the search ranking.
+func sortProfiles(profiles []*Profile, order string) error {
+	var less func(a, b *Profile) bool
+	switch order {
+	case "":
+		return nil
+	case "name":
+		less = func(a, b *Profile) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case "url":
+		less = func(a, b *Profile) bool { return a.URL < b.URL }
+	case "folder":
+		less = func(a, b *Profile) bool { return strings.ToLower(a.Folder) < strings.ToLower(b.Folder) }
+	case "modified":
+		less = func(a, b *Profile) bool { return lastChanged(a).After(lastChanged(b)) }
+	default:
+		return fmt.Errorf("sort order must be name, url, folder, or modified")
+	}
+	sort.SliceStable(profiles, func(i, j int) bool { return less(profiles[i], profiles[j]) })
+	return nil
+}
+
+// listRow is the data available to list -format templates.
+type listRow struct {
+	*Profile
+	Password string
+}
+
+func parseListFormat(format string) *template.Template {
+	t, err := template.New("format").Parse(format)
+	if err != nil {
+		exitf(exitUsage, "Invalid -format: %v\n", err)
+	}
+	return t
+}
+
+// printListTemplate prints one line per profile using a template.
+func printListTemplate(t *template.Template, profiles []*Profile, passwords []string) {
+	for i, p := range profiles {
+		row := listRow{Profile: p}
+		if passwords != nil {
+			row.Password = passwords[i]
+		}
+		if err := t.Execute(os.Stdout, row); err != nil {
+			failf("Error formatting %s: %v\n", p.Name, err)
+		}
+		fmt.Println()
+	}
+}
+
+// listColumns gives the table cells for one profile. The long form
+// adds when it last changed and whether sync still has to upload it.
+func listColumns(p *Profile, long bool) []string {
+	var row []string
+	if p.IsDeleted() {
+		row = []string{"[deleted " + p.UUID + "]", "", "", "", ""}
+	} else {
+		name := p.Name
+		if p.ModifiedAt != nil && !long {
+			name = "*" + name
+		}
+		gen := strconv.Itoa(p.Generation)
+		if p.Scheme == schemeStored {
+			gen = "stored"
+		}
+		row = []string{name, p.Username, p.URL, gen, strconv.Itoa(p.Length)}
+	}
+	if !long {
+		return row
+	}
+	modified := "-"
+	if t := lastChanged(p); !t.IsZero() {
+		modified = t.Local().Format("2006-01-02 15:04")
+	}
+	if p.IsDeleted() && p.DeletedAt != nil {
+		modified = p.DeletedAt.Local().Format("2006-01-02 15:04")
+	}
+	status := "synced"
+	if p.ModifiedAt != nil {
+		status = "unsynced"
+	}
+	return append(row, modified, status)
+}
+
+var (
+	listHeaders     = []string{"NAME", "USERNAME", "URL", "GEN", "LEN"}
+	listLongHeaders = []string{"MODIFIED", "SYNC"}
+)
+
+// printLastSync reports when the store last synced, for list -l.
+func printLastSync(client *Client) {
+	history := loadHistory()
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Status == "ok" {
+			fmt.Printf("last sync: %s with %s\n", history[i].At.Local().Format(time.Stamp), history[i].Server)
+			return
+		}
+	}
+	if client.PreviousSyncAt != nil {
+		fmt.Printf("last sync: %s\n", client.PreviousSyncAt.Local().Format(time.Stamp))
+		return
+	}
+	fmt.Printf("last sync: never\n")
+}
+
+// listTable lays profiles out in aligned columns, highlighting the
+// parts of the name, username, and URL that matched the query. The
+// extra function, if given, is called after each row (for QR codes).
+func listTable(profiles []*Profile, passwords []string, query *Query, long bool, extra func(i int)) {
+	headers := listHeaders
+	if long {
+		headers = append(append([]string{}, headers...), listLongHeaders...)
+	}
+	if passwords != nil {
+		headers = append(append([]string{}, headers...), "PASSWORD")
+	}
+	rows := [][]string{}
+	for i, p := range profiles {
+		row := listColumns(p, long)
+		if passwords != nil {
+			row = append(row, passwords[i])
+		}
+		rows = append(rows, row)
+	}
+	widths := make([]int, len(headers))
+	for _, row := range append([][]string{headers}, rows...) {
+		for j, cell := range row {
+			if n := displayWidth(cell); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+
+	color := useColor()
+	printRow := func(row []string, highlight bool) {
+		var line strings.Builder
+		line.WriteString("    ")
+		for j, cell := range row {
+			padding := strings.Repeat(" ", widths[j]-displayWidth(cell))
+			if j == len(row)-1 {
+				padding = ""
+			}
+			if highlight && color && j < 3 {
+				cell = highlightMatch(cell, query)
+			}
+			line.WriteString(cell + padding)
+			if j < len(row)-1 {
+				line.WriteString("  ")
+			}
+		}
+		fmt.Println(strings.TrimRight(line.String(), " "))
+	}
+	printRow(headers, false)
+	for i, row := range rows {
+		printRow(row, true)
+		if extra != nil {
+			extra(i)
+		}
+	}
+}
+
+// displayWidth is the number of terminal columns text takes up, counting
+// East Asian wide characters as two.
+func displayWidth(text string) int {
+	n := 0
+	for _, r := range text {
+		switch width.LookupRune(r).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			n += 2
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// highlightMatch colors the part of text that matches the query.
+func highlightMatch(text string, query *Query) string {
+	start, end := query.matchSpan(text)
+	if start < 0 {
+		return text
+	}
+	return text[:start] + colorMatch + text[start:end] + colorReset + text[end:]
+}
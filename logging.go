@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Log levels: info messages are always shown, debug messages with -v,
+// and trace messages (including redacted sync payloads) with -vv.
+const (
+	levelInfo = iota
+	levelDebug
+	levelTrace
+)
+
+var (
+	logLevel = levelInfo
+	logFile  string
+	logger   = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// setupLogging applies -v, -vv, -log, and LETMEIN_DEBUG. LETMEIN_DEBUG
+// holds a level number; any other non-empty value means trace.
+func setupLogging(verbose, veryVerbose bool) {
+	if s := os.Getenv("LETMEIN_DEBUG"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil {
+			logLevel = n
+		} else {
+			logLevel = levelTrace
+		}
+	}
+	if verbose && logLevel < levelDebug {
+		logLevel = levelDebug
+	}
+	if veryVerbose {
+		logLevel = levelTrace
+	}
+	if logFile != "" {
+		f, err := os.OpenFile(expandHome(logFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			failf("Error opening log file: %v\n", err)
+		}
+		logger.SetOutput(f)
+	}
+}
+
+func logAt(level int, f string, args ...interface{}) {
+	if logLevel >= level {
+		logger.Output(3, fmt.Sprintf(f, args...))
+	}
+}
+
+func infof(f string, args ...interface{})  { logAt(levelInfo, f, args...) }
+func debugf(f string, args ...interface{}) { logAt(levelDebug, f, args...) }
+func tracef(f string, args ...interface{}) { logAt(levelTrace, f, args...) }
+
+// timed logs how long a step took at debug level: defer timed("x")()
+func timed(what string) func() {
+	start := time.Now()
+	return func() {
+		debugf("%s took %v", what, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+var (
+	sealedValue = regexp.MustCompile(`"` + sealedPrefix + `[^"]*"`)
+	verifyValue = regexp.MustCompile(`("verify":\s*)"[^"]*"`)
+)
+
+// traceJSON logs a value as JSON at trace level, with sealed secrets
+// and the verification code redacted so logs are safe to share.
+func traceJSON(label string, v interface{}) {
+	if logLevel < levelTrace {
+		return
+	}
+	raw, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		tracef("%s: %v", label, err)
+		return
+	}
+	raw = sealedValue.ReplaceAll(raw, []byte(`"[sealed]"`))
+	raw = verifyValue.ReplaceAll(raw, []byte(`$1"[redacted]"`))
+	logger.Output(2, fmt.Sprintf("%s:\n%s", label, raw))
+}
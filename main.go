@@ -1,35 +1,87 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"text/template"
 	"time"
-
-	"github.com/howeyc/gopass"
 )
 
-var filename = filepath.Join(os.Getenv("HOME"), ".letmeinrc")
+var filename = defaultVaultPath()
 var never = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
 
-const defaultServer = "https://letmein-app.appspot.com"
+const (
+	defaultServer           = "https://letmein-app.appspot.com"
+	defaultClipboardTimeout = 45
+	defaultBackups          = 10
+	defaultTombstoneDays    = 30
+)
 
 type Client struct {
-	Name     string     `json:"name"`
-	Verify   string     `json:"verify"`
-	Profiles []*Profile `json:"profiles,omitempty"`
+	// Version is the store format; see schema.go
+	Version int `json:"version,omitempty"`
+
+	Name     string      `json:"name"`
+	Verify   string      `json:"verify,omitempty"`
+	Verifier *Verifier   `json:"verifier,omitempty"`
+	NoVerify bool        `json:"no_verify,omitempty"`
+	FIDO2    *FIDO2Token `json:"fido2,omitempty"`
+	Profiles []*Profile  `json:"profiles,omitempty"`
+
+	// Integrity is a checksum of the profiles keyed by the master
+	// password; see integrity.go
+	Integrity string `json:"integrity,omitempty"`
+
+	// Sealed is set once the store has had a checksum, so losing it is
+	// noticed. IntegrityBase and IntegrityHeader hold the sealed copies
+	// of profiles (null for new ones) and of the rest of the record
+	// changed by commands run without the master password
+	Sealed          bool                `json:"sealed,omitempty"`
+	IntegrityBase   map[string]*Profile `json:"integrity_base,omitempty"`
+	IntegrityHeader json.RawMessage     `json:"integrity_header,omitempty"`
+
+	// Shared lists the shared vaults this account belongs to, and
+	// Contacts the confirmed public keys of other members by account;
+	// contacts stay on this device
+	Shared   []*SharedVault    `json:"shared,omitempty"`
+	Contacts map[string]string `json:"contacts,omitempty"`
 
 	SyncedAt       *time.Time `json:"synced_at,omitempty"`
 	PreviousSyncAt *time.Time `json:"previous_sync_at,omitempty"`
 
+	// Hashes is only sent on sync: the content hash of every profile
+	// here, so a server can return those whose copy differs from its
+	// own without relying on timestamps
+	Hashes map[string]string `json:"hashes,omitempty"`
+
+	// AccountSecret is the sealed secret that proves to the server this
+	// account owns its name; see claim.go
+	AccountSecret string `json:"account_secret,omitempty"`
+
+	// Full is only sent on sync: it asks the server for every profile,
+	// whatever the cursor says
+	Full bool `json:"full,omitempty"`
+
+	// DeviceID identifies this copy of the vault to the server
+	DeviceID   string `json:"device_id,omitempty"`
+	DeviceName string `json:"device_name,omitempty"`
+
 	Master string `json:"-"`
+
+	// partial is set when only some profiles were read; see readMatches
+	partial bool
+
+	// loaded and loadedHeader hold the store as read; see
+	// snapshotIntegrity
+	loaded       map[string][]byte
+	loadedHeader []byte
 }
 
 func (c *Client) Matches(search string) []*Profile {
@@ -43,7 +95,8 @@ func (c *Client) Matches(search string) []*Profile {
 }
 
 // VerifyProfile is a simple profile that generates a verification code for the master password.
-// This can be used to catch typos when entering the master password.
+// It is only kept to migrate old vaults and to identify the account to the sync server;
+// vaults now store a salted Verifier instead.
 var VerifyProfile = &Profile{
 	Username:    "verify",
 	URL:         "",
@@ -59,68 +112,52 @@ var VerifyProfile = &Profile{
 }
 
 func main() {
-	// check which subcommand is requested
-	cmd := ""
-	if len(os.Args) >= 2 {
-		cmd = os.Args[1]
+	if runtime.GOOS == "js" {
+		browserMain()
+		return
+	}
+	if isNativeMessagingLaunch(os.Args[1:]) {
+		// started directly by a browser
+		os.Args = []string{os.Args[0], "native-host"}
 	}
-	var client *Client
-	modified := false
-
-	switch cmd {
-	case "create":
-		os.Args = os.Args[1:]
-		client = createProfile()
-		modified = true
-	case "delete":
-		os.Args = os.Args[1:]
-		client = deleteProfile()
-		modified = true
-	case "list":
-		os.Args = os.Args[1:]
-		client = listProfiles()
-	case "sync":
-		os.Args = os.Args[1:]
-		client = syncProfiles()
-		modified = true
-	case "update":
-		os.Args = os.Args[1:]
-		client = updateProfile()
-		modified = true
-	case "init":
-		os.Args = os.Args[1:]
-		client = initProfile()
-		modified = true
-	default:
-		fmt.Fprint(os.Stderr, `letmein is a password generator
-
-Usage:
-
-        letmein command [arguments] <searchterm>
-
-The commands are:
-
-    init        create a new client instance
-    list        list all matching profiles with passwords
-    create      create a new profile
-    update      update an existing profile
-    delete      delete a profile
-    sync        sync profiles with server
 
-Use "letmein command -help" for more information about a command.
-`)
+	// global options come before the command
+	fs := globalFlags()
+	fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		usage(os.Stderr)
+		os.Exit(exitUsage)
 	}
+	cmd := findCommand(fs.Arg(0))
+	if cmd == nil {
+		unknownCommand(fs.Arg(0))
+	}
+	os.Args = fs.Args()
+	setupLogging(verbose, veryVerbose)
+	auditCommand = cmd.name
+	defer timed("letmein " + cmd.name)()
+
+	// keep secrets out of core dumps
+	hardenProcess()
+	setupConsole()
+
+	// load user defaults
+	config = loadConfig()
+	applyConfig(config)
+	if globalVault != "" {
+		filename = expandHome(globalVault)
+	}
+	checkWritable(cmd, os.Args)
 
-	if client != nil && modified {
-		raw, err := json.MarshalIndent(client, "", "    ")
-		if err != nil {
-			failf("Error encoding %s: %v\n", filename, err)
-		}
-		raw = append(raw, '\n')
-		if err = ioutil.WriteFile(filename, raw, 0600); err != nil {
-			failf("Error writing %s: %v\n", filename, err)
-		}
+	client := cmd.run()
+	if client != nil && cmd.saves {
+		saveClient(client)
 	}
+
+	// other commands need not wait while the clipboard waits to be cleared
+	unlockStore()
+	finishCopy()
+	wipeSecrets()
 }
 
 func createProfile() *Client {
@@ -129,11 +166,51 @@ func createProfile() *Client {
 	// gather options
 	var master string
 	registerMasterFlag(&master)
+	registerVaultFlag()
 	p := new(Profile)
 	registerProfileFlags(p)
+	fields := make(fieldFlag)
+	flag.Var(fields, "field", "Custom field as key=value (repeatable)")
+	stored := false
+	secret := ""
+	flag.BoolVar(&stored, "stored", stored, "Store a password that cannot be generated")
+	flag.StringVar(&secret, "secret", secret, "Password to store with -stored (prompted if omitted)")
+	template := ""
+	flag.StringVar(&template, "template", template, "Start from a template defined in the config file")
+	schemeName := ""
+	flag.StringVar(&schemeName, "scheme", schemeName, "Password scheme: scrypt (the default), scrypt-v2, scrypt-v3, lesspass-v2, or spectre-v3(TEMPLATE,FULL NAME)")
+	wizard := false
+	flag.BoolVar(&wizard, "i", wizard, "Ask for each setting interactively (the default with no options)")
+	registerAliasFlag()
+	registerRevealFlags()
 	flag.Parse()
+
+	// with no options at all, walk the user through it
+	if flag.NFlag() == 0 && flag.NArg() == 0 && interactive() {
+		wizard = true
+	}
+	if template != "" {
+		settings, ok := config.Templates[template]
+		if !ok {
+			failf("No template named %s in %s\n", template, configFilename)
+		}
+
+		// options given on the command line override the template
+		given := *p
+		if err := applyTemplate(p, settings); err != nil {
+			failf("Template %s: %v\n", template, err)
+		}
+		applyProfileFlags(p, &given)
+	}
+	if noAmbiguous {
+		p.Exclude += ambiguousChars
+	}
 	master = getAndVerifyMaster(master)
 	client := getClient(now, master)
+	if wizard {
+		createWizard(client, p, master)
+	}
+	applyAlias(p, master, client.Name, true)
 
 	// see if this profile already exists
 	if matches := client.Matches(p.Name); len(matches) != 0 {
@@ -145,16 +222,30 @@ func createProfile() *Client {
 	}
 
 	p.UUID = newUUID()
-	p.Scheme = schemeScrypt
+	if err := newProfileScheme(p, schemeName); err != nil {
+		failf("%v\n", err)
+	}
 	p.ModifiedAt = &now
+	applyFields(p, fields, secretKey(master, client.Name))
+	if stored {
+		setStoredSecret(p, master, getSecret(secret))
+	} else if secret != "" {
+		failf("-secret can only be used with -stored\n")
+	}
 
 	// validate the new profile
 	if err := p.Validate(); err != nil {
 		failf("invalid profile: %v\n", err)
 	}
+	if err := p.checkMaxLength(0); err != nil {
+		failf("invalid profile: %v\n", err)
+	}
 
-	fmt.Printf("profile created: %s --> %s\n", p, p.Generate(master))
+	password := p.Generate(master)
+	fmt.Printf("profile created: %s --> %s\n", p, maskPassword(password))
+	revealPassword(password)
 	client.Profiles = append(client.Profiles, p)
+	recordOp("create", nil, []string{p.UUID})
 
 	return client
 }
@@ -165,8 +256,20 @@ func updateProfile() *Client {
 	// gather options
 	var master string
 	registerMasterFlag(&master)
+	registerVaultFlag()
 	p := new(Profile)
 	registerProfileFlags(p)
+	fields := make(fieldFlag)
+	flag.Var(fields, "field", "Custom field as key=value (repeatable; empty value removes)")
+	secret := ""
+	flag.StringVar(&secret, "secret", secret, "New password for a stored-password profile")
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	force := false
+	registerForceFlag(&force)
+	registerAliasFlag()
+	registerRevealFlags()
 	flag.Parse()
 	master = getAndVerifyMaster(master)
 	client := getClient(now, master)
@@ -178,55 +281,47 @@ func updateProfile() *Client {
 	}
 
 	// find the matching profile
-	matches := client.Matches(args[0])
-	if len(matches) > 1 {
-		fmt.Printf("Profile matches:\n")
-		for _, elt := range matches {
-			fmt.Printf("    %s\n", elt)
-		}
-		failf("Cannot update profile without a unique match\n")
-	}
-	if len(matches) == 0 {
-		failf("No matching profile found\n")
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
 	}
-
-	q := matches[0]
+	q := chooseProfile(client.Search(query), args[0], "update")
+	before := *q
+	before.Fields = copyFields(q.Fields)
+	applyProfileFlags(q, p)
+	applyAlias(q, master, client.Name, false)
 	flag.Visit(func(f *flag.Flag) {
 		switch f.Name {
-		case "name":
-			q.Name = p.Name
-		case "username":
-			q.Username = p.Username
-		case "url":
-			q.URL = p.URL
-		case "generation":
-			q.Generation = p.Generation
-		case "length":
-			q.Length = p.Length
-		case "lower":
-			q.Lower = p.Lower
-		case "upper":
-			q.Upper = p.Upper
-		case "digits":
-			q.Digits = p.Digits
-		case "punctuation":
-			q.Punctuation = p.Punctuation
-		case "spaces":
-			q.Spaces = p.Spaces
-		case "include":
-			q.Include = p.Include
-		case "exclude":
-			q.Exclude = p.Exclude
+		case "secret":
+			if q.Scheme != schemeStored {
+				failf("-secret can only be used with stored-password profiles\n")
+			}
+			setStoredSecret(q, master, secret)
+		}
+		if q.Scheme == schemeStored && derivationFlags[f.Name] {
+			failf("-%s does not apply to stored-password profiles\n", f.Name)
 		}
 	})
+	applyFields(q, fields, secretKey(master, client.Name))
 	q.ModifiedAt = &now
 
 	// validate the updated profile
 	if err := q.Validate(); err != nil {
 		failf("updated profile is invalid, canceling: %v\n", err)
 	}
+	if err := q.checkMaxLength(before.Length); err != nil {
+		failf("updated profile is invalid, canceling: %v\n", err)
+	}
+	if !force {
+		fmt.Printf("    %s\n -> %s\n", &before, q)
+		confirm(force, "Update this profile?")
+	}
 
-	fmt.Printf("profile updated: %s --> %s\n", q, q.Generate(master))
+	password := q.Generate(master)
+	fmt.Printf("profile updated: %s --> %s\n", q, maskPassword(password))
+	revealPassword(password)
+	stampChanges(&before, q, now)
+	recordOp("update", []*Profile{&before}, nil)
 
 	return client
 }
@@ -237,8 +332,14 @@ func deleteProfile() *Client {
 	// gather options
 	var master string
 	registerMasterFlag(&master)
+	registerVaultFlag()
 	p := new(Profile)
 	registerProfileFlags(p)
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	force := false
+	registerForceFlag(&force)
 	flag.Parse()
 	master = getAndVerifyMaster(master)
 	client := getClient(now, master)
@@ -250,28 +351,28 @@ func deleteProfile() *Client {
 	}
 
 	// find the matching profile
-	matches := client.Matches(args[0])
-	if len(matches) > 1 {
-		fmt.Printf("Profile matches:\n")
-		for _, elt := range matches {
-			fmt.Printf("    %s\n", elt)
-		}
-		failf("Cannot delete profile without a unique match\n")
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
 	}
-	if len(matches) == 0 {
-		failf("No matching profile found\n")
+	q := chooseProfile(client.Search(query), args[0], "delete")
+	if !force {
+		fmt.Printf("    %s\n", q)
+		confirm(force, "Delete this profile?")
 	}
-
-	q := matches[0]
 	fmt.Printf("profile deleted: %s\n", q)
+	before := *q
+	trashProfile(master, client.Name, q, now)
 
 	// delete it
 	q.Length = 0
 	q.ModifiedAt = &now
+	q.DeletedAt = &now
 
 	if err := q.Validate(); err != nil {
 		failf("deleted profile is invalid, canceling: %v\n", err)
 	}
+	recordOp("delete", []*Profile{&before}, nil)
 
 	return client
 }
@@ -282,11 +383,37 @@ func listProfiles() *Client {
 	// gather options
 	var master string
 	registerMasterFlag(&master)
+	registerVaultFlag()
 	p := new(Profile)
 	registerProfileFlags(p)
+	query := new(Query)
+	registerQueryFlags(query)
+	showQR, showProfileQR := false, false
+	flag.BoolVar(&showQR, "qr", false, "Show each password as a QR code")
+	flag.BoolVar(&showProfileQR, "qr-profile", false, "Show each profile's settings as a QR code")
+	words := 0
+	registerBIP39Flag(&words)
+	noGenerate := false
+	flag.BoolVar(&noGenerate, "no-generate", false, "List profiles without generating passwords (no master password needed)")
+	order, format := "", ""
+	flag.StringVar(&order, "sort", order, "Sort by name, url, folder, or modified (default: best match first)")
+	flag.StringVar(&format, "format", format, "Print each profile with a Go template, e.g. '{{.Name}} {{.Password}}'")
+	flag.BoolVar(&noColor, "no-color", false, "Do not highlight matches (or set NO_COLOR)")
+	spell := false
+	registerSpellFlag(&spell)
+	tree, recent := false, false
+	flag.BoolVar(&tree, "tree", tree, "Group profiles under their folders")
+	flag.BoolVar(&recent, "recent", recent, "List profiles used on this device, most recent first")
+	long, all := false, false
+	flag.BoolVar(&long, "l", long, "Also show when each profile changed and whether it is synced")
+	flag.BoolVar(&all, "all", all, "With -l, also show deleted profiles not yet collected")
+	registerRevealFlags()
 	flag.Parse()
-	master = getAndVerifyMaster(master)
-	client := getClient(now, master)
+	checkBIP39Words(words)
+	var tmpl *template.Template
+	if format != "" {
+		tmpl = parseListFormat(format)
+	}
 
 	// get search string
 	args := flag.Args()
@@ -297,48 +424,175 @@ func listProfiles() *Client {
 		failf("Must provide no more than one search term to find profiles to list\n")
 	}
 
+	query.Term = search
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "url":
+			query.URL = p.URL
+		case "folder":
+			query.Folder = p.Folder
+		case "fav":
+			query.Favorite = p.Favorite
+		}
+	})
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+
+	// -all also lists deleted profiles, so it needs every one of them
+	var client *Client
+	switch {
+	case noGenerate && all:
+		client = loadClient()
+	case noGenerate:
+		client = loadMatches(query)
+	default:
+		master = getAndVerifyMaster(master)
+		if all {
+			client = getClient(now, master)
+		} else {
+			client = getMatches(now, master, query)
+		}
+	}
+
 	// find matching profiles
-	matches := client.Matches(search)
+	matches := client.Search(query)
+	if all {
+		for _, elt := range client.Profiles {
+			if elt.IsDeleted() {
+				matches = append(matches, elt)
+			}
+		}
+	}
+	if err := sortProfiles(matches, order); err != nil {
+		exitf(exitUsage, "%v\n", err)
+	}
+	if recent {
+		matches = sortRecent(matches, loadUsage())
+	}
+	if long && tmpl == nil && !jsonOutput {
+		printLastSync(client)
+	}
 
-	for _, elt := range matches {
-		fmt.Printf("    %s --> %s\n", elt, elt.Generate(master))
+	if noGenerate {
+		if tmpl != nil {
+			printListTemplate(tmpl, matches, nil)
+		} else if tree {
+			listTree(matches, nil)
+		} else if len(matches) > 0 {
+			listTable(matches, nil, query, long, nil)
+		}
+		return nil
+	}
+
+	passwords := generateAll(matches, func(elt *Profile) string {
+		if elt.IsDeleted() {
+			return ""
+		}
+		if words > 0 {
+			return elt.Mnemonic(master, words)
+		}
+		return elt.Generate(master)
+	})
+	if jsonOutput {
+		type listed struct {
+			*Profile
+			Password string `json:"password"`
+		}
+		out := []listed{}
+		for i, elt := range matches {
+			out = append(out, listed{elt, passwords[i]})
+		}
+		dump(out)
+		return client
+	}
+	if tmpl != nil {
+		printListTemplate(tmpl, matches, passwords)
+		return client
+	}
+	if copyPassword && len(matches) != 1 {
+		failf("-copy needs exactly one matching profile, found %d\n", len(matches))
+	}
+	shown := make([]string, len(passwords))
+	for i, password := range passwords {
+		shown[i] = maskPassword(password)
+	}
+	if tree {
+		listTree(matches, shown)
+	} else if len(matches) > 0 {
+		listTable(matches, shown, query, long, func(i int) {
+			if spell && passwords[i] != "" {
+				fmt.Print(spellPassword(passwords[i]))
+			}
+			if showQR {
+				printQR(passwords[i])
+			}
+			if showProfileQR {
+				printQR(profileExport(matches[i]))
+			}
+		})
+	}
+	if len(matches) == 1 && passwords[0] != "" {
+		revealPassword(passwords[0])
 	}
 
 	return client
 }
 
+// generateAll runs gen over the profiles on a pool of GOMAXPROCS
+// workers, since each derivation is a slow scrypt call. Results are
+// returned in the same order as the profiles.
+func generateAll(profiles []*Profile, gen func(*Profile) string) []string {
+	results := make([]string, len(profiles))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0) && w < len(profiles); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = gen(profiles[i])
+			}
+		}()
+	}
+	for i := range profiles {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
 func registerMasterFlag(master *string) {
-	flag.StringVar(master, "master", "", "Master password (or set LETMEIN_MASTER)")
+	flag.Var(masterFlag{master}, "master", "Master `password`, visible to other processes (needs allow_insecure_master)")
+	flag.BoolVar(&masterStdin, "master-stdin", false, "Read the master password from the first line of standard input")
+	flag.IntVar(&masterFD, "master-fd", -1, "Read the master password from this open file descriptor")
 }
 
 func getAndVerifyMaster(master string) string {
 	// prompt for a master password if necessary
 	if len(master) == 0 {
-		// get master password from environment, or from keyboard
-		if s := os.Getenv("LETMEIN_MASTER"); s != "" {
-			master = s
-		} else {
-			fmt.Printf("Master password: ")
-			master = string(gopass.GetPasswdMasked())
-			if len(master) == 0 {
-				fmt.Fprintf(os.Stderr, "master password is required")
-				os.Exit(1)
-			}
+		master = readMaster()
+		if len(master) == 0 {
+			exitf(exitBadMaster, "master password is required\n")
 		}
 	}
 
 	// validate the master password
 	if len(master) < minMasterLength || len(master) > maxMasterLength {
-		fmt.Fprintf(os.Stderr, "master password must be between %d and %d characters\n", minMasterLength, maxMasterLength)
-		os.Exit(1)
+		exitf(exitBadMaster, "master password must be between %d and %d characters\n", minMasterLength, maxMasterLength)
 	}
 	for _, r := range master {
 		if r < minChar || r > maxChar {
-			fmt.Fprintf(os.Stderr, "master password contains an illegal character\n")
-			os.Exit(1)
+			exitf(exitBadMaster, "master password contains an illegal character\n")
 		}
 	}
 
+	// mix in a hardware key if the vault requires one
+	if client, err := readMatches(nil); err == nil {
+		master = applyToken(client, master)
+	}
+
 	return master
 }
 
@@ -346,58 +600,168 @@ func registerProfileFlags(p *Profile) {
 	flag.StringVar(&p.Name, "name", "", "Profile name")
 	flag.StringVar(&p.Username, "username", "", "User name/email")
 	flag.StringVar(&p.URL, "url", "", "Website URL")
+	flag.StringVar(&p.Folder, "folder", "", "Folder path, e.g. work/aws")
 	flag.IntVar(&p.Generation, "generation", defaultGeneration, "Generation counter")
-	flag.IntVar(&p.Length, "length", defaultLength, "Password length")
-	flag.BoolVar(&p.Lower, "lower", true, "Include lower-case letters")
-	flag.BoolVar(&p.Upper, "upper", true, "Include upper-case letters")
-	flag.BoolVar(&p.Digits, "digits", true, "Include digits")
-	flag.BoolVar(&p.Punctuation, "punctuation", true, "Include punctuation")
-	flag.BoolVar(&p.Spaces, "spaces", false, "Include spaces")
+	flag.IntVar(&p.Length, "length", config.Length, "Password length")
+	flag.BoolVar(&p.Lower, "lower", config.Lower, "Include lower-case letters")
+	flag.BoolVar(&p.Upper, "upper", config.Upper, "Include upper-case letters")
+	flag.BoolVar(&p.Digits, "digits", config.Digits, "Include digits")
+	flag.BoolVar(&p.Punctuation, "punctuation", config.Punctuation, "Include punctuation")
+	flag.BoolVar(&p.Spaces, "spaces", config.Spaces, "Include spaces")
+	flag.BoolVar(&p.Latin1, "latin1", false, "Include Latin-1 symbols like £ and ± (not all sites accept them)")
 	flag.StringVar(&p.Include, "include", "", "Include specific ASCII characters")
 	flag.StringVar(&p.Exclude, "exclude", "", "Exclude specific ASCII characters")
+	flag.StringVar(&p.AutoType, "autotype", "", "Auto-type sequence, e.g. {USERNAME}{TAB}{PASSWORD}{ENTER}")
+	flag.BoolVar(&p.Favorite, "fav", false, "Mark as a favorite, listed first in pickers")
+	flag.BoolVar(&noAmbiguous, "no-ambiguous", false, "Exclude characters that are easy to confuse, like 0/O and 1/l/I")
+	flag.Var(pinFlag{p}, "pin", fmt.Sprintf("Generate a numeric PIN of this length (%d-%d)", minPINLength, maxPINLength))
+}
+
+// applyProfileFlags copies the profile settings given on the command
+// line (registered by registerProfileFlags into p) onto q.
+func applyProfileFlags(q, p *Profile) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			q.Name = p.Name
+		case "username":
+			q.Username = p.Username
+		case "url":
+			q.URL = p.URL
+		case "folder":
+			q.Folder = p.Folder
+		case "generation":
+			q.Generation = p.Generation
+		case "length":
+			q.Length = p.Length
+		case "lower":
+			q.Lower = p.Lower
+		case "upper":
+			q.Upper = p.Upper
+		case "digits":
+			q.Digits = p.Digits
+		case "punctuation":
+			q.Punctuation = p.Punctuation
+		case "spaces":
+			q.Spaces = p.Spaces
+		case "latin1":
+			q.Latin1 = p.Latin1
+		case "include":
+			q.Include = p.Include
+		case "exclude":
+			q.Exclude = p.Exclude
+		case "no-ambiguous":
+			// visited after exclude, so it adds to any new exclusions
+			if noAmbiguous {
+				q.Exclude += ambiguousChars
+			}
+		case "autotype":
+			q.AutoType = p.AutoType
+		case "fav":
+			q.Favorite = p.Favorite
+		case "pin":
+			q.SetPIN(p.Length)
+		}
+	})
+}
+
+// ambiguousChars are excluded by -no-ambiguous: characters that look
+// alike in many fonts or are hard to read aloud.
+const ambiguousChars = "0Oo1lI|`'\""
+
+// noAmbiguous is set by -no-ambiguous. It is recorded by adding
+// ambiguousChars to the profile's exclusions, which every version of
+// letmein understands.
+var noAmbiguous bool
+
+// pinFlag sets all the character-set options for a numeric PIN at once.
+type pinFlag struct {
+	p *Profile
+}
+
+func (f pinFlag) String() string {
+	return ""
+}
+
+func (f pinFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("PIN length must be a number")
+	}
+	return f.p.SetPIN(n)
 }
 
 func getClient(now time.Time, master string) *Client {
-	// load the file
-	raw, err := ioutil.ReadFile(filename)
-	if err != nil && !os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", filename, err)
-		os.Exit(1)
-	} else if err != nil {
-		// no profile list exists
-		fmt.Fprintf(os.Stderr, "No profile data found: you must run the init function first\n")
-		os.Exit(1)
+	return unlockClient(loadClient(), master)
+}
+
+// getMatches is getClient for commands that only look at the profiles
+// matching q. The client may hold just those, and cannot be saved.
+func getMatches(now time.Time, master string, q *Query) *Client {
+	return unlockClient(loadMatches(q), master)
+}
+
+func unlockClient(client *Client, master string) *Client {
+	if err := client.checkMaster(master); err != nil {
+		exitf(exitBadMaster, "Master password does not match this vault\n")
+	}
+	if client.partial {
+		debugf("skipping the integrity check for a partial read")
+	} else {
+		checkIntegrity(client, master)
 	}
 
-	client := new(Client)
-	if err := json.Unmarshal(raw, &client); err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing %s: %v\n", filename, err)
-		os.Exit(1)
+	// replace a legacy verification code as soon as the master is known,
+	// unless the store must not change
+	if !client.partial && !readOnly && client.upgradeVerifier(master) {
+		saveClient(client)
 	}
-	verify := VerifyProfile.Generate(master)
-	if client.Verify == "" {
-		client.Verify = verify
-	} else if client.Verify != verify {
-		fmt.Fprintf(os.Stderr, "Master password verification mismatch: found %s but expected %s\n", verify, client.Verify)
-		os.Exit(1)
+	unlockSharedVaults(client, master)
+	startAudit(master, client.Name)
+
+	return client
+}
+
+// loadClient locks and reads the profile store without checking the master password.
+func loadClient() *Client {
+	lockStore()
+
+	// load the store
+	return checkRead(readClient())
+}
+
+// loadMatches is loadClient for commands that only look at the profiles
+// matching q; see readMatches.
+func loadMatches(q *Query) *Client {
+	lockStore()
+	return checkRead(readMatches(q))
+}
+
+func checkRead(client *Client, err error) *Client {
+	if os.IsNotExist(err) {
+		// no profile list exists
+		exitf(exitNoVault, "No profile data found: you must run the init function first\n")
+	} else if err != nil {
+		failf("Error reading %s: %v\n", filename, err)
 	}
 
 	return client
 }
 
-func newClient(now time.Time, master string, name string) *Client {
+func newClient(now time.Time, master string, name string, verifier *Verifier) *Client {
+	lockStore()
+
 	// make sure the file does not exist
 	_, err := os.Stat(filename)
 	if err == nil {
-		fmt.Fprintf(os.Stderr, "Profile data already exists; delete %s to reset and start over\n", filename)
-		os.Exit(1)
+		failf("Profile data already exists; delete %s to reset and start over\n", filename)
 	} else if !os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error checking for existing profile data: %v\n", err)
-		os.Exit(1)
+		failf("Error checking for existing profile data: %v\n", err)
 	}
 	client := &Client{
 		Name:     name,
-		Verify:   VerifyProfile.Generate(master),
+		Verifier: verifier,
+		NoVerify: verifier == nil,
 		Profiles: []*Profile{},
 
 		Master: master,
@@ -412,102 +776,273 @@ func syncProfiles() *Client {
 	// gather options
 	var master string
 	registerMasterFlag(&master)
-	server := defaultServer
-	verbose := false
+	registerVaultFlag()
+	server := config.Server
 	flag.StringVar(&server, "server", server, "Server URL")
-	flag.BoolVar(&verbose, "v", verbose, "Dump messages")
+	dumpMessages := false
+	flag.BoolVar(&dumpMessages, "v", dumpMessages, "Dump messages (same as the -vv global option)")
+	onlyPending, noQueue := false, false
+	flag.BoolVar(&onlyPending, "pending", onlyPending, "Only sync if an earlier sync was queued (for timers and cron)")
+	flag.BoolVar(&noQueue, "no-queue", noQueue, "Fail instead of queueing the sync when the server is unreachable")
+	pullAll := false
+	flag.BoolVar(&pullAll, "pull-all", pullAll, "Download every profile from the server, not just those changed since the last sync")
+	peer, peerAddr := false, ""
+	flag.BoolVar(&peer, "peer", peer, "Sync directly with another device on the local network instead of a server")
+	flag.StringVar(&peerAddr, "peer-addr", peerAddr, "With -peer, connect to the device waiting at HOST:PORT")
 	flag.Parse()
+	if dumpMessages {
+		logLevel = levelTrace
+	}
+	if onlyPending {
+		pending := loadPendingSync()
+		if pending == nil {
+			return nil
+		}
+
+		// retry against the server that was unreachable
+		serverSet := false
+		flag.Visit(func(f *flag.Flag) { serverSet = serverSet || f.Name == "server" })
+		if !serverSet && pending.Server != "" {
+			server = pending.Server
+		}
+	}
 	master = getAndVerifyMaster(master)
 	client := getClient(now, master)
+	if peer || peerAddr != "" {
+		return peerSync(now, client, master, peerAddr)
+	}
+	return runSync(now, client, master, server, noQueue, pullAll)
+}
 
-	// prepare the sync request
+// runSync sends the client's changed profiles to a server and merges
+// the server's changes, or all of its profiles if full is set. It
+// returns nil if the sync was queued instead.
+func runSync(now time.Time, client *Client, master, server string, noQueue, full bool) *Client {
+	if isSSHServer(server) {
+		return sshSync(now, client, master, server)
+	}
+	ensureDevice(client)
+
+	// prepare the sync request. The server still identifies accounts by
+	// the legacy verification code, so it is derived on the fly rather
+	// than kept in the vault
+	secret := accountSecret(client, master)
 	req := &Client{
 		Name:           client.Name,
-		Verify:         client.Verify,
+		Verify:         VerifyProfile.Generate(master),
 		SyncedAt:       &now,
 		PreviousSyncAt: client.PreviousSyncAt,
+		Full:           full,
+		DeviceID:       client.DeviceID,
+		DeviceName:     client.DeviceName,
+		Shared:         client.Shared,
+		Hashes:         make(map[string]string),
+	}
+	if full {
+		// older servers return everything when there is no cursor
+		req.PreviousSyncAt = nil
+	}
+	var signKey []byte
+	if config.SyncSignatures != signaturesOff {
+		signKey = signingKey(master, client.Name)
 	}
 	for _, elt := range client.Profiles {
+		req.Hashes[elt.UUID] = contentHash(elt)
 		if elt.ModifiedAt != nil {
+			if signKey != nil {
+				nextRevision(elt)
+				signProfile(profileSigningKey(signKey, elt), elt)
+			}
 			req.Profiles = append(req.Profiles, elt)
 		}
 	}
-	if verbose {
-		fmt.Printf("\nRequest:\n")
-		dump(req)
-	}
-	raw, err := json.MarshalIndent(req, "", "    ")
+	debugf("sync request to %s: %d changed profiles", server, len(req.Profiles))
+	traceJSON("sync request", req)
+	uploadAttachments(server, client, secret, req.Profiles)
+	raw, err := json.Marshal(req)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error JSON-encoding request: %v\n", err)
-		os.Exit(1)
+		failf("Error JSON-encoding request: %v\n", err)
 	}
-	r, err := http.NewRequest("POST", server+"/api/v1noauth/sync", bytes.NewReader(raw))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error forming POST request: %v\n", err)
-		os.Exit(1)
+	// servers that understand it can use the cursor to return only
+	// profiles changed since the last sync, and may take a gzipped body
+	header := secretHeader(secret)
+	if !full && client.PreviousSyncAt != nil {
+		header.Set("If-Modified-Since", client.PreviousSyncAt.UTC().Format(http.TimeFormat))
 	}
-	r.Header.Set("Content-Type", "application/json")
-	r.Header.Set("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(r)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error sending POST request to server: %v\n", err)
-		os.Exit(1)
+	if config.CompressSync {
+		raw = gzipBytes(raw)
+		header.Set("Content-Encoding", "gzip")
+	}
+	debugf("sync request body: %d bytes", len(raw))
+	done := timed("sync request")
+	resp, err := sendRequest("POST", server+"/api/v1noauth/sync", "application/json", raw, header)
+	done()
+	if err != nil || resp.StatusCode >= 500 {
+		// the server is unreachable or down: queue the sync for later
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			resp.Body.Close()
+		}
+		recordSync(&SyncRecord{At: now, Server: server, Status: "failed", Error: reason, Sent: len(req.Profiles)})
+		if noQueue {
+			exitf(exitNetwork, "Error syncing with server: %s\n", reason)
+		}
+		pending := queueSync(now, server, reason)
+		fmt.Fprintf(os.Stderr, "Server unavailable (%s); sync queued since %s.\n", reason, pending.Since.Local().Format(time.Stamp))
+		fmt.Fprintf(os.Stderr, "Run \"letmein sync -pending\" later (serve-api retries automatically).\n")
+		return nil
 	}
+	checkClaimed(resp, server, client.Name)
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "Server returned an error status: %s\n", resp.Status)
-		io.Copy(os.Stderr, resp.Body)
-		fmt.Fprintf(os.Stderr, "\n")
-		os.Exit(1)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		exitf(exitNetwork, "Server returned an error status: %s\n%s\n", resp.Status, body)
 	}
+	clearPendingSync()
+	skew := checkClockSkew(resp, time.Now())
 
 	// decode the response
 	updates := new(Client)
 	decoder := json.NewDecoder(resp.Body)
 	if err = decoder.Decode(updates); err != nil {
-		fmt.Fprintf(os.Stderr, "Error decoding server response JSON: %v\n", err)
-		os.Exit(1)
+		exitf(exitNetwork, "Error decoding server response JSON: %v\n", err)
 	}
-	if verbose {
-		fmt.Printf("\nResponse:\n")
-		dump(updates)
+	if updates.Verify != "" && updates.Verify != req.Verify {
+		exitf(exitBadMaster, "Master password does not match the account on %s\n", server)
 	}
+	debugf("sync response: %s, %d profiles", resp.Status, len(updates.Profiles))
+	traceJSON("sync response", updates)
 
 	// merge the results
+	recordSnapshot("sync", client)
 	client.SyncedAt = nil
 	client.PreviousSyncAt = updates.PreviousSyncAt
 
+	mergeSharedVaults(client, updates.Shared, master)
+
+	rec := &SyncRecord{At: now, Server: server, Status: "ok", Sent: len(req.Profiles), ClockSkew: int(skew / time.Second)}
+	mergeUpdates(now, client, master, updates.Profiles, signKey, full, rec)
+	downloadAttachments(server, client, secret)
+	recordSync(rec)
+	return client
+}
+
+// checkRemoteIDs refuses a profile from the other side whose UUID or
+// attachment blob IDs could name a file outside the store. The rest of
+// it is checked by Validate when it is used.
+func checkRemoteIDs(p *Profile) error {
+	if !validUUID(p.UUID) {
+		return fmt.Errorf("invalid UUID %q", p.UUID)
+	}
+	for _, a := range p.Attachments {
+		if !validBlobID(a.Blob) {
+			return fmt.Errorf("profile %s has an invalid blob ID %q", p.UUID, a.Blob)
+		}
+	}
+	return nil
+}
+
+// mergeUpdates merges profiles from the other side of a sync into the
+// client, counting what changed in rec. If full is set, updates holds
+// every profile the other side has.
+func mergeUpdates(now time.Time, client *Client, master string, updates []*Profile, signKey []byte, full bool, rec *SyncRecord) {
 	byuuid := make(map[string]*Profile)
+	changed := make(map[string]bool)
 	for _, elt := range client.Profiles {
-		// discard deleted records now that they hav been uploaded
-		if !elt.IsDeleted() {
-			byuuid[elt.UUID] = elt
-		}
+		// deleted records are kept as tombstones until collected
+		byuuid[elt.UUID] = elt
 
 		// reset updated fields
+		changed[elt.UUID] = elt.ModifiedAt != nil
 		elt.ModifiedAt = nil
 	}
-	for _, elt := range updates.Profiles {
+	returned := make(map[string]bool)
+	for _, elt := range updates {
+		returned[elt.UUID] = true
+
+		// refuse anything the server could have forged or altered
+		if err := checkRemoteIDs(elt); err != nil {
+			infof("rejecting profile from server: %v", err)
+			rec.Rejected++
+			continue
+		}
+		if err := checkSignature(profileSigningKey(signKey, elt), elt, byuuid[elt.UUID]); err != nil {
+			infof("rejecting profile from server: %v", err)
+			rec.Rejected++
+			continue
+		}
+
+		// a profile changed on both sides is merged field by field; if
+		// the result differs from the server's copy, it is sent next time
+		if old := byuuid[elt.UUID]; changed[elt.UUID] && !old.IsDeleted() && !elt.IsDeleted() && contentHash(old) != contentHash(elt) {
+			merged, conflicts := mergeProfiles(old, elt)
+			rec.Conflicts += conflicts
+			if err := merged.Validate(); err != nil {
+				infof("cannot merge changes to %s (%v); keeping the server's version", elt.Name, err)
+			} else if contentHash(merged) != contentHash(elt) {
+				infof("merged local and remote changes to profile: %s", merged)
+				merged.ModifiedAt = &now
+				merged.SyncHashes = fieldHashes(elt)
+				byuuid[elt.UUID] = merged
+				rec.Updated++
+				continue
+			}
+		}
+
 		// is it a delete notice?
 		if elt.IsDeleted() {
-			log.Printf("deleting profile: %s", byuuid[elt.UUID])
-			delete(byuuid, elt.UUID)
+			if old, exists := byuuid[elt.UUID]; exists && !old.IsDeleted() {
+				infof("deleting profile: %s", old)
+				trashProfile(master, client.Name, old, now)
+				rec.Deleted++
+			}
+			if elt.DeletedAt == nil {
+				elt.DeletedAt = &now
+			}
 		} else {
-			if _, exists := byuuid[elt.UUID]; exists {
-				log.Printf("updating profile: %s", elt)
+			if old, exists := byuuid[elt.UUID]; exists {
+				if contentHash(old) != contentHash(elt) {
+					infof("updating profile: %s", elt)
+					rec.Updated++
+				}
 			} else {
-				log.Printf("adding profile: %s", elt)
+				infof("adding profile: %s", elt)
+				rec.Added++
 			}
+		}
 
-			elt.ModifiedAt = nil
-			byuuid[elt.UUID] = elt
+		elt.ModifiedAt = nil
+		byuuid[elt.UUID] = elt
+	}
+	if full {
+		// the server's set is complete, so anything else here is missing there
+		missing := 0
+		for _, elt := range client.Profiles {
+			if !returned[elt.UUID] && !changed[elt.UUID] && !elt.IsDeleted() {
+				missing++
+			}
+		}
+		if missing > 0 {
+			infof("profiles here but not on the server: %d (kept, and sent once changed)", missing)
 		}
 	}
 	client.Profiles = []*Profile{}
 	for _, elt := range byuuid {
+		// remember what both sides now agree on; a merged profile's
+		// base is the server's copy until it is sent
+		if elt.ModifiedAt == nil {
+			elt.SyncHashes = fieldHashes(elt)
+		}
 		client.Profiles = append(client.Profiles, elt)
 	}
-	return client
+
+	// prune old tombstones automatically
+	if config.TombstoneDays > 0 {
+		collectTombstones(client, config.TombstoneDays, false, now)
+	}
 }
 
 func initProfile() *Client {
@@ -516,23 +1051,56 @@ func initProfile() *Client {
 	// gather options
 	var master string
 	registerMasterFlag(&master)
-	server := "http://letmein-app.appspot.com"
+	registerVaultFlag()
+	server := config.Server
 	name := ""
 	flag.StringVar(&server, "server", server, "Server URL")
 	flag.StringVar(&name, "name", name, "Name to identify your account (required)")
+	verifyLength, verifyChars, noVerify := defaultVerifyLength, defaultVerifyChars, false
+	flag.IntVar(&verifyLength, "verify-length", verifyLength, "Length of the master password verification code")
+	flag.StringVar(&verifyChars, "verify-chars", verifyChars, "Characters for the verification code ("+verifyCharsetNames()+")")
+	flag.BoolVar(&noVerify, "no-verify", noVerify, "Do not store anything to check the master password against")
+	useFIDO2 := false
+	flag.BoolVar(&useFIDO2, "fido2", useFIDO2, "Require a FIDO2 security key (via libfido2 tools) to unlock")
+	flag.StringVar(&config.Backend, "backend", config.Backend, "Store format: json, dir (one file per profile), or sqlite (or set backend)")
+	fromServer := false
+	flag.BoolVar(&fromServer, "from-server", fromServer, "Download the account's profiles from the server")
 	flag.Parse()
+	if err := config.Validate(); err != nil {
+		exitf(exitUsage, "%v\n", err)
+	}
 	if name == "" {
-		fmt.Fprintf(os.Stderr, "name is required\n")
-		os.Exit(1)
+		exitf(exitUsage, "name is required\n")
 	}
 	master = getAndVerifyMaster(master)
-	client := newClient(now, master, name)
+	var token *FIDO2Token
+	if useFIDO2 {
+		var err error
+		if token, err = enrollFIDO2(name); err != nil {
+			failf("Error registering security key: %v\n", err)
+		}
+		if master, err = token.unlock(master); err != nil {
+			failf("Error unlocking with security key: %v\n", err)
+		}
+	}
+	var verifier *Verifier
+	if !noVerify {
+		var err error
+		if verifier, err = newVerifier(master, verifyLength, verifyChars); err != nil {
+			failf("%v\n", err)
+		}
+	}
+	client := newClient(now, master, name, verifier)
+	client.FIDO2 = token
+	integrityKey = integrityKeyFor(master, name)
+	if fromServer {
+		client = bootstrapFromServer(now, client, master, server)
+	}
 	return client
 }
 
 func failf(f string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, f, args...)
-	os.Exit(1)
+	exitf(exitError, f, args...)
 }
 
 func dump(elt interface{}) {
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Scripts and frontends can pass the master password on standard input
+// or an inherited file descriptor (such as a pipe, or a systemd
+// credential file), which unlike -master and LETMEIN_MASTER is not
+// visible to other processes through /proc.
+var (
+	masterStdin bool
+	masterFD    = -1
+
+	// masterInput is the password once read, as the input cannot be
+	// read twice
+	masterInput string
+
+	// skipSession ignores an unlocked session, for unlock itself
+	skipSession bool
+)
+
+// readMasterLine reads one line, unbuffered so that anything after it
+// is left for the command.
+func readMasterLine(r io.Reader) (string, error) {
+	buf := make([]byte, 0, maxMasterLength*4)
+	defer func() { wipe(buf[:cap(buf)]) }()
+	b := make([]byte, 1)
+	for len(buf) < cap(buf) {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			buf = append(buf, b[0])
+			continue
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(buf), "\r"), nil
+}
+
+// -master and LETMEIN_MASTER leave the password in shell history and
+// where other processes can read it, so they are refused unless the
+// allow_insecure_master setting accepts the risk.
+func checkInsecureMaster(source string) {
+	if !config.AllowInsecureMaster {
+		exitf(exitBadMaster, "%s exposes the master password to other processes; use -master-stdin, -master-fd, or the prompt, or set allow_insecure_master to accept the risk\n", source)
+	}
+}
+
+// masterFlag is -master, which checks the setting as soon as it is
+// given. It exits rather than returning an error, which the flag
+// package would print along with the password.
+type masterFlag struct {
+	master *string
+}
+
+func (f masterFlag) String() string {
+	return ""
+}
+
+func (f masterFlag) Set(s string) error {
+	checkInsecureMaster("-master")
+	*f.master = s
+	return nil
+}
+
+// readMaster gets the master password from -master-stdin or
+// -master-fd, LETMEIN_MASTER, an unlocked session, pinentry, or the
+// keyboard, in that order.
+func readMaster() string {
+	if masterStdin && masterFD >= 0 {
+		exitf(exitUsage, "Use only one of -master-stdin and -master-fd\n")
+	}
+	var (
+		src  *os.File
+		what string
+	)
+	switch {
+	case masterInput != "":
+		return masterInput
+	case masterStdin:
+		src, what = os.Stdin, "standard input"
+	case masterFD >= 0:
+		src, what = os.NewFile(uintptr(masterFD), "master-fd"), "the -master-fd descriptor"
+		defer src.Close()
+	}
+	if src != nil {
+		master, err := readMasterLine(src)
+		if err != nil {
+			exitf(exitBadMaster, "Error reading the master password from %s: %v\n", what, err)
+		}
+		if master == "" {
+			exitf(exitBadMaster, "No master password on %s\n", what)
+		}
+		masterInput = master
+		return master
+	}
+	if s := os.Getenv("LETMEIN_MASTER"); s != "" {
+		checkInsecureMaster("LETMEIN_MASTER")
+		return s
+	}
+	if !skipSession {
+		if master := readSession(); master != "" {
+			return master
+		}
+	}
+	if config.Pinentry != "" {
+		master, err := pinentryPrompt("Master password:", "Enter the master password for letmein.")
+		if err != nil {
+			exitf(exitBadMaster, "Error running %s: %v\n", config.Pinentry, err)
+		}
+		masterInput = master
+		return master
+	}
+	fmt.Printf("Master password: ")
+	return readMasked()
+}
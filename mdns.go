@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Devices waiting for a peer sync answer multicast DNS queries for the
+// letmein service on the local network. Each answers with an instance
+// named after a hash of the account name, so a device only connects
+// to peers of its own account and the name itself is not broadcast.
+
+const mdnsService = "_letmein._tcp.local."
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// peerTag identifies the account on the network.
+func peerTag(account string) string {
+	sum := sha256.Sum256([]byte("letmein peer\t" + account))
+	return hex.EncodeToString(sum[:8])
+}
+
+// mdnsAdvertise answers queries for the service until stop is closed,
+// pointing them at the given TCP port.
+func mdnsAdvertise(account string, port int, stop <-chan struct{}) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-stop
+		conn.Close()
+	}()
+	service := dnsmessage.MustNewName(mdnsService)
+	instance := dnsmessage.MustNewName(peerTag(account) + "." + mdnsService)
+	host, _ := os.Hostname()
+	target, err := dnsmessage.NewName(mdnsLabel(host) + ".local.")
+	if err != nil {
+		target = dnsmessage.MustNewName("letmein.local.")
+	}
+
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			hdr, err := p.Start(buf[:n])
+			if err != nil || hdr.Response {
+				continue
+			}
+			asked := false
+			for {
+				q, err := p.Question()
+				if err != nil {
+					break
+				}
+				if q.Type == dnsmessage.TypePTR && strings.EqualFold(q.Name.String(), mdnsService) {
+					asked = true
+				}
+			}
+			if !asked {
+				continue
+			}
+			answer := dnsmessage.Message{
+				Header: dnsmessage.Header{ID: hdr.ID, Response: true, Authoritative: true},
+				Answers: []dnsmessage.Resource{
+					{
+						Header: dnsmessage.ResourceHeader{Name: service, Class: dnsmessage.ClassINET, TTL: 120},
+						Body:   &dnsmessage.PTRResource{PTR: instance},
+					},
+					{
+						Header: dnsmessage.ResourceHeader{Name: instance, Class: dnsmessage.ClassINET, TTL: 120},
+						Body:   &dnsmessage.SRVResource{Port: uint16(port), Target: target},
+					},
+				},
+			}
+			out, err := answer.Pack()
+			if err != nil {
+				continue
+			}
+
+			// reply straight to the asker, who listens on its own port
+			conn.WriteToUDP(out, from)
+		}
+	}()
+	return nil
+}
+
+// mdnsBrowse looks for a device of the same account waiting for a peer
+// sync, and returns its address, or "" if none answered in time.
+func mdnsBrowse(account string, wait time.Duration) (string, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	query := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(mdnsService),
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	out, err := query.Pack()
+	if err != nil {
+		return "", err
+	}
+	want := strings.ToLower(peerTag(account) + "." + mdnsService)
+
+	deadline := time.Now().Add(wait)
+	buf := make([]byte, 9000)
+	for time.Now().Before(deadline) {
+		if _, err := conn.WriteToUDP(out, mdnsGroup); err != nil {
+			return "", err
+		}
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			if port := mdnsPeerPort(buf[:n], want); port != 0 {
+				return net.JoinHostPort(from.IP.String(), strconv.Itoa(port)), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// mdnsPeerPort returns the port in a response's SRV record for the
+// wanted instance, or 0.
+func mdnsPeerPort(msg []byte, want string) int {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil || !hdr.Response {
+		return 0
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0
+	}
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			return 0
+		}
+		if h.Type != dnsmessage.TypeSRV || strings.ToLower(h.Name.String()) != want {
+			p.SkipAnswer()
+			continue
+		}
+		srv, err := p.SRVResource()
+		if err != nil {
+			return 0
+		}
+		return int(srv.Port)
+	}
+}
+
+// mdnsLabel makes a host name usable as a single DNS label.
+func mdnsLabel(host string) string {
+	host = strings.SplitN(host, ".", 2)[0]
+	if host == "" {
+		return "letmein"
+	}
+	if len(host) > 63 {
+		host = host[:63]
+	}
+	return host
+}
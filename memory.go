@@ -0,0 +1,48 @@
+package main
+
+// wipe zeroes a buffer that held secret material. Go strings cannot be
+// wiped, so secrets should stay in byte slices for as long as possible.
+//
+// Schemes take the master password and return the password as byte
+// slices, which Generate and DeriveKey wipe once they are done, and the
+// keys cached along the way are wiped by wipeSecrets on exit. Commands
+// still read the master password into a string and Generate still
+// hands back a string, so those copies are left to the garbage
+// collector.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// wipeSecrets wipes every cached key before the process exits.
+func wipeSecrets() {
+	secretKeysMu.Lock()
+	for id, key := range secretKeys {
+		unlockMemory(key)
+		delete(secretKeys, id)
+	}
+	secretKeysMu.Unlock()
+	for id, key := range vaultKeys {
+		wipe(key)
+		delete(vaultKeys, id)
+	}
+	derivedCache.clear()
+}
+
+// joinSecret builds the tab-separated KDF input from a secret and the
+// other parts in a new byte slice, without an intermediate string the
+// caller could not wipe. The caller wipes the result.
+func joinSecret(secret []byte, parts ...string) []byte {
+	n := len(secret)
+	for _, part := range parts {
+		n += 1 + len(part)
+	}
+	out := make([]byte, 0, n)
+	out = append(out, secret...)
+	for _, part := range parts {
+		out = append(out, '\t')
+		out = append(out, part...)
+	}
+	return out
+}
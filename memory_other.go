@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package main
+
+func hardenProcess() {}
+
+func lockMemory(b []byte) {}
+
+func unlockMemory(b []byte) {
+	wipe(b)
+}
@@ -0,0 +1,27 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// hardenProcess disables core dumps so secrets in memory never end up
+// on disk after a crash.
+func hardenProcess() {
+	unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{Cur: 0, Max: 0})
+}
+
+// lockMemory keeps a secret buffer out of swap. It is best effort: the
+// RLIMIT_MEMLOCK limit is often small for unprivileged users.
+func lockMemory(b []byte) {
+	if len(b) > 0 {
+		unix.Mlock(b)
+	}
+}
+
+// unlockMemory wipes a buffer locked by lockMemory and releases it.
+func unlockMemory(b []byte) {
+	wipe(b)
+	if len(b) > 0 {
+		unix.Munlock(b)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// hardenProcess is a no-op on Windows, which does not write core dumps
+// unless Windows Error Reporting is configured to.
+func hardenProcess() {}
+
+// lockMemory keeps a secret buffer out of the page file (best effort).
+func lockMemory(b []byte) {
+	if len(b) > 0 {
+		windows.VirtualLock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+	}
+}
+
+// unlockMemory wipes a buffer locked by lockMemory and releases it.
+func unlockMemory(b []byte) {
+	wipe(b)
+	if len(b) > 0 {
+		windows.VirtualUnlock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+	}
+}
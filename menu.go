@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+func menuProfile() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	picker := "fzf"
+	list, typed, print := false, false, false
+	flag.StringVar(&picker, "picker", picker, `Picker command, e.g. "rofi -dmenu" or "dmenu -i"`)
+	flag.BoolVar(&list, "list", list, "Print profile names for an external picker and exit")
+	flag.BoolVar(&typed, "type", typed, "Type the profile's auto-type sequence into the focused window")
+	flag.BoolVar(&print, "print", print, "Print the password instead of copying it")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: letmein menu [options] [profile name]\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if list {
+		printProfileNames()
+		return nil
+	}
+
+	// get the selection, running the picker if necessary
+	args := flag.Args()
+	var name string
+	switch len(args) {
+	case 0:
+		name = runPicker(picker)
+	case 1:
+		name = args[0]
+	default:
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if name == "" {
+		failf("No profile selected\n")
+	}
+
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+	var p *Profile
+	for _, elt := range client.Profiles {
+		if !elt.IsDeleted() && elt.Name == name {
+			p = elt
+		}
+	}
+	if p == nil {
+		exitf(exitNoMatch, "No profile named %q\n", name)
+	}
+
+	password := p.Generate(master)
+	recordUse(p)
+	switch {
+	case print:
+		fmt.Println(password)
+	case typed:
+		if err := autoType(p, password); err != nil {
+			failf("Error typing: %v\n", err)
+		}
+	default:
+		// nothing is saved, so other commands need not wait while the
+		// clipboard waits to be cleared
+		unlockStore()
+		copyToClipboard(password, clipboardTimeout())
+	}
+
+	return client
+}
+
+// runPicker feeds profile names to a picker command and returns the
+// selected name.
+func runPicker(picker string) string {
+	fields := strings.Fields(picker)
+	if len(fields) == 0 {
+		failf("No picker command given\n")
+	}
+	raw, err := readProfileNames()
+	if err != nil {
+		failf("Error reading profile names: %v\n", err)
+	}
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stdin = bytes.NewReader(raw)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// pickers exit non-zero when the user cancels
+		if _, ok := err.(*exec.ExitError); ok {
+			return ""
+		}
+		failf("Error running %s: %v\n", fields[0], err)
+	}
+	return strings.TrimRight(string(out), "\r\n")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mergeFields are the Profile fields merged independently on sync, so
+// concurrent edits to different fields on different devices both
+// survive. FieldTimes is keyed by each field's JSON name.
+var mergeFields = []string{
+	"Scheme", "Name", "Username", "URL", "Folder", "Generation", "Length",
+	"Lower", "Upper", "Digits", "Punctuation", "Spaces", "Latin1", "Include", "Exclude",
+	"AutoType", "Archived", "Favorite", "Fields", "Attachments", "Secret", "Codes",
+}
+
+func mergeKey(field string) string {
+	f, _ := reflect.TypeOf(Profile{}).FieldByName(field)
+	return strings.Split(f.Tag.Get("json"), ",")[0]
+}
+
+// stampChanges records now as the modification time of every field
+// that differs between before and after.
+func stampChanges(before, after *Profile, now time.Time) {
+	times := make(map[string]*time.Time)
+	for k, v := range after.FieldTimes {
+		times[k] = v
+	}
+	b, a := reflect.ValueOf(before).Elem(), reflect.ValueOf(after).Elem()
+	for _, field := range mergeFields {
+		if !reflect.DeepEqual(b.FieldByName(field).Interface(), a.FieldByName(field).Interface()) {
+			t := now
+			times[mergeKey(field)] = &t
+		}
+	}
+	after.FieldTimes = times
+}
+
+// fieldHash is a short hash of one field's value.
+func fieldHash(v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		failf("Error encoding profile field: %v\n", err)
+	}
+	sum := sha256.Sum256(raw)
+	return base64.RawStdEncoding.EncodeToString(sum[:9])
+}
+
+// fieldHashes hashes each merge field of p, for SyncHashes.
+func fieldHashes(p *Profile) map[string]string {
+	if p.IsDeleted() {
+		return nil
+	}
+	hashes := make(map[string]string)
+	v := reflect.ValueOf(p).Elem()
+	for _, field := range mergeFields {
+		hashes[mergeKey(field)] = fieldHash(v.FieldByName(field).Interface())
+	}
+	return hashes
+}
+
+// contentHash identifies what a profile holds, ignoring timestamps and
+// sync bookkeeping, so two copies can be compared without trusting
+// either device's clock.
+func contentHash(p *Profile) string {
+	if p.IsDeleted() {
+		return "deleted"
+	}
+	hashes := fieldHashes(p)
+	keys := make([]string, 0, len(hashes))
+	for k := range hashes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k + "=" + hashes[k] + "\n"))
+	}
+	return base64.RawStdEncoding.EncodeToString(h.Sum(nil)[:12])
+}
+
+// mergeProfiles combines a local profile that changed since the last
+// sync with the server's version of it. Where the local profile has
+// hashes from the last sync, a field changed on only one side takes
+// that side's value whatever the clocks say. A field changed on both
+// sides, or one without a hash, comes from whichever side changed it
+// more recently; fields without a timestamp on either side keep the
+// server's value, as a whole-profile sync always did. It returns the
+// merged profile and the number of fields both sides changed to
+// different values.
+func mergeProfiles(local, remote *Profile) (*Profile, int) {
+	merged := *remote
+	if local.Revision > merged.Revision {
+		merged.Revision = local.Revision
+	}
+	merged.FieldTimes = make(map[string]*time.Time)
+	for k, v := range remote.FieldTimes {
+		merged.FieldTimes[k] = v
+	}
+	conflicts := 0
+	l, m := reflect.ValueOf(local).Elem(), reflect.ValueOf(&merged).Elem()
+	for _, field := range mergeFields {
+		key := mergeKey(field)
+		lv, rv := l.FieldByName(field).Interface(), m.FieldByName(field).Interface()
+		lt, rt := local.FieldTimes[key], remote.FieldTimes[key]
+		if base, ok := local.SyncHashes[key]; ok {
+			lh, rh := fieldHash(lv), fieldHash(rv)
+			switch {
+			case lh == rh || lh == base:
+				// unchanged here, so the server's value stands
+				continue
+			case rh == base:
+				// changed only here
+				m.FieldByName(field).Set(l.FieldByName(field))
+				if lt != nil {
+					merged.FieldTimes[key] = lt
+				}
+				continue
+			}
+
+			// changed on both sides: fall back on the clocks
+			conflicts++
+			if lt == nil || (rt != nil && !lt.After(*rt)) {
+				continue
+			}
+		} else {
+			if lt == nil || (rt != nil && !lt.After(*rt)) {
+				continue
+			}
+			if rt != nil && !reflect.DeepEqual(lv, rv) {
+				conflicts++
+			}
+		}
+		m.FieldByName(field).Set(l.FieldByName(field))
+		merged.FieldTimes[key] = lt
+	}
+	if len(merged.FieldTimes) == 0 {
+		merged.FieldTimes = nil
+	}
+	return &merged, conflicts
+}
+
+// countChanged counts the profiles changed since the last sync.
+func countChanged(client *Client) int {
+	n := 0
+	for _, elt := range client.Profiles {
+		if elt.ModifiedAt != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// settleSynced marks every profile as agreed with the other side, for
+// syncs that leave both sides with the same profiles.
+func settleSynced(client *Client) {
+	for _, elt := range client.Profiles {
+		elt.ModifiedAt = nil
+		elt.SyncHashes = fieldHashes(elt)
+	}
+	client.SyncedAt = nil
+}
+
+// syncCopies prepares profiles to hand to another device or store:
+// copies without this device's sync bookkeeping, signed if signKey is
+// set.
+func syncCopies(profiles []*Profile, signKey []byte) []*Profile {
+	out := []*Profile{}
+	for _, elt := range profiles {
+		copied := *elt
+		copied.ModifiedAt = nil
+		copied.SyncHashes = nil
+		if signKey != nil {
+			signProfile(profileSigningKey(signKey, &copied), &copied)
+		}
+		out = append(out, &copied)
+	}
+	return out
+}
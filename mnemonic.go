@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// registerBIP39Flag adds the -bip39 output mode to a command.
+func registerBIP39Flag(words *int) {
+	flag.IntVar(words, "bip39", 0, "Output a 12- or 24-word BIP39 mnemonic instead of a password")
+}
+
+// checkBIP39Words validates the -bip39 word count.
+func checkBIP39Words(words int) {
+	if words != 0 && words != 12 && words != 24 {
+		failf("-bip39 must be 12 or 24\n")
+	}
+}
+
+// Mnemonic derives a BIP39 mnemonic of 12 or 24 words from a profile.
+func (p *Profile) Mnemonic(master string, words int) string {
+	if p.Scheme == schemeStored {
+		failf("Cannot derive a mnemonic from a stored-password profile\n")
+	}
+	// 12 words encode 128 bits of entropy, 24 words encode 256; the word
+	// count is part of the purpose so one seed is never a prefix of the other
+	entropy := p.DeriveKey(master, fmt.Sprintf("bip39-%d", words), words/12*16)
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	wipe(entropy)
+	if err != nil {
+		panic(fmt.Sprintf("error generating mnemonic: %v", err))
+	}
+	return mnemonic
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// nativeHostName identifies the native messaging host to browsers.
+const nativeHostName = "com.github.russross.letmein"
+
+// maxNativeMessage is the largest message we accept from the browser.
+const maxNativeMessage = 1 << 20
+
+type nativeRequest struct {
+	Op     string `json:"op"`
+	Master string `json:"master,omitempty"`
+	URL    string `json:"url,omitempty"`
+	UUID   string `json:"uuid,omitempty"`
+}
+
+type nativeProfile struct {
+	UUID     string `json:"uuid"`
+	Name     string `json:"name"`
+	Username string `json:"username,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type nativeResponse struct {
+	OK       bool             `json:"ok"`
+	Error    string           `json:"error,omitempty"`
+	Profiles []*nativeProfile `json:"profiles,omitempty"`
+}
+
+// isNativeMessagingLaunch recognizes the arguments browsers pass when
+// they start a native messaging host directly: Chrome passes the
+// caller's origin, Firefox passes the manifest path and extension ID.
+func isNativeMessagingLaunch(args []string) bool {
+	if len(args) >= 1 && strings.HasPrefix(args[0], "chrome-extension://") {
+		return true
+	}
+	return len(args) == 2 && strings.HasSuffix(args[0], ".json") && !strings.HasPrefix(args[1], "-")
+}
+
+func nativeHost() {
+	install := ""
+	extension := ""
+	registerVaultFlag()
+	flag.StringVar(&install, "install", install, "Install the host manifest for chrome, chromium, or firefox")
+	flag.StringVar(&extension, "extension-id", extension, "Browser extension ID allowed to use the host")
+	flag.Parse()
+
+	if install != "" {
+		installNativeManifest(install, extension)
+		return
+	}
+
+	for {
+		req := new(nativeRequest)
+		if err := readNativeMessage(os.Stdin, req); err == io.EOF {
+			return
+		} else if err != nil {
+			writeNativeMessage(os.Stdout, &nativeResponse{Error: err.Error()})
+			return
+		}
+		writeNativeMessage(os.Stdout, handleNativeRequest(req))
+	}
+}
+
+func handleNativeRequest(req *nativeRequest) *nativeResponse {
+	master := req.Master
+	if master == "" && config.AllowInsecureMaster {
+		master = os.Getenv("LETMEIN_MASTER")
+	}
+	if master == "" {
+		return &nativeResponse{Error: "master password is required"}
+	}
+	var client *Client
+	var err error
+	if req.Op == "lookup" {
+		client, err = readMatches(&Query{URL: req.URL})
+	} else {
+		client, err = readClient()
+	}
+	if err != nil {
+		return &nativeResponse{Error: err.Error()}
+	}
+	if client.FIDO2 != nil {
+		if master, err = client.FIDO2.unlock(master); err != nil {
+			return &nativeResponse{Error: err.Error()}
+		}
+	}
+	if err := client.checkMaster(master); err != nil {
+		return &nativeResponse{Error: err.Error()}
+	}
+	startAudit(master, client.Name)
+
+	resp := &nativeResponse{OK: true, Profiles: []*nativeProfile{}}
+	switch req.Op {
+	case "lookup":
+		for _, elt := range client.Profiles {
+			if !elt.IsDeleted() && !elt.Archived && SameSite(req.URL, elt.URL) {
+				resp.Profiles = append(resp.Profiles, &nativeProfile{
+					UUID:     elt.UUID,
+					Name:     elt.Name,
+					Username: elt.Username,
+					URL:      elt.URL,
+				})
+			}
+		}
+	case "generate":
+		for _, elt := range client.Profiles {
+			if !elt.IsDeleted() && elt.UUID == req.UUID {
+				resp.Profiles = append(resp.Profiles, &nativeProfile{
+					UUID:     elt.UUID,
+					Name:     elt.Name,
+					Username: elt.Username,
+					URL:      elt.URL,
+					Password: elt.Generate(master),
+				})
+			}
+		}
+		if len(resp.Profiles) == 0 {
+			return &nativeResponse{Error: "no profile with uuid " + req.UUID}
+		}
+	default:
+		return &nativeResponse{Error: "unknown op: " + req.Op}
+	}
+	return resp
+}
+
+// readNativeMessage reads one length-prefixed JSON message.
+func readNativeMessage(r io.Reader, msg interface{}) error {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return err
+	}
+	if size > maxNativeMessage {
+		return fmt.Errorf("message too large: %d bytes", size)
+	}
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, msg)
+}
+
+// writeNativeMessage writes one length-prefixed JSON message.
+func writeNativeMessage(w io.Writer, msg interface{}) {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		panic(err.Error())
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(raw))); err != nil {
+		os.Exit(1)
+	}
+	if _, err := w.Write(raw); err != nil {
+		os.Exit(1)
+	}
+}
+
+// nativeManifestDir returns where a browser looks for host manifests.
+func nativeManifestDir(browser string) string {
+	home := homeDir()
+	switch runtime.GOOS + "/" + browser {
+	case "linux/chrome":
+		return filepath.Join(home, ".config", "google-chrome", "NativeMessagingHosts")
+	case "linux/chromium":
+		return filepath.Join(home, ".config", "chromium", "NativeMessagingHosts")
+	case "linux/firefox":
+		return filepath.Join(home, ".mozilla", "native-messaging-hosts")
+	case "darwin/chrome":
+		return filepath.Join(home, "Library", "Application Support", "Google", "Chrome", "NativeMessagingHosts")
+	case "darwin/chromium":
+		return filepath.Join(home, "Library", "Application Support", "Chromium", "NativeMessagingHosts")
+	case "darwin/firefox":
+		return filepath.Join(home, "Library", "Application Support", "Mozilla", "NativeMessagingHosts")
+	case "windows/chrome", "windows/chromium", "windows/firefox":
+		// Windows finds manifests through the registry instead
+		return filepath.Join(dataDir(), "native-messaging", browser)
+	}
+	failf("Unsupported browser %q on %s: must be chrome, chromium, or firefox\n", browser, runtime.GOOS)
+	return ""
+}
+
+func installNativeManifest(browser, extension string) {
+	if extension == "" {
+		failf("-extension-id is required\n")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		failf("Error finding letmein executable: %v\n", err)
+	}
+	manifest := map[string]interface{}{
+		"name":        nativeHostName,
+		"description": "letmein password generator",
+		"path":        exe,
+		"type":        "stdio",
+	}
+	if browser == "firefox" {
+		manifest["allowed_extensions"] = []string{extension}
+	} else {
+		manifest["allowed_origins"] = []string{"chrome-extension://" + extension + "/"}
+	}
+	raw, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		failf("Error encoding manifest: %v\n", err)
+	}
+	raw = append(raw, '\n')
+
+	dir := nativeManifestDir(browser)
+	path := filepath.Join(dir, nativeHostName+".json")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		failf("Error creating %s: %v\n", dir, err)
+	}
+	if err := writeFileAtomic(path, raw, 0644); err != nil {
+		failf("Error writing %s: %v\n", path, err)
+	}
+	fmt.Printf("native messaging manifest written to %s\n", path)
+	if runtime.GOOS == "windows" {
+		key := `HKCU\Software\Google\Chrome\NativeMessagingHosts\`
+		if browser == "firefox" {
+			key = `HKCU\Software\Mozilla\NativeMessagingHosts\`
+		}
+		fmt.Printf("register it with:\n    reg add \"%s%s\" /ve /t REG_SZ /d \"%s\" /f\n", key, nativeHostName, path)
+	}
+}
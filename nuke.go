@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// nukeFiles lists everything letmein keeps for the current store.
+func nukeFiles() []string {
+	return []string{
+		filename,
+		historyFilename(),
+		journalFilename(),
+		pendingSyncFilename(),
+		trashFilename(),
+		usageFilename(),
+		auditFilename(),
+		indexFile(filename),
+		apiTokenFilename(),
+		attachmentDir(),
+		backupDir(),
+		filename + ".lock",
+		sessionFilename(),
+	}
+}
+
+func nukeCommand() {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	registerInteractiveFlag()
+	server := config.Server
+	remote, force := false, false
+	flag.StringVar(&server, "server", server, "Server URL")
+	flag.BoolVar(&remote, "remote", remote, "Also ask the server to delete this account's data")
+	registerForceFlag(&force)
+	flag.Parse()
+
+	client := loadClient()
+	if !force {
+		if !interactive() {
+			failf("Refusing to delete %s without confirmation; use -force\n", filename)
+		}
+		fmt.Printf("This permanently deletes %s, its backups, and its attachments.\n", filename)
+		if remote {
+			fmt.Printf("It also deletes account %s from %s.\n", client.Name, server)
+		}
+		if readLine(fmt.Sprintf("Type the account name (%s) to continue: ", client.Name)) != client.Name {
+			failf("Canceled\n")
+		}
+	}
+
+	// delete the server copy first, so a failure leaves the local store
+	// intact to try again
+	if remote {
+		master = getAndVerifyMaster(master)
+		getClient(now, master)
+		deleteRemoteAccount(server, client, master)
+		fmt.Printf("deleted account %s from %s\n", client.Name, server)
+	}
+
+	unlockStore()
+	for _, path := range nukeFiles() {
+		if err := os.RemoveAll(path); err != nil {
+			failf("Error deleting %s: %v\n", path, err)
+		}
+		debugf("deleted %s", path)
+	}
+	fmt.Printf("deleted %s\n", filename)
+}
+
+// deleteRemoteAccount asks the server to forget an account, identified
+// the same way a sync does.
+func deleteRemoteAccount(server string, client *Client, master string) {
+	if isSSHServer(server) {
+		target, err := parseSSHServer(server)
+		if err != nil {
+			exitf(exitUsage, "%v\n", err)
+		}
+		if _, err := target.run("rm -f "+shellQuote(target.path), nil); err != nil {
+			exitf(exitNetwork, "Error deleting %s: %v\n", server, err)
+		}
+		return
+	}
+	raw, err := json.Marshal(&deviceRequest{
+		Name:     client.Name,
+		Verify:   VerifyProfile.Generate(master),
+		DeviceID: client.DeviceID,
+	})
+	if err != nil {
+		failf("Error JSON-encoding request: %v\n", err)
+	}
+	resp, err := sendRequest("POST", server+"/api/v1noauth/delete", "application/json", raw, secretHeader(accountSecret(client, master)))
+	if err != nil {
+		exitf(exitNetwork, "Error contacting server: %v\n", err)
+	}
+	checkClaimed(resp, server, client.Name)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		exitf(exitNetwork, "This server does not support deleting accounts; nothing was deleted\n")
+	}
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		exitf(exitNetwork, "Server returned an error status: %s\n%s\n", resp.Status, body)
+	}
+}
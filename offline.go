@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const pendingRetryInterval = 5 * time.Minute
+
+// PendingSync records a sync that failed because the server could not
+// be reached, so it can be retried later without the user remembering.
+type PendingSync struct {
+	Server    string    `json:"server"`
+	Since     time.Time `json:"since"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+}
+
+func pendingSyncFilename() string {
+	return filename + ".sync-pending"
+}
+
+func loadPendingSync() *PendingSync {
+	raw, err := ioutil.ReadFile(pendingSyncFilename())
+	if err != nil {
+		return nil
+	}
+	pending := new(PendingSync)
+	if err := json.Unmarshal(raw, pending); err != nil {
+		return nil
+	}
+	return pending
+}
+
+// queueSync notes a failed sync attempt.
+func queueSync(now time.Time, server, reason string) *PendingSync {
+	pending := loadPendingSync()
+	if pending == nil {
+		pending = &PendingSync{Since: now}
+	}
+	pending.Server = server
+	pending.Attempts++
+	pending.LastError = reason
+	raw, err := json.MarshalIndent(pending, "", "    ")
+	if err != nil {
+		failf("Error encoding %s: %v\n", pendingSyncFilename(), err)
+	}
+	if err := writeFileAtomic(pendingSyncFilename(), append(raw, '\n'), 0600); err != nil {
+		failf("Error writing %s: %v\n", pendingSyncFilename(), err)
+	}
+	return pending
+}
+
+func clearPendingSync() {
+	if err := os.Remove(pendingSyncFilename()); err != nil && !os.IsNotExist(err) {
+		failf("Error removing %s: %v\n", pendingSyncFilename(), err)
+	}
+}
+
+// retryPendingSyncs runs in long-lived commands, retrying a queued sync
+// in a child process from time to time. Vaults that need a security key
+// are skipped, since nobody is there to touch it.
+func retryPendingSyncs(master string) {
+	self, err := os.Executable()
+	if err != nil {
+		return
+	}
+	for range time.Tick(pendingRetryInterval) {
+		if loadPendingSync() == nil {
+			continue
+		}
+		if client, err := readClient(); err != nil || client.FIDO2 != nil {
+			continue
+		}
+		// hand over the master password on a pipe, not in the environment
+		r, w, err := os.Pipe()
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(self, "-vault", filename, "sync", "-pending", "-master-fd", "3")
+		cmd.ExtraFiles = []*os.File{r}
+		go func() {
+			io.WriteString(w, master+"\n")
+			w.Close()
+		}()
+		out, err := cmd.CombinedOutput()
+		r.Close()
+		if err != nil {
+			infof("retrying queued sync: %v: %s", err, out)
+		} else {
+			infof("queued sync completed")
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// letmein pair hands a snapshot of the store to a phone without going
+// through a sync server. It shows a QR code holding a throwaway X25519
+// public key, a one-time token, and an address on the local network.
+// The phone scans it and posts to /pair:
+//
+//	{"key": PHONE_PUBLIC_KEY, "mac": HMAC-SHA256(token, "letmein pair\0" || key)}
+//
+// with both values in unpadded base64url. The mac shows that the phone
+// saw the code, so nothing else on the network can ask for the store.
+// The reply is {"bundle": SEALED}, the snapshot sealed as stored
+// secrets are (see sealSecret) under
+//
+//	HMAC-SHA256(X25519 shared secret, "letmein pair\0" || our key || phone key)
+//
+// The private key is never written down, so the code alone cannot
+// open a recorded exchange. The snapshot holds the profiles as the
+// store does, so the phone still needs the master password.
+
+const pairTimeout = 5 * time.Minute
+
+// pairScheme starts the text in the QR code.
+const pairScheme = "letmein-pair"
+
+type pairRequest struct {
+	Key string `json:"key"`
+	MAC string `json:"mac"`
+}
+
+type pairReply struct {
+	Bundle string `json:"bundle,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+var pairEncoding = base64.RawURLEncoding
+
+// pairMAC is what the phone sends to show it holds the token.
+func pairMAC(token, phoneKey []byte) []byte {
+	mac := hmac.New(sha256.New, token)
+	mac.Write([]byte("letmein pair\x00"))
+	mac.Write(phoneKey)
+	return mac.Sum(nil)
+}
+
+// pairKey is the key the bundle is sealed under.
+func pairKey(shared, ourKey, phoneKey []byte) []byte {
+	mac := hmac.New(sha256.New, shared)
+	mac.Write([]byte("letmein pair\x00"))
+	mac.Write(ourKey)
+	mac.Write(phoneKey)
+	return mac.Sum(nil)
+}
+
+// pairSnapshot is the store as the phone gets it: the account and its
+// profiles, without deleted ones or anything that ties it to this
+// device or a sync server.
+func pairSnapshot(client *Client) ([]byte, int) {
+	snapshot := &Client{
+		Version:  storeVersion,
+		Name:     client.Name,
+		Verify:   client.Verify,
+		Verifier: client.Verifier,
+		NoVerify: client.NoVerify,
+		Profiles: []*Profile{},
+	}
+	for _, elt := range client.Profiles {
+		if !elt.IsDeleted() {
+			snapshot.Profiles = append(snapshot.Profiles, elt)
+		}
+	}
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		failf("Error encoding the store: %v\n", err)
+	}
+	return raw, len(snapshot.Profiles)
+}
+
+// localAddress guesses the address other devices on the network can
+// reach this one at.
+func localAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil && !ipnet.IP.IsLoopback() && !ipnet.IP.IsLinkLocalUnicast() {
+			return ipnet.IP.String()
+		}
+	}
+	return ""
+}
+
+func pairCommand() {
+	// gather options
+	registerVaultFlag()
+	host := ""
+	flag.StringVar(&host, "addr", host, "Address the phone should connect to (default: this device's address on the local network)")
+	port := 0
+	flag.IntVar(&port, "port", port, "Port to listen on (default: any free port)")
+	flag.Parse()
+	if len(flag.Args()) != 0 {
+		exitf(exitUsage, "letmein pair takes no arguments\n")
+	}
+	if host == "" {
+		if host = localAddress(); host == "" {
+			failf("Cannot tell this device's address on the local network; give it with -addr\n")
+		}
+	}
+
+	client := loadClient()
+	unlockStore()
+	bundle, count := pairSnapshot(client)
+	defer wipe(bundle)
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		failf("Error generating a key: %v\n", err)
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		failf("Error generating a token: %v\n", err)
+	}
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{Port: port})
+	if err != nil {
+		exitf(exitNetwork, "Error listening for the phone: %v\n", err)
+	}
+	defer ln.Close()
+	addr := net.JoinHostPort(host, strconv.Itoa(ln.Addr().(*net.TCPAddr).Port))
+
+	done := make(chan string, 1)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		reply := func(status int, body *pairReply) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(body)
+		}
+		if r.URL.Path != "/pair" || r.Method != "POST" {
+			reply(http.StatusNotFound, &pairReply{Error: "not found"})
+			return
+		}
+		req := new(pairRequest)
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(req); err != nil {
+			reply(http.StatusBadRequest, &pairReply{Error: "bad request"})
+			return
+		}
+		phoneKey, err1 := pairEncoding.DecodeString(req.Key)
+		mac, err2 := pairEncoding.DecodeString(req.MAC)
+		if err1 != nil || err2 != nil || !hmac.Equal(mac, pairMAC(token, phoneKey)) {
+			infof("refused a pairing request from %s", r.RemoteAddr)
+			reply(http.StatusForbidden, &pairReply{Error: "this is not the device that scanned the code"})
+			return
+		}
+		pub, err := ecdh.X25519().NewPublicKey(phoneKey)
+		if err != nil {
+			reply(http.StatusBadRequest, &pairReply{Error: "bad key"})
+			return
+		}
+		shared, err := priv.ECDH(pub)
+		if err != nil {
+			reply(http.StatusBadRequest, &pairReply{Error: "bad key"})
+			return
+		}
+		key := pairKey(shared, priv.PublicKey().Bytes(), phoneKey)
+		reply(http.StatusOK, &pairReply{Bundle: sealSecret(key, bundle)})
+		wipe(shared)
+		wipe(key)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		select {
+		case done <- r.RemoteAddr:
+		default:
+		}
+	}
+	server := &http.Server{Handler: http.HandlerFunc(handler), ReadTimeout: 30 * time.Second, WriteTimeout: 30 * time.Second}
+	go server.Serve(ln)
+	defer server.Close()
+
+	v := url.Values{}
+	v.Set("addr", addr)
+	v.Set("key", pairEncoding.EncodeToString(priv.PublicKey().Bytes()))
+	v.Set("token", pairEncoding.EncodeToString(token))
+	v.Set("name", client.Name)
+	code := pairScheme + ":?" + v.Encode()
+	printQR(code)
+	fmt.Fprintf(os.Stderr, "Scan this with the letmein app to copy %d profiles to it, or enter:\n%s\n", count, code)
+	fmt.Fprintf(os.Stderr, "Waiting up to %v on %s.\n", pairTimeout, addr)
+
+	select {
+	case remote := <-done:
+		fmt.Printf("sent the store to %s\n", remote)
+	case <-time.After(pairTimeout):
+		exitf(exitNetwork, "No phone connected within %v\n", pairTimeout)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// Two devices syncing directly prove to each other that they know the
+// master password with a password-authenticated key exchange, so that
+// neither a passive listener nor an active impostor learns anything to
+// test password guesses against offline. This is SPEKE on P-256: the
+// Diffie-Hellman generator is derived from the password, so only
+// someone who knows it can arrive at the same shared key. Only x
+// coordinates are exchanged, since x(k·P) = x(k·-P).
+
+// pakeSecret is what the exchange proves knowledge of. It is derived
+// with scrypt, so each guess an attacker makes costs as much as a login.
+func pakeSecret(master, account string) []byte {
+	return secretKey(master, "peer\t"+account)
+}
+
+// curveY returns a y for which (x, y) lies on P-256, or nil if there is
+// none.
+func curveY(x *big.Int) *big.Int {
+	params := elliptic.P256().Params()
+	p := params.P
+	if x.Sign() < 0 || x.Cmp(p) >= 0 {
+		return nil
+	}
+
+	// y² = x³ - 3x + b
+	y2 := new(big.Int).Mul(x, x)
+	y2.Mul(y2, x)
+	threeX := new(big.Int).Lsh(x, 1)
+	threeX.Add(threeX, x)
+	y2.Sub(y2, threeX)
+	y2.Add(y2, params.B)
+	y2.Mod(y2, p)
+	return new(big.Int).ModSqrt(y2, p)
+}
+
+// pointFromX builds the P-256 point with the given x coordinate.
+func pointFromX(x []byte) (*ecdh.PublicKey, error) {
+	if len(x) != 32 {
+		return nil, fmt.Errorf("bad key exchange value")
+	}
+	y := curveY(new(big.Int).SetBytes(x))
+	if y == nil {
+		return nil, fmt.Errorf("bad key exchange value")
+	}
+	encoded := make([]byte, 65)
+	encoded[0] = 4
+	copy(encoded[1:33], x)
+	y.FillBytes(encoded[33:])
+	return ecdh.P256().NewPublicKey(encoded)
+}
+
+// pakeGenerator maps the secret to a point by trying successive hashes
+// until one is the x coordinate of a point on the curve.
+func pakeGenerator(secret []byte) *ecdh.PublicKey {
+	for ctr := uint32(0); ; ctr++ {
+		h := sha256.New()
+		h.Write([]byte("letmein pake generator\x00"))
+		binary.Write(h, binary.BigEndian, ctr)
+		h.Write(secret)
+		if g, err := pointFromX(h.Sum(nil)); err == nil {
+			return g
+		}
+	}
+}
+
+// A pake is one side of an exchange.
+type pake struct {
+	priv      *ecdh.PrivateKey
+	generator *ecdh.PublicKey
+	Message   []byte
+}
+
+func newPAKE(secret []byte) (*pake, error) {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	g := pakeGenerator(secret)
+	msg, err := priv.ECDH(g)
+	if err != nil {
+		return nil, err
+	}
+	return &pake{priv: priv, generator: g, Message: msg}, nil
+}
+
+// Finish combines the other side's message with ours and the channel
+// the exchange ran over, returning a key both sides share only if they
+// used the same secret over the same channel.
+func (k *pake) Finish(peer, binding []byte) ([]byte, error) {
+	if bytes.Equal(peer, k.Message) {
+		return nil, fmt.Errorf("key exchange value was reflected")
+	}
+	pub, err := pointFromX(peer)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := k.priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	// both sides hash the messages in the same order
+	first, second := k.Message, peer
+	if bytes.Compare(first, second) > 0 {
+		first, second = second, first
+	}
+	mac := hmac.New(sha256.New, shared)
+	mac.Write([]byte("letmein pake key\x00"))
+	mac.Write(first)
+	mac.Write(second)
+	mac.Write(binding)
+	return mac.Sum(nil), nil
+}
+
+// pakeConfirm is the tag one side sends to prove it has the key.
+func pakeConfirm(key []byte, role string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("letmein pake confirm\x00" + role))
+	return mac.Sum(nil)
+}
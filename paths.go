@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// legacyFilename is where profiles were stored before platform-aware paths.
+var legacyFilename = filepath.Join(homeDir(), ".letmeinrc")
+
+func homeDir() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return home
+	}
+	return os.Getenv("HOME")
+}
+
+// dataDir returns the platform-appropriate directory for the profile store.
+func dataDir() string {
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return filepath.Join(dir, "letmein")
+		}
+		return filepath.Join(homeDir(), "AppData", "Roaming", "letmein")
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "letmein")
+	}
+	return filepath.Join(homeDir(), ".local", "share", "letmein")
+}
+
+// configDir returns the platform-appropriate directory for the config file.
+func configDir() string {
+	if runtime.GOOS == "windows" {
+		return dataDir()
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "letmein")
+	}
+	return filepath.Join(homeDir(), ".config", "letmein")
+}
+
+func defaultVaultPath() string {
+	return filepath.Join(dataDir(), "profiles.json")
+}
+
+// registerVaultFlag lets a command override the profile store location.
+func registerVaultFlag() {
+	flag.StringVar(&filename, "vault", filename, "Path to profile store (or set LETMEIN_VAULT)")
+}
+
+// migrateLegacyStore moves an existing ~/.letmeinrc to the default store
+// location. In read-only mode it reads the old store where it is.
+func migrateLegacyStore() {
+	if filename != defaultVaultPath() {
+		return
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		return
+	}
+	raw, err := ioutil.ReadFile(legacyFilename)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		failf("Error reading %s: %v\n", legacyFilename, err)
+	}
+	if readOnly {
+		filename = legacyFilename
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		failf("Error creating directory for %s: %v\n", filename, err)
+	}
+	if err := writeFileAtomic(filename, raw, 0600); err != nil {
+		failf("Error writing %s: %v\n", filename, err)
+	}
+	if err := os.Remove(legacyFilename); err != nil {
+		failf("Error removing %s after migration: %v\n", legacyFilename, err)
+	}
+	fmt.Fprintf(os.Stderr, "Moved profile data from %s to %s\n", legacyFilename, filename)
+}
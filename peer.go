@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	mathrand "math/rand"
+	"net"
+	"os"
+	"time"
+)
+
+// letmein sync -peer syncs two devices on the same network directly.
+// One device waits for a connection and advertises itself over
+// multicast DNS; the other finds it and connects. The connection is
+// TLS with throwaway certificates, and each side proves it knows the
+// master password with a key exchange bound to that TLS session (see
+// pake.go), so a device on the network that does not know it can
+// neither join nor sit in the middle. The device that connects merges
+// both stores and sends the result back, so the two end up the same.
+
+// peerTimeout bounds the wait for a peer and the sync itself.
+const peerTimeout = 2 * time.Minute
+
+// peerMessage is one step of the peer protocol, sent as JSON.
+type peerMessage struct {
+	PAKE     []byte     `json:"pake,omitempty"`
+	Confirm  []byte     `json:"confirm,omitempty"`
+	Profiles []*Profile `json:"profiles,omitempty"`
+	Done     bool       `json:"done,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+type peerConn struct {
+	conn *tls.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+func (c *peerConn) send(msg *peerMessage) {
+	if err := c.enc.Encode(msg); err != nil {
+		exitf(exitNetwork, "Error talking to the other device: %v\n", err)
+	}
+}
+
+func (c *peerConn) receive() *peerMessage {
+	msg := new(peerMessage)
+	if err := c.dec.Decode(msg); err != nil {
+		exitf(exitNetwork, "Error talking to the other device: %v\n", err)
+	}
+	if msg.Error != "" {
+		exitf(exitNetwork, "The other device stopped: %s\n", msg.Error)
+	}
+	return msg
+}
+
+// fail tells the other side why we are giving up, then exits.
+func (c *peerConn) fail(code int, f string, args ...interface{}) {
+	msg := fmt.Sprintf(f, args...)
+	c.enc.Encode(&peerMessage{Error: msg})
+	exitf(code, "%s\n", msg)
+}
+
+// peerTLSConfig makes a certificate for this session only; the key
+// exchange, not the certificate, authenticates the other side.
+func peerTLSConfig() *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		failf("Error generating a TLS key: %v\n", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		failf("Error creating a TLS certificate: %v\n", err)
+	}
+	return &tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		ClientAuth:         tls.RequireAnyClientCert,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+	}
+}
+
+// peerSync finds or waits for another device and syncs with it. With
+// addr set it connects there instead of looking on the network.
+func peerSync(now time.Time, client *Client, master, addr string) *Client {
+	if addr == "" {
+		// whichever device starts second finds the first
+		wait := 2*time.Second + time.Duration(mathrand.Int63n(int64(2*time.Second)))
+		found, err := mdnsBrowse(client.Name, wait)
+		if err != nil {
+			debugf("looking for a peer: %v", err)
+		}
+		addr = found
+	}
+	secret := pakeSecret(master, client.Name)
+	tlsConfig := peerTLSConfig()
+	if addr != "" {
+		infof("connecting to %s", addr)
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+		if err != nil {
+			exitf(exitNetwork, "Error connecting to %s: %v\n", addr, err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(peerTimeout))
+		return peerConnect(now, client, master, secret, addr, conn)
+	}
+
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	if err != nil {
+		exitf(exitNetwork, "Error listening for a peer: %v\n", err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	stop := make(chan struct{})
+	defer close(stop)
+	if err := mdnsAdvertise(client.Name, port, stop); err != nil {
+		infof("cannot advertise on the local network (%v); the other device needs -peer-addr", err)
+	}
+	fmt.Fprintf(os.Stderr, "Waiting for another device: run letmein sync -peer there (or -peer-addr HOST:%d).\n", port)
+	ln.SetDeadline(time.Now().Add(peerTimeout))
+	raw, err := ln.Accept()
+	if err != nil {
+		exitf(exitNetwork, "No other device connected: %v\n", err)
+	}
+	conn := tls.Server(raw, tlsConfig)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(peerTimeout))
+	return peerAccept(now, client, master, secret, raw.RemoteAddr().String(), conn)
+}
+
+// authenticate runs the key exchange over a fresh TLS connection. The
+// side that connected speaks first.
+func authenticate(conn *tls.Conn, secret []byte, connected bool) *peerConn {
+	if err := conn.Handshake(); err != nil {
+		exitf(exitNetwork, "Error setting up the connection: %v\n", err)
+	}
+	state := conn.ConnectionState()
+	binding, err := state.ExportKeyingMaterial("letmein peer sync", nil, 32)
+	if err != nil {
+		exitf(exitNetwork, "Error setting up the connection: %v\n", err)
+	}
+	c := &peerConn{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}
+	k, err := newPAKE(secret)
+	if err != nil {
+		failf("Error starting the key exchange: %v\n", err)
+	}
+	mismatch := "the other device does not have the same account and master password"
+
+	if connected {
+		c.send(&peerMessage{PAKE: k.Message})
+		reply := c.receive()
+		key, err := k.Finish(reply.PAKE, binding)
+		if err != nil {
+			c.fail(exitNetwork, "key exchange failed: %v", err)
+		}
+		if !hmac.Equal(reply.Confirm, pakeConfirm(key, "waiting")) {
+			c.fail(exitBadMaster, "%s", mismatch)
+		}
+		c.send(&peerMessage{Confirm: pakeConfirm(key, "connecting")})
+		return c
+	}
+
+	hello := c.receive()
+	key, err := k.Finish(hello.PAKE, binding)
+	if err != nil {
+		c.fail(exitNetwork, "key exchange failed: %v", err)
+	}
+	c.send(&peerMessage{PAKE: k.Message, Confirm: pakeConfirm(key, "waiting")})
+	if !hmac.Equal(c.receive().Confirm, pakeConfirm(key, "connecting")) {
+		c.fail(exitBadMaster, "%s", mismatch)
+	}
+	return c
+}
+
+// peerConnect is the connecting side: it fetches the other device's
+// profiles, merges them, and sends back the result.
+func peerConnect(now time.Time, client *Client, master string, secret []byte, addr string, conn *tls.Conn) *Client {
+	c := authenticate(conn, secret, true)
+	theirs := c.receive().Profiles
+
+	var signKey []byte
+	if config.SyncSignatures != signaturesOff {
+		signKey = signingKey(master, client.Name)
+	}
+	rec := &SyncRecord{At: now, Server: "peer " + addr, Status: "ok", Sent: countChanged(client)}
+	recordSnapshot("sync", client)
+	mergeUpdates(now, client, master, theirs, signKey, false, rec)
+	settleSynced(client)
+	c.send(&peerMessage{Profiles: syncCopies(client.Profiles, signKey)})
+	if !c.receive().Done {
+		exitf(exitNetwork, "The other device did not finish the sync\n")
+	}
+	recordSync(rec)
+	fmt.Fprintf(os.Stderr, "Synced with %s.\n", addr)
+	return client
+}
+
+// peerAccept is the waiting side: it sends its profiles and takes the
+// merged result.
+func peerAccept(now time.Time, client *Client, master string, secret []byte, addr string, conn *tls.Conn) *Client {
+	c := authenticate(conn, secret, false)
+	var signKey []byte
+	if config.SyncSignatures != signaturesOff {
+		signKey = signingKey(master, client.Name)
+	}
+	c.send(&peerMessage{Profiles: syncCopies(client.Profiles, signKey)})
+	merged := c.receive().Profiles
+
+	rec := &SyncRecord{At: now, Server: "peer " + addr, Status: "ok", Sent: countChanged(client)}
+	recordSnapshot("sync", client)
+	mergeUpdates(now, client, master, merged, signKey, false, rec)
+	settleSynced(client)
+	c.send(&peerMessage{Done: true})
+	recordSync(rec)
+	fmt.Fprintf(os.Stderr, "Synced with %s.\n", addr)
+	return client
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// With the pinentry setting, the master password is asked for by a
+// pinentry program, as GnuPG does: a graphical dialog with
+// pinentry-gnome3 or pinentry-mac, or a full-screen terminal prompt
+// with pinentry-curses. The program speaks the Assuan protocol on its
+// standard input and output.
+
+// assuanEscape encodes text for an Assuan command.
+func assuanEscape(s string) string {
+	s = strings.Replace(s, "%", "%25", -1)
+	s = strings.Replace(s, "\r", "%0D", -1)
+	return strings.Replace(s, "\n", "%0A", -1)
+}
+
+// assuanUnescape decodes the data in a D line.
+func assuanUnescape(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				out.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+// pinentryPrompt asks the pinentry program for a secret. It returns ""
+// if the user cancelled.
+func pinentryPrompt(prompt, desc string) (string, error) {
+	cmd := exec.Command(config.Pinentry)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	defer cmd.Wait()
+	defer in.Close()
+	r := bufio.NewReader(out)
+
+	// expect reads responses up to OK, returning the data sent with it
+	expect := func() (string, error) {
+		var data string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return "", fmt.Errorf("pinentry stopped: %v", err)
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case line == "OK" || strings.HasPrefix(line, "OK "):
+				return data, nil
+			case strings.HasPrefix(line, "D "):
+				data += assuanUnescape(line[2:])
+			case strings.HasPrefix(line, "ERR "):
+				return "", errPinentry(line[4:])
+			}
+		}
+	}
+	send := func(command string) (string, error) {
+		if _, err := io.WriteString(in, command+"\n"); err != nil {
+			return "", err
+		}
+		return expect()
+	}
+
+	if _, err := expect(); err != nil {
+		return "", err
+	}
+	commands := []string{
+		"SETTITLE letmein",
+		"SETPROMPT " + assuanEscape(prompt),
+		"SETDESC " + assuanEscape(desc),
+	}
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		commands = append(commands, "OPTION ttyname="+tty)
+	}
+	for _, c := range commands {
+		if _, err := send(c); err != nil {
+			return "", err
+		}
+	}
+	pin, err := send("GETPIN")
+	if e, ok := err.(errPinentry); ok && e.cancelled() {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	io.WriteString(in, "BYE\n")
+	return pin, nil
+}
+
+// errPinentry is an ERR response, such as "83886179 Operation cancelled".
+type errPinentry string
+
+func (e errPinentry) Error() string {
+	return "pinentry: " + string(e)
+}
+
+// cancelled reports whether the user closed the dialog.
+func (e errPinentry) cancelled() bool {
+	code, _ := strconv.Atoi(strings.Fields(string(e) + " 0")[0])
+	return code&0xffff == 99
+}
@@ -5,13 +5,12 @@ import (
 	"crypto/rand"
 	"fmt"
 	"math/big"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
-	"github.com/dchest/scrypt"
+	"golang.org/x/text/unicode/norm"
 )
 
 const (
@@ -25,6 +24,7 @@ const (
 	maxURLLength      = 256
 	minLength         = 1
 	maxLength         = 32
+	maxLengthLimit    = 128
 	defaultLength     = 16
 	minGeneration     = 0
 	maxGeneration     = 1 << 30
@@ -33,6 +33,11 @@ const (
 	maxChar           = 126
 
 	schemeScrypt = `scrypt(master\turl\tusername,generation,16384,8,1,length)`
+	schemeStored = `stored`
+
+	maxStoredLength = 1024
+	minPINLength    = 4
+	maxPINLength    = maxLengthLimit
 
 	scryptN = 16384
 	scryptR = 8
@@ -46,6 +51,7 @@ type Profile struct {
 	Name       string `json:"name,omitempty"`
 	Username   string `json:"username,omitempty"`
 	URL        string `json:"url,omitempty"`
+	Folder     string `json:"folder,omitempty"`
 	Generation int    `json:"generation,omitempty"`
 	Length     int    `json:"length,omitempty"`
 
@@ -54,10 +60,47 @@ type Profile struct {
 	Digits      bool   `json:"digits,omitempty"`
 	Punctuation bool   `json:"punctuation,omitempty"`
 	Spaces      bool   `json:"spaces,omitempty"`
+	Latin1      bool   `json:"latin1,omitempty"`
 	Include     string `json:"include,omitempty"`
 	Exclude     string `json:"exclude,omitempty"`
 
+	AutoType string `json:"autotype,omitempty"`
+
+	// Archived profiles are hidden from searches but kept and synced.
+	Archived bool `json:"archived,omitempty"`
+
+	// Favorite profiles are listed first in pickers.
+	Favorite bool `json:"favorite,omitempty"`
+
+	// Fields holds custom values, each encrypted with the master password.
+	Fields map[string]string `json:"fields,omitempty"`
+
+	Attachments []*Attachment `json:"attachments,omitempty"`
+
+	// Secret is the encrypted password for profiles using schemeStored.
+	Secret string `json:"secret,omitempty"`
+
+	// Codes holds the profile's encrypted 2FA recovery codes; see codes.go.
+	Codes string `json:"codes,omitempty"`
+
+	// FieldTimes records when each field was last changed, so sync can
+	// merge edits to different fields made on different devices.
+	FieldTimes map[string]*time.Time `json:"field_times,omitempty"`
+
+	// SyncHashes holds a hash of each merge field as of the last sync,
+	// so the next one can tell which side really changed it.
+	SyncHashes map[string]string `json:"sync_hashes,omitempty"`
+
+	// Vault is the ID of the shared vault this profile belongs to, if any.
+	Vault string `json:"vault,omitempty"`
+
+	// Signature authenticates the profile to other devices on sync, and
+	// Revision, which it covers, counts the versions sent; see sign.go.
+	Signature string `json:"sig,omitempty"`
+	Revision  int    `json:"rev,omitempty"`
+
 	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
 }
 
 // String gives back a printable summary of a profile.
@@ -65,6 +108,13 @@ func (p *Profile) String() string {
 	if p.Length == 0 {
 		return fmt.Sprintf("[deleted] uuid=%s", p.UUID)
 	}
+	modified := ""
+	if p.ModifiedAt != nil {
+		modified = "*"
+	}
+	if p.Scheme == schemeStored {
+		return fmt.Sprintf("%s[%s] user:%s url:%s stored", modified, p.Name, p.Username, p.URL)
+	}
 	charset := ""
 	if p.Lower {
 		charset += "a–z"
@@ -81,16 +131,15 @@ func (p *Profile) String() string {
 	if p.Spaces {
 		charset += "[space]"
 	}
+	if p.Latin1 {
+		charset += "[latin1]"
+	}
 	if p.Include != "" {
 		charset += "+[" + p.Include + "]"
 	}
 	if p.Exclude != "" {
 		charset += "-[" + p.Exclude + "]"
 	}
-	modified := ""
-	if p.ModifiedAt != nil {
-		modified = "*"
-	}
 	return fmt.Sprintf("%s[%s] user:%s url:%s gen:%d len:%d chars:%s", modified, p.Name, p.Username, p.URL, p.Generation, p.Length, charset)
 }
 
@@ -99,6 +148,23 @@ func (p *Profile) Match(search string) bool {
 	return !p.IsDeleted() && strings.Contains(strings.ToLower(p.Name), strings.ToLower(search))
 }
 
+// checkText checks the length (in characters) of a name, username, or
+// URL, and that it is valid UTF-8 without control characters.
+func checkText(s, what string, min, max int) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("%s is not valid UTF-8", what)
+	}
+	if n := utf8.RuneCountInString(s); n < min || n > max {
+		return fmt.Errorf("%s must be between %d and %d characters", what, min, max)
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%s contains an illegal character", what)
+		}
+	}
+	return nil
+}
+
 // Validate normalizes some profile parameters and verifies their validity.
 func (p *Profile) Validate() error {
 	// special case: deleted profile
@@ -107,6 +173,7 @@ func (p *Profile) Validate() error {
 		p.Name = ""
 		p.Username = ""
 		p.URL = ""
+		p.Folder = ""
 		p.Generation = 0
 		p.Length = 0
 		p.Lower = false
@@ -114,94 +181,168 @@ func (p *Profile) Validate() error {
 		p.Digits = false
 		p.Punctuation = false
 		p.Spaces = false
+		p.Latin1 = false
 		p.Include = ""
 		p.Exclude = ""
+		p.AutoType = ""
+		p.Archived = false
+		p.Favorite = false
+		p.Fields = nil
+		p.Attachments = nil
+		p.Secret = ""
+		p.Codes = ""
+		p.FieldTimes = nil
+		p.SyncHashes = nil
+		p.Signature = ""
+		p.Vault = ""
 
 		return nil
 	}
 
-	// scheme must be the only recognized scheme
-	if p.Scheme != schemeScrypt {
-		return fmt.Errorf("unknown scheme: I only recognize %s", schemeScrypt)
+	// scheme must be a recognized scheme; for scrypt, scrypt-v2, and
+	// scrypt-v3, the latin1 setting picks the scheme variant
+	if strings.HasPrefix(p.Scheme, "scrypt(") || isSampledScheme(p.Scheme) {
+		p.Scheme = withLatin1(p.Scheme, p.Latin1)
+	}
+	scheme, err := lookupScheme(p.Scheme)
+	if err != nil {
+		return err
+	}
+	exact := false
+	if e, ok := scheme.(exactInputer); ok {
+		exact = e.exactInputs()
 	}
 
 	// trim leading/trailing whitespace from profile name
-	p.Name = strings.TrimSpace(p.Name)
-
-	// name must be within length limits
-	if len(p.Name) < minNameLength || len(p.Name) > maxNameLength {
-		return fmt.Errorf("name must be between %d and %d characters", minNameLength, maxNameLength)
+	p.Name = norm.NFC.String(strings.TrimSpace(p.Name))
+	if err := checkText(p.Name, "name", minNameLength, maxNameLength); err != nil {
+		return err
 	}
 
-	// name must not contain illegal characters
-	for _, r := range p.Name {
-		if r < minChar || r > maxChar {
-			return fmt.Errorf("name contains an illegal character")
-		}
+	// convert username to lower case and trim leading/trailing whitespace.
+	// Usernames and URLs feed into the password, so they are normalized
+	// to NFC first: the same text typed on different systems must hash
+	// to the same bytes
+	p.Username = strings.TrimSpace(p.Username)
+	if !exact {
+		p.Username = norm.NFC.String(strings.ToLower(p.Username))
+	}
+	if err := checkText(p.Username, "username/email", minUsernameLength, maxUsernameLength); err != nil {
+		return err
 	}
 
-	// convert username to lower case and trim leading/trailing whitespace
-	p.Username = strings.ToLower(strings.TrimSpace(p.Username))
+	// convert URL to lower case and trim leading/trailing whitespace
+	p.URL = strings.TrimSpace(p.URL)
+	if !exact {
+		p.URL = norm.NFC.String(strings.ToLower(p.URL))
+	}
+	if err := checkText(p.URL, "website URL", minURLLength, maxURLLength); err != nil {
+		return err
+	}
 
-	// username must be within length limits
-	if len(p.Username) < minUsernameLength || len(p.Username) > maxUsernameLength {
-		return fmt.Errorf("username/email must be between %d and %d characters", minUsernameLength, maxUsernameLength)
+	// folders are slash-separated paths like work/aws
+	p.Folder = cleanFolder(p.Folder)
+	if err := checkText(p.Folder, "folder", 0, maxFolderLength); err != nil {
+		return err
 	}
 
-	// username must not contain illegal characters
-	for _, r := range p.Username {
-		if r < minChar || r > maxChar {
-			return fmt.Errorf("username/email contains an illegal character")
+	if p.Scheme == schemeStored {
+		// stored passwords are not derived, so only their length matters
+		if p.Length < minLength || p.Length > maxStoredLength {
+			return fmt.Errorf("stored password must be between %d and %d characters", minLength, maxStoredLength)
+		}
+		if !strings.HasPrefix(p.Secret, sealedPrefix) {
+			return fmt.Errorf("stored password is not encrypted")
 		}
-	}
 
-	// convert URL to lower case and trim leading/trailing whitespace
-	p.URL = strings.ToLower(strings.TrimSpace(p.URL))
+		// clear derivation settings that do not apply
+		p.Generation = 0
+		p.Lower = false
+		p.Upper = false
+		p.Digits = false
+		p.Punctuation = false
+		p.Spaces = false
+		p.Latin1 = false
+		p.Include = ""
+		p.Exclude = ""
+	} else if checker, ok := scheme.(profileChecker); ok {
+		if err := checker.checkProfile(p); err != nil {
+			return err
+		}
+	} else {
+		// generation must be within limits
+		if p.Generation < minGeneration || p.Generation > maxGeneration {
+			return fmt.Errorf("generation must be between %d and %d", minGeneration, maxGeneration)
+		}
 
-	// URL must be within length limits
-	if len(p.URL) < minURLLength || len(p.URL) > maxURLLength {
-		return fmt.Errorf("website URL must be between %d and %d characters", minURLLength, maxURLLength)
-	}
+		// length must be within limits; max_length, which digits-only
+		// PINs are exempt from, is checked by checkMaxLength
+		if p.Length < minLength || p.Length > maxLengthLimit {
+			return fmt.Errorf("length must be between %d and %d", minLength, maxLengthLimit)
+		}
 
-	// URL must not contain illegal characters
-	for _, r := range p.URL {
-		if r < minChar || r > maxChar {
-			return fmt.Errorf("website URL contains an illegal character")
+		// normalize includes and excludes
+		// and count the characters that we can use in passwords
+		include := new(bytes.Buffer)
+		exclude := new(bytes.Buffer)
+		count := 0
+		for r := rune(minChar); r <= rune(maxChar); r++ {
+			if p.CanUse(r) {
+				count++
+			}
+
+			if strings.ContainsRune(p.Exclude, r) {
+				exclude.WriteRune(r)
+			} else if strings.ContainsRune(p.Include, r) {
+				include.WriteRune(r)
+			}
 		}
-	}
+		for _, r := range latin1Symbols {
+			if p.CanUse(r) {
+				count++
+			}
+			if strings.ContainsRune(p.Exclude, r) {
+				exclude.WriteRune(r)
+			}
+		}
+		p.Include = include.String()
+		p.Exclude = exclude.String()
 
-	// generation must be within limits
-	if p.Generation < minGeneration || p.Generation > maxGeneration {
-		return fmt.Errorf("generation must be between %d and %d", minGeneration, maxGeneration)
-	}
+		// can we use > 1 characters?
+		if count < 2 {
+			return fmt.Errorf("profile does not allow > 1 possible character in password")
+		}
 
-	// length must be within limits
-	if p.Length < minLength || p.Length > maxLength {
-		return fmt.Errorf("length must be between %d and %d", minLength, maxLength)
 	}
 
-	// normalize includes and excludes
-	// and count the characters that we can use in passwords
-	include := new(bytes.Buffer)
-	exclude := new(bytes.Buffer)
-	count := 0
-	for r := rune(minChar); r <= rune(maxChar); r++ {
-		if p.CanUse(r) {
-			count++
+	// custom fields must have sensible names and encrypted values
+	for k, v := range p.Fields {
+		if k == "" || len(k) > maxFieldKeyLength {
+			return fmt.Errorf("field name must be between 1 and %d characters", maxFieldKeyLength)
+		}
+		for _, r := range k {
+			if r < minChar || r > maxChar {
+				return fmt.Errorf("field name contains an illegal character")
+			}
+		}
+		if !strings.HasPrefix(v, sealedPrefix) {
+			return fmt.Errorf("field %s is not encrypted", k)
 		}
+	}
 
-		if strings.ContainsRune(p.Exclude, r) {
-			exclude.WriteRune(r)
-		} else if strings.ContainsRune(p.Include, r) {
-			include.WriteRune(r)
+	// attachments must have names and valid blob references
+	for _, a := range p.Attachments {
+		if a.Name == "" || len(a.Name) > maxNameLength || strings.ContainsAny(a.Name, `/\`) {
+			return fmt.Errorf("invalid attachment name %q", a.Name)
+		}
+		if !validBlobID(a.Blob) {
+			return fmt.Errorf("attachment %s has an invalid blob ID", a.Name)
 		}
 	}
-	p.Include = include.String()
-	p.Exclude = exclude.String()
 
-	// can we use > 1 characters?
-	if count < 2 {
-		return fmt.Errorf("profile does not allow > 1 possible character in password")
+	// auto-type sequence must be well formed
+	if _, err := parseAutoType(p.AutoType); err != nil {
+		return err
 	}
 
 	if p.ModifiedAt != nil {
@@ -211,33 +352,102 @@ func (p *Profile) Validate() error {
 	return nil
 }
 
+// checkMaxLength applies the max_length setting to a length chosen on
+// this device; was is the length before, or 0 for a new profile.
+// Validate allows anything up to maxLengthLimit, so a profile made on a
+// device with a higher setting still works on the others.
+func (p *Profile) checkMaxLength(was int) error {
+	if p.Scheme == schemeStored || p.IsPIN() || p.Length <= config.MaxLength || p.Length <= was {
+		return nil
+	}
+	return fmt.Errorf("length must be between %d and %d (see the max_length setting)", minLength, config.MaxLength)
+}
+
 // Generate makes a password using the given master password.
 func (p *Profile) Generate(master string) string {
-	// generate the password
-	passwordPart := master + "\t" + p.URL + "\t" + p.Username
-	saltPart := strconv.Itoa(p.Generation)
-	hash, err := scrypt.Key([]byte(passwordPart), []byte(saltPart), scryptN, scryptR, scryptP, p.Length)
+	auditGeneration(p)
+
+	password, err := p.generate(master)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "scrypt error: %v\n", err)
-		os.Exit(1)
+		failf("%v\n", err)
 	}
+	return password
+}
 
-	// get the character set
-	chars := p.GetCharacterSet()
+// generate is the core of Generate, shared with the browser build: it
+// reports errors instead of exiting and keeps no audit log.
+func (p *Profile) generate(master string) (string, error) {
+	s, err := p.scheme()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", p.Name, err)
+	}
+	secret := []byte(master)
+	defer wipe(secret)
+	password, err := s.Password(p, secret)
+	if err != nil {
+		return "", fmt.Errorf("error generating password for %s: %v", p.Name, err)
+	}
+	defer wipe(password)
+	return string(password), nil
+}
 
-	// map the generated password to the character set
+// encodeCharset maps derived bytes onto length characters from chars,
+// treating the bytes as a fraction and drawing one digit at a time in
+// base len(chars). The result is UTF-8 in a buffer sized up front, so
+// no copy is left behind by growing it.
+func encodeCharset(hash []byte, chars []rune, length int) []byte {
 	pool := new(big.Int).SetBytes(hash)
 	poolSize := new(big.Int).SetBit(new(big.Int), len(hash)*8, 1)
 
-	out := new(bytes.Buffer)
-	for i := 0; i < p.Length; i++ {
+	out := make([]byte, 0, length*utf8.UTFMax)
+	for i := 0; i < length; i++ {
 		// generate one number in the range len(chars)
 		base := new(big.Int).Mul(pool, big.NewInt(int64(len(chars))))
 		quo, rem := new(big.Int).QuoRem(base, poolSize, new(big.Int))
 		pool = rem
-		out.WriteByte(chars[int(quo.Int64())])
+		out = utf8.AppendRune(out, chars[int(quo.Int64())])
+	}
+	return out
+}
+
+// DeriveKey derives n bytes of key material from the master password for
+// some purpose other than a password, such as an SSH key. The purpose is
+// mixed into the salt, so derived keys never coincide with the password.
+func (p *Profile) DeriveKey(master, purpose string, n int) []byte {
+	auditGeneration(p)
+	s, err := p.scheme()
+	if err != nil {
+		failf("%s: %v\n", p.Name, err)
+	}
+	secret := []byte(master)
+	defer wipe(secret)
+	key, err := s.Key(p, secret, purpose, n)
+	if err != nil {
+		failf("error deriving key for %s: %v\n", p.Name, err)
+	}
+	return key
+}
+
+// IsPIN returns true if this profile generates digits only.
+func (p *Profile) IsPIN() bool {
+	return p.Digits && !p.Lower && !p.Upper && !p.Punctuation && !p.Spaces && !p.Latin1 && p.Include == ""
+}
+
+// SetPIN configures the profile to generate a numeric PIN of length n.
+func (p *Profile) SetPIN(n int) error {
+	if n < minPINLength || n > maxPINLength {
+		return fmt.Errorf("PIN length must be between %d and %d", minPINLength, maxPINLength)
 	}
-	return out.String()
+	p.Length = n
+	p.Lower = false
+	p.Upper = false
+	p.Digits = true
+	p.Punctuation = false
+	p.Spaces = false
+	p.Latin1 = false
+	p.Include = ""
+	p.Exclude = ""
+	return nil
 }
 
 // IsDeleted returns true if this profile has been deleted.
@@ -247,6 +457,9 @@ func (p *Profile) IsDeleted() bool {
 
 // CanUse checks if a given rune can be included in a password based on this profile.
 func (p *Profile) CanUse(r rune) bool {
+	if strings.ContainsRune(latin1Symbols, r) {
+		return p.Latin1 && !strings.ContainsRune(p.Exclude, r)
+	}
 	if r < minChar || r > maxChar {
 		return false
 	}
@@ -287,9 +500,35 @@ func (p *Profile) GetCharacterSet() string {
 			buf.WriteRune(r)
 		}
 	}
+	for _, r := range latin1Symbols {
+		if p.CanUse(r) {
+			buf.WriteRune(r)
+		}
+	}
 	return buf.String()
 }
 
+// validUUID checks that a UUID has the form newUUID gives it, so it is
+// safe to use as a file name.
+func validUUID(uuid string) bool {
+	if len(uuid) != 36 {
+		return false
+	}
+	for i, r := range uuid {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func newUUID() string {
 	b := make([]byte, 16)
 	if _, err := rand.Reader.Read(b); err != nil {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// noInteractive disables prompts, for use in scripts.
+var noInteractive bool
+
+func registerInteractiveFlag() {
+	flag.BoolVar(&noInteractive, "no-interactive", false, "Never prompt; fail instead")
+}
+
+// interactive reports whether it is appropriate to prompt the user.
+func interactive() bool {
+	return !noInteractive && term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// readMasked reads a password from the terminal, echoing * for each
+// character, and wipes its buffer once the string copy has been made.
+// Raw mode works the same on Unix terminals and the Windows console.
+// Without a terminal it reads a line from standard input.
+func readMasked() string {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		line, _ := stdin.ReadString('\n')
+		return strings.TrimRight(line, "\r\n")
+	}
+	buf := make([]byte, 0, maxMasterLength*utf8.UTFMax)
+	defer func() { wipe(buf[:cap(buf)]) }()
+	key := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(key); err != nil || n == 0 {
+			break
+		}
+		c := key[0]
+		switch {
+		case c == '\r' || c == '\n':
+			term.Restore(fd, state)
+			fmt.Println()
+			return string(buf)
+		case c == 3: // Ctrl-C
+			term.Restore(fd, state)
+			fmt.Println()
+			exitf(exitError, "Interrupted\n")
+		case c == 4 && len(buf) == 0: // Ctrl-D
+			term.Restore(fd, state)
+			fmt.Println()
+			return ""
+		case c == 8 || c == 127: // backspace
+			if len(buf) > 0 {
+				_, size := utf8.DecodeLastRune(buf)
+				buf = buf[:len(buf)-size]
+				fmt.Print("\b \b")
+			}
+		case c == 21: // Ctrl-U
+			fmt.Print(strings.Repeat("\b \b", utf8.RuneCount(buf)))
+			buf = buf[:0]
+		case c < 0x20:
+		case len(buf) < cap(buf):
+			buf = append(buf, c)
+
+			// one * per character, not per byte
+			if utf8.RuneStart(c) {
+				fmt.Print("*")
+			}
+		}
+	}
+	term.Restore(fd, state)
+	fmt.Println()
+	return string(buf)
+}
+
+// registerForceFlag adds -force and its alias -yes, which skip
+// confirmation prompts.
+func registerForceFlag(force *bool) {
+	flag.BoolVar(force, "force", false, "Do not ask for confirmation")
+	flag.BoolVar(force, "yes", false, "Same as -force")
+}
+
+// confirm asks a yes/no question before a destructive change, failing
+// if the answer is no. With force it does not ask; without a terminal
+// it fails rather than guess.
+func confirm(force bool, format string, args ...interface{}) {
+	if force {
+		return
+	}
+	question := fmt.Sprintf(format, args...)
+	if !interactive() {
+		failf("Cannot ask %q without a terminal; use -force\n", question)
+	}
+	switch strings.ToLower(readLine(question + " [y/N] ")) {
+	case "y", "yes":
+	default:
+		failf("Canceled\n")
+	}
+}
+
+// readLine prompts and reads one line from standard input.
+func readLine(prompt string) string {
+	fmt.Print(prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		failf("\nError reading input: %v\n", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+// trailingFlags returns the arguments left after flag.Parse, also
+// parsing any flags given after the search term, as in
+// "letmein rename <query> -name <new>".
+func trailingFlags() []string {
+	args := flag.Args()
+	if len(args) > 1 {
+		term := args[0]
+		if err := flag.CommandLine.Parse(args[1:]); err != nil {
+			exitf(exitUsage, "%v\n", err)
+		}
+		args = append([]string{term}, flag.Args()...)
+	}
+	return args
+}
+
+// chooseProfile resolves search results to a single profile. When the
+// match is ambiguous it lets the user pick one from a numbered list, or
+// fails if prompting is not possible.
+func chooseProfile(matches []*Profile, term, verb string) *Profile {
+	if len(matches) == 0 {
+		exitf(exitNoMatch, "No matching profile found\n")
+	}
+	if q := uniqueMatch(matches, term); q != nil {
+		return q
+	}
+
+	fmt.Printf("Profile matches:\n")
+	for i, elt := range matches {
+		fmt.Printf("    %2d) %s\n", i+1, elt)
+	}
+	if !interactive() {
+		exitf(exitAmbiguous, "Cannot %s profile without a unique match\n", verb)
+	}
+	for {
+		line := readLine(fmt.Sprintf("Profile to %s (1-%d, blank to cancel): ", verb, len(matches)))
+		if line == "" {
+			failf("Canceled\n")
+		}
+		n, err := strconv.Atoi(line)
+		if err == nil && n >= 1 && n <= len(matches) {
+			return matches[n-1]
+		}
+		fmt.Printf("Please enter a number between 1 and %d\n", len(matches))
+	}
+}
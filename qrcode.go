@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// qrQuietZone is the blank border (in modules) scanners need around a code.
+const qrQuietZone = 2
+
+// printQR renders text as a QR code in the terminal, using half-block
+// characters so each line of output covers two rows of modules.
+func printQR(text string) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		failf("Error generating QR code: %v\n", err)
+	}
+
+	// modules outside the code are part of the (light) quiet zone
+	black := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < code.Size && y < code.Size && code.Black(x, y)
+	}
+
+	// light modules are drawn as blocks, so the code reads correctly on
+	// the usual dark terminal background
+	buf := new(strings.Builder)
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y += 2 {
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			top, bottom := !black(x, y), !black(x, y+1)
+			switch {
+			case top && bottom:
+				buf.WriteString("█")
+			case top:
+				buf.WriteString("▀")
+			case bottom:
+				buf.WriteString("▄")
+			default:
+				buf.WriteString(" ")
+			}
+		}
+		buf.WriteString("\n")
+	}
+	fmt.Print(buf.String())
+}
+
+// profileExport returns a profile's settings as compact JSON, without
+// local bookkeeping, for transfer to another device.
+func profileExport(p *Profile) string {
+	export := *p
+	export.ModifiedAt = nil
+	export.DeletedAt = nil
+	raw, err := json.Marshal(&export)
+	if err != nil {
+		failf("Error encoding profile: %v\n", err)
+	}
+	return string(raw)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"runtime"
+)
+
+// readOnly refuses every change to the profile store. It is set by the
+// -read-only global flag or the read_only config setting.
+var readOnly bool
+
+// checkWritable fails before any prompting if cmd would write the store
+// in read-only mode.
+func checkWritable(cmd *command, args []string) {
+	if !readOnly {
+		return
+	}
+	writes := cmd.writes
+	if cmd.name == "attach" && len(args) > 1 && (args[1] == "add" || args[1] == "rm") {
+		writes = true
+	}
+	if cmd.name == "codes" && len(args) > 1 && (args[1] == "add" || args[1] == "use") {
+		writes = true
+	}
+	if writes {
+		exitf(exitReadOnly, "letmein %s would modify %s, but read-only mode is on\n", cmd.name, filename)
+	}
+}
+
+// checkStorePermissions makes sure the store is private to its owner.
+// Stray bits that grant nobody else read access are quietly repaired;
+// a store that others can read is refused unless the user accepts it
+// with the allow_insecure_permissions setting.
+func checkStorePermissions() {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+	mode, want := info.Mode().Perm(), storeMode(info)
+	if mode == want {
+		return
+	}
+	if mode&0044 != 0 && !config.AllowInsecurePermissions {
+		failf("%s is readable by other users (mode %04o); run chmod %o on it, or set allow_insecure_permissions\n", filename, mode, want)
+	}
+	if mode&0044 == 0 && !readOnly {
+		if err := os.Chmod(filename, want); err != nil {
+			failf("Error fixing permissions on %s: %v\n", filename, err)
+		}
+	}
+}
+
+// storeMode is the mode a private store has: 0600 for a file, or 0700
+// for a directory.
+func storeMode(info os.FileInfo) os.FileMode {
+	if info.IsDir() {
+		return 0700
+	}
+	return 0600
+}
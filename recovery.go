@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sharePrefix = "letmein-share"
+
+// formatShare encodes a share as letmein-share-<k>-<x>-<hex>, so each
+// share says how many are needed to recover the secret.
+func formatShare(k int, x byte, data []byte) string {
+	return fmt.Sprintf("%s-%d-%d-%s", sharePrefix, k, x, hex.EncodeToString(data))
+}
+
+func parseShare(s string) (k int, x byte, data []byte, err error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 5 || parts[0]+"-"+parts[1] != sharePrefix {
+		return 0, 0, nil, fmt.Errorf("not a letmein share: %q", s)
+	}
+	k, err = strconv.Atoi(parts[2])
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("bad threshold in share %q", s)
+	}
+	xi, err := strconv.Atoi(parts[3])
+	if err != nil || xi < 1 || xi > 255 {
+		return 0, 0, nil, fmt.Errorf("bad index in share %q", s)
+	}
+	if data, err = hex.DecodeString(parts[4]); err != nil {
+		return 0, 0, nil, fmt.Errorf("bad data in share %q", s)
+	}
+	return k, byte(xi), data, nil
+}
+
+func recoveryCommand() {
+	if len(os.Args) < 2 {
+		failf("Usage: letmein recovery split|combine [options]\n")
+	}
+	sub := os.Args[1]
+	os.Args = os.Args[1:]
+	switch sub {
+	case "split":
+		recoverySplit()
+	case "combine":
+		recoveryCombine()
+	default:
+		failf("Unknown recovery command %q: use split or combine\n", sub)
+	}
+}
+
+// recoverySplit splits the master password into shares to hand out.
+func recoverySplit() {
+	now := time.Now().Round(time.Millisecond)
+
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	k, n, showQR := 2, 3, false
+	flag.IntVar(&k, "k", k, "Number of shares needed to recover")
+	flag.IntVar(&n, "n", n, "Number of shares to make")
+	flag.BoolVar(&showQR, "qr", showQR, "Show each share as a QR code")
+	flag.Parse()
+
+	// split what the user types, not a token-mixed secret
+	if master == "" {
+		master = readMaster()
+	}
+	client := getClient(now, getAndVerifyMaster(master))
+
+	// pad to a fixed size so shares do not reveal the password length;
+	// master passwords never contain NUL, so the padding is unambiguous
+	secret := make([]byte, maxMasterLength)
+	copy(secret, master)
+	defer wipe(secret)
+	shares, err := shamirSplit(secret, k, n)
+	if err != nil {
+		failf("Error splitting: %v\n", err)
+	}
+	fmt.Printf("Recovery shares for %s: any %d of these %d recover the master password.\n", client.Name, k, n)
+	if client.FIDO2 != nil {
+		fmt.Printf("This vault also requires its security key.\n")
+	}
+	for i, share := range shares {
+		text := formatShare(k, byte(i+1), share)
+		fmt.Printf("\nshare %d of %d:\n%s\n", i+1, n, text)
+		if showQR {
+			printQR(text)
+		}
+		wipe(share)
+	}
+}
+
+// recoveryCombine reads shares from the arguments or standard input,
+// one per line, and prints the recovered master password.
+func recoveryCombine() {
+	registerVaultFlag()
+	flag.Parse()
+	lines := flag.Args()
+	if len(lines) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	var xs []byte
+	var shares [][]byte
+	need := 0
+	for _, line := range lines {
+		k, x, data, err := parseShare(line)
+		if err != nil {
+			failf("%v\n", err)
+		}
+		need = k
+		xs = append(xs, x)
+		shares = append(shares, data)
+	}
+	if len(shares) < need || len(shares) == 0 {
+		failf("Need %d shares but only have %d\n", need, len(shares))
+	}
+	secret, err := shamirCombine(xs, shares)
+	if err != nil {
+		failf("Error combining shares: %v\n", err)
+	}
+	master := strings.TrimRight(string(secret), "\x00")
+	wipe(secret)
+
+	// check the result against the vault if there is one here
+	if client, err := readClient(); err == nil && client.FIDO2 == nil {
+		if err := client.checkMaster(master); err != nil {
+			failf("The shares do not recover this vault's master password\n")
+		}
+	}
+	fmt.Printf("master password: %s\n", master)
+}
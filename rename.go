@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// renameProfile changes only a profile's display name. The name is not
+// an input to password derivation, so the password stays the same.
+func renameProfile() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	registerVaultFlag()
+	name := ""
+	flag.StringVar(&name, "name", name, "New profile name (required)")
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	flag.Parse()
+
+	args := trailingFlags()
+	if name == "" {
+		exitf(exitUsage, "-name is required\n")
+	}
+	if len(args) != 1 {
+		exitf(exitUsage, "Must provide exactly one search term to find the profile to rename\n")
+	}
+	client := loadClient()
+
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	q := chooseProfile(client.Search(query), args[0], "rename")
+	for _, elt := range client.Profiles {
+		if elt != q && !elt.IsDeleted() && strings.EqualFold(elt.Name, strings.TrimSpace(name)) {
+			failf("Another profile is already named %s\n", elt.Name)
+		}
+	}
+	before := *q
+	q.Name = name
+	q.ModifiedAt = &now
+	if err := q.Validate(); err != nil {
+		failf("renamed profile is invalid, canceling: %v\n", err)
+	}
+
+	fmt.Printf("profile renamed: %s --> %s\n", before.Name, q.Name)
+	stampChanges(&before, q, now)
+	recordOp("rename", []*Profile{&before}, nil)
+
+	return client
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// maskedPassword stands in for a hidden password. It has a fixed
+// length so it does not give away the real one.
+const maskedPassword = "********"
+
+// defaultRevealSeconds is how long a revealed password stays on the
+// screen before it is blanked out.
+const defaultRevealSeconds = 15
+
+var (
+	showPasswords bool
+	copyPassword  bool
+
+	// pendingCopy is copied to the clipboard once the command has
+	// saved its changes, since copying waits to clear the clipboard
+	pendingCopy string
+)
+
+func registerRevealFlags() {
+	flag.BoolVar(&showPasswords, "show", false, "Print passwords instead of masking them (or set show_passwords)")
+	flag.BoolVar(&copyPassword, "copy", false, "Copy the password to the clipboard instead of printing it")
+}
+
+// passwordsHidden reports whether passwords should be masked: only on a
+// terminal, where they can be seen over a shoulder or in a recording.
+// Output to a pipe or file is left alone for scripts.
+func passwordsHidden() bool {
+	return !showPasswords && !config.ShowPasswords && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+func maskPassword(password string) string {
+	if password != "" && passwordsHidden() {
+		return maskedPassword
+	}
+	return password
+}
+
+// revealPassword follows the printing of a single, possibly masked,
+// password: it copies it if -copy was given, or else offers to show
+// or copy it with a keypress.
+func revealPassword(password string) {
+	if copyPassword {
+		pendingCopy = password
+		return
+	}
+	if !passwordsHidden() || !interactive() {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Press r to reveal the password, c to copy it, or any other key to continue: ")
+	key := readKey()
+	fmt.Fprintf(os.Stderr, "\n")
+	switch key {
+	case 'r', 'R':
+		printTimed(password)
+	case 'c', 'C':
+		pendingCopy = password
+	}
+}
+
+// printTimed shows a password on the terminal for reveal_seconds and
+// then overwrites it with blanks. Interrupting the wait blanks it too.
+func printTimed(password string) {
+	timeout := time.Duration(config.RevealSeconds) * time.Second
+	if timeout == 0 || !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Println(password)
+		return
+	}
+	fmt.Printf("%s  (hiding in %v)", password, timeout)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	select {
+	case <-time.After(timeout):
+	case <-interrupt:
+	}
+	blank := strings.Repeat(" ", displayWidth(password)+len("  (hiding in )")+len(timeout.String()))
+	fmt.Printf("\r%s\r%s\n", blank, maskedPassword)
+}
+
+// readKey reads a single keypress without waiting for Enter.
+func readKey() byte {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return 0
+	}
+	defer term.Restore(fd, state)
+	b := make([]byte, 1)
+	if _, err := os.Stdin.Read(b); err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+// finishCopy copies a password chosen for the clipboard, after the
+// command is otherwise done.
+func finishCopy() {
+	if pendingCopy != "" {
+		copyToClipboard(pendingCopy, clipboardTimeout())
+		pendingCopy = ""
+	}
+}
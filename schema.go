@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// storeVersion is the current format of the profile store. Stores
+// without a version field are version 0.
+const storeVersion = 1
+
+// A migration upgrades a store, decoded as raw JSON fields, by one
+// version. migrations[i] upgrades version i to version i+1.
+type migration func(store map[string]json.RawMessage) error
+
+var migrations = []migration{
+	// version 1 only adds the version field itself
+	func(store map[string]json.RawMessage) error { return nil },
+}
+
+// storeVersionOf reports the format version of a raw store.
+func storeVersionOf(raw []byte) (int, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return 0, err
+	}
+	return header.Version, nil
+}
+
+// decodeClient parses a profile store, upgrading older formats one
+// version at a time.
+func decodeClient(raw []byte) (*Client, error) {
+	version, err := storeVersionOf(raw)
+	if err != nil {
+		return nil, err
+	}
+	if version > storeVersion {
+		return nil, fmt.Errorf("store format %d is newer than this version of letmein supports (%d); upgrade letmein", version, storeVersion)
+	}
+	if version < storeVersion {
+		var store map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &store); err != nil {
+			return nil, err
+		}
+		for v := version; v < storeVersion; v++ {
+			if err := migrations[v](store); err != nil {
+				return nil, fmt.Errorf("upgrading store from format %d: %v", v, err)
+			}
+			debugf("upgraded store from format %d to %d", v, v+1)
+		}
+		store["version"] = json.RawMessage(fmt.Sprint(storeVersion))
+		if raw, err = json.Marshal(store); err != nil {
+			return nil, err
+		}
+	}
+	client := new(Client)
+	if err := json.Unmarshal(raw, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
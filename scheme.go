@@ -0,0 +1,316 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dchest/scrypt"
+)
+
+// Scheme derives the secrets for profiles that use it. A profile's
+// scheme string names the scheme and fixes its parameters, so the
+// same profile gives the same password on every device.
+type Scheme interface {
+	// Password returns the profile's password. The master password and
+	// the result are byte slices so the caller can wipe them.
+	Password(p *Profile, master []byte) ([]byte, error)
+
+	// Key derives n bytes for a purpose other than the password, like
+	// an SSH key. Different purposes give unrelated keys.
+	Key(p *Profile, master []byte, purpose string, n int) ([]byte, error)
+}
+
+// Schemes for passwords from other managers have their own rules, so
+// they may implement these as well:
+
+// exactInputer is a scheme that uses usernames and URLs exactly as
+// typed, without lower-casing or normalizing them.
+type exactInputer interface {
+	exactInputs() bool
+}
+
+// profileChecker is a scheme that checks generation, length, and
+// character settings itself instead of using letmein's limits.
+type profileChecker interface {
+	checkProfile(p *Profile) error
+}
+
+// defaultSetter is a scheme that fills in settings for new profiles.
+type defaultSetter interface {
+	setDefaults(p *Profile)
+}
+
+// schemes maps scheme names (the part of a scheme string before any
+// parameters) to functions that parse a full scheme string.
+var schemes = make(map[string]func(scheme string) (Scheme, error))
+
+// registerScheme adds a scheme. New derivation algorithms register
+// themselves from an init function.
+func registerScheme(name string, parse func(scheme string) (Scheme, error)) {
+	if _, exists := schemes[name]; exists {
+		panic("scheme registered twice: " + name)
+	}
+	schemes[name] = parse
+}
+
+// lookupScheme finds and parses a profile's scheme string.
+func lookupScheme(scheme string) (Scheme, error) {
+	name := scheme
+	if i := strings.IndexByte(scheme, '('); i >= 0 {
+		name = scheme[:i]
+	}
+	parse, ok := schemes[name]
+	if !ok {
+		var known []string
+		for elt := range schemes {
+			known = append(known, elt)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unknown scheme %q: this version of letmein knows %s; it may need an upgrade", scheme, strings.Join(known, ", "))
+	}
+	return parse(scheme)
+}
+
+func init() {
+	registerScheme("scrypt", func(scheme string) (Scheme, error) {
+		n, r, p, err := parseScryptScheme(scheme)
+		if err != nil {
+			return nil, err
+		}
+		return &scryptKDF{n: n, r: r, p: p}, nil
+	})
+}
+
+// scryptKDF is the original scheme: scrypt over the master password,
+// URL, and username, salted with the generation, with the output mapped
+// onto the profile's character set.
+type scryptKDF struct {
+	n, r, p int
+}
+
+func (s *scryptKDF) derive(p *Profile, master []byte, salt string, n int) ([]byte, error) {
+	passwordPart := joinSecret(master, p.URL, p.Username)
+	defer wipe(passwordPart)
+	key := kdfCacheKey(passwordPart, salt, s.n, s.r, s.p, n)
+	if hash, ok := derivedCache.get(key); ok {
+		return hash, nil
+	}
+	done := timed("scrypt derivation")
+	hash, err := scrypt.Key(passwordPart, []byte(salt), s.n, s.r, s.p, n)
+	if err != nil {
+		return nil, err
+	}
+	done()
+	derivedCache.put(key, hash)
+	return hash, nil
+}
+
+// Password draws one scrypt output byte per character: the output
+// length is the password length, so longer passwords draw on more
+// PBKDF2 output blocks while shorter ones are unchanged by the cap on
+// length.
+func (s *scryptKDF) Password(p *Profile, master []byte) ([]byte, error) {
+	hash, err := s.derive(p, master, strconv.Itoa(p.Generation), p.Length)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(hash)
+	return encodeCharset(hash, []rune(p.GetCharacterSet()), p.Length), nil
+}
+
+func (s *scryptKDF) Key(p *Profile, master []byte, purpose string, n int) ([]byte, error) {
+	// derived keys are not cached, since callers wipe them
+	passwordPart := joinSecret(master, p.URL, p.Username)
+	defer wipe(passwordPart)
+	salt := purpose + "\t" + strconv.Itoa(p.Generation)
+	return scrypt.Key(passwordPart, []byte(salt), s.n, s.r, s.p, n)
+}
+
+const (
+	minScryptN      = 1 << 14
+	maxScryptMemory = 1 << 30
+	maxScryptR      = 32
+	maxScryptP      = 16
+)
+
+var scryptSchemePattern = regexp.MustCompile(`^scrypt\(master\\turl\\tusername,generation,(\d+),(\d+),(\d+),length(,latin1)?\)$`)
+
+// latin1Variant marks schemes whose passwords may use latin1Symbols.
+// Older versions of letmein reject the unknown scheme instead of
+// silently generating a different password.
+const latin1Variant = ",latin1)"
+
+// latin1Symbols are the extra characters available with -latin1: Latin-1
+// symbols that are easy to tell apart and type with a compose key, and
+// which stay a single byte in Latin-1 encoded systems.
+const latin1Symbols = "¡¢£¥§©«®°±µ¶·»¿×÷"
+
+// withLatin1 adds or removes the latin1 variant from a scrypt,
+// scrypt-v2, or scrypt-v3 scheme.
+func withLatin1(scheme string, latin1 bool) string {
+	base := strings.TrimSuffix(scheme, latin1Variant)
+	if base != scheme {
+		base += ")"
+	}
+	if latin1 {
+		return strings.TrimSuffix(base, ")") + latin1Variant
+	}
+	return base
+}
+
+// scryptScheme formats a scheme string with the given cost parameters.
+func scryptScheme(n, r, p int) string {
+	return fmt.Sprintf(`scrypt(master\turl\tusername,generation,%d,%d,%d,length)`, n, r, p)
+}
+
+// parseScryptScheme extracts the cost parameters from a scheme string.
+func parseScryptScheme(scheme string) (n, r, p int, err error) {
+	m := scryptSchemePattern.FindStringSubmatch(scheme)
+	if m == nil {
+		return 0, 0, 0, fmt.Errorf("unknown scheme: I only recognize %s (with any cost parameters) and %s", schemeScrypt, schemeStored)
+	}
+	n, _ = strconv.Atoi(m[1])
+	r, _ = strconv.Atoi(m[2])
+	p, _ = strconv.Atoi(m[3])
+	return n, r, p, checkScryptParams(n, r, p)
+}
+
+// checkScryptParams verifies that cost parameters are usable.
+func checkScryptParams(n, r, p int) error {
+	if n < minScryptN || n&(n-1) != 0 {
+		return fmt.Errorf("scrypt N must be a power of 2 no smaller than %d", minScryptN)
+	}
+	if r < 1 || r > maxScryptR {
+		return fmt.Errorf("scrypt r must be between 1 and %d", maxScryptR)
+	}
+	if p < 1 || p > maxScryptP {
+		return fmt.Errorf("scrypt p must be between 1 and %d", maxScryptP)
+	}
+	if int64(128)*int64(n)*int64(r) > maxScryptMemory {
+		return fmt.Errorf("scrypt parameters need more than %d MiB of memory", maxScryptMemory>>20)
+	}
+	return nil
+}
+
+// scheme returns the Scheme for a profile, using the default scrypt
+// parameters for profiles without a scheme (like VerifyProfile).
+func (p *Profile) scheme() (Scheme, error) {
+	if p.Scheme == "" {
+		return &scryptKDF{n: scryptN, r: scryptR, p: scryptP}, nil
+	}
+	return lookupScheme(p.Scheme)
+}
+
+// defaultScheme is the scheme for new generated-password profiles.
+func defaultScheme() string {
+	return scryptScheme(config.ScryptN, config.ScryptR, config.ScryptP)
+}
+
+// newProfileScheme sets the scheme for a new profile from a -scheme
+// flag: scrypt (the default), scrypt-v2, or scrypt-v3, with the
+// configured costs, or the name of another registered scheme, like
+// lesspass-v2.
+func newProfileScheme(p *Profile, name string) error {
+	if name == "" || name == "scrypt" {
+		p.Scheme = defaultScheme()
+		return nil
+	}
+	if name == schemeScryptV2 {
+		p.Scheme = scryptV2Scheme(config.ScryptN, config.ScryptR, config.ScryptP)
+		return nil
+	}
+	if name == schemeScryptV3 {
+		p.Scheme = scryptV3Scheme(config.ScryptN, config.ScryptR, config.ScryptP)
+		return nil
+	}
+	if name == schemeStored {
+		return fmt.Errorf("use -stored to store a password")
+	}
+	s, err := lookupScheme(name)
+	if err != nil {
+		return err
+	}
+	p.Scheme = name
+	if d, ok := s.(defaultSetter); ok {
+		d.setDefaults(p)
+	}
+	return nil
+}
+
+func upgradeScheme() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	n, r, par := config.ScryptN, config.ScryptR, config.ScryptP
+	flag.IntVar(&n, "N", n, "scrypt CPU/memory cost (power of 2)")
+	flag.IntVar(&r, "r", r, "scrypt block size")
+	flag.IntVar(&par, "p", par, "scrypt parallelism")
+	v2 := false
+	flag.BoolVar(&v2, "v2", v2, "Switch to scrypt-v2, which picks characters without bias")
+	v3 := false
+	flag.BoolVar(&v3, "v3", v3, "Switch to scrypt-v3, which also expands one scrypt key with HKDF")
+	flag.Parse()
+	if v2 && v3 {
+		exitf(exitUsage, "-v2 and -v3 cannot be used together\n")
+	}
+	if err := checkScryptParams(n, r, par); err != nil {
+		failf("%v\n", err)
+	}
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+
+	// get search string
+	args := flag.Args()
+	if len(args) != 1 {
+		failf("Must provide exactly one search term to find profile to upgrade\n")
+	}
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	q := chooseProfile(client.Search(query), args[0], "upgrade")
+	isV2 := strings.HasPrefix(q.Scheme, schemeScryptV2+"(")
+	isV3 := strings.HasPrefix(q.Scheme, schemeScryptV3+"(")
+	if !strings.HasPrefix(q.Scheme, "scrypt(") && !isV2 && !isV3 {
+		failf("Only scrypt profiles can be upgraded; %s uses %s\n", q.Name, q.Scheme)
+	}
+	scheme := withLatin1(scryptScheme(n, r, par), q.Latin1)
+	switch {
+	case v3 || isV3:
+		scheme = withLatin1(scryptV3Scheme(n, r, par), q.Latin1)
+	case v2 || isV2:
+		scheme = withLatin1(scryptV2Scheme(n, r, par), q.Latin1)
+	}
+	if q.Scheme == scheme {
+		fmt.Fprintf(os.Stderr, "profile already uses %s\n", scheme)
+		return nil
+	}
+
+	// the password changes either way; bump the generation so the
+	// change is visible and matches what the user must do at the site
+	before := *q
+	q.Scheme = scheme
+	q.Generation++
+	q.ModifiedAt = &now
+	if err := q.Validate(); err != nil {
+		failf("upgraded profile is invalid, canceling: %v\n", err)
+	}
+
+	fmt.Printf("profile upgraded to %s: %s --> %s\n", scheme, q, q.Generate(master))
+	stampChanges(&before, q, now)
+	recordOp("upgrade-scheme", []*Profile{&before}, nil)
+
+	return client
+}
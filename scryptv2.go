@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// scrypt-v2 derives bytes the way the original scheme does but maps
+// them onto characters by rejection sampling. The original treats the
+// scrypt output as one big fraction and reads off base-k digits with
+// big.Int arithmetic. That is very nearly uniform (see letmein analyze),
+// but not exactly, and big.Int takes time that depends on the values.
+//
+// scrypt-v2 takes one byte at a time. A byte of 256 - 256%k or more is
+// thrown away. Any other byte picks character byte%k, so every
+// character is exactly equally likely. The pick scans the whole
+// character set, so it takes the same time whichever character it is.
+// Skipped bytes say nothing about the characters that were kept.
+//
+// scrypt output is PBKDF2, which makes the same leading bytes whatever
+// length is asked for. So when the bytes run out, a longer output
+// continues the same stream. The salt is marked v2, so a profile moved
+// to scrypt-v2 gets a password unrelated to its old one.
+//
+// scrypt-v3 maps the same way, but asks scrypt for one 32-byte key
+// whatever the length, and expands it with HKDF-SHA256 into as many
+// bytes as the sampling needs, so long passwords get the same margin as
+// short ones. HKDF also makes the same leading bytes whatever length is
+// asked for. It is a separate scheme so scrypt-v2 passwords never
+// change; its salt is marked v3.
+
+const (
+	schemeScryptV2 = "scrypt-v2"
+	schemeScryptV3 = "scrypt-v3"
+)
+
+// scryptV2Headroom is how many bytes beyond the length are derived at
+// first. Running out needs more than this many skips, which is rare.
+const scryptV2Headroom = 32
+
+// scryptV3Info labels the HKDF expansion, and scryptV3MaxExpand is the
+// most it can give.
+const (
+	scryptV3Info      = "letmein scrypt-v3 password"
+	scryptV3MaxExpand = 255 * sha256.Size
+)
+
+var (
+	scryptV2Pattern = regexp.MustCompile(`^scrypt-v2\(master\\turl\\tusername,generation,(\d+),(\d+),(\d+),length(,latin1)?\)$`)
+	scryptV3Pattern = regexp.MustCompile(`^scrypt-v3\(master\\turl\\tusername,generation,(\d+),(\d+),(\d+),length(,latin1)?\)$`)
+)
+
+func scryptV2Scheme(n, r, p int) string {
+	return fmt.Sprintf(`%s(master\turl\tusername,generation,%d,%d,%d,length)`, schemeScryptV2, n, r, p)
+}
+
+func scryptV3Scheme(n, r, p int) string {
+	return fmt.Sprintf(`%s(master\turl\tusername,generation,%d,%d,%d,length)`, schemeScryptV3, n, r, p)
+}
+
+// isSampledScheme reports whether a scheme string is scrypt-v2 or
+// scrypt-v3, which both map characters by rejection sampling.
+func isSampledScheme(scheme string) bool {
+	return strings.HasPrefix(scheme, schemeScryptV2+"(") || strings.HasPrefix(scheme, schemeScryptV3+"(")
+}
+
+func init() {
+	register := func(name string, pattern *regexp.Regexp, example func(n, r, p int) string, v3 bool) {
+		registerScheme(name, func(scheme string) (Scheme, error) {
+			m := pattern.FindStringSubmatch(scheme)
+			if m == nil {
+				return nil, fmt.Errorf("%s schemes look like %s", name, example(scryptN, scryptR, scryptP))
+			}
+			n, _ := strconv.Atoi(m[1])
+			r, _ := strconv.Atoi(m[2])
+			p, _ := strconv.Atoi(m[3])
+			if err := checkScryptParams(n, r, p); err != nil {
+				return nil, err
+			}
+			return &scryptV2{kdf: scryptKDF{n: n, r: r, p: p}, v3: v3}, nil
+		})
+	}
+	register(schemeScryptV2, scryptV2Pattern, scryptV2Scheme, false)
+	register(schemeScryptV3, scryptV3Pattern, scryptV3Scheme, true)
+}
+
+// scryptV2 is scrypt-v2, or scrypt-v3 when v3 is set.
+type scryptV2 struct {
+	kdf scryptKDF
+	v3  bool
+}
+
+func (s *scryptV2) name() string {
+	if s.v3 {
+		return schemeScryptV3
+	}
+	return schemeScryptV2
+}
+
+func (s *scryptV2) Password(p *Profile, master []byte) ([]byte, error) {
+	chars := []rune(p.GetCharacterSet())
+	if len(chars) == 0 || len(chars) > 256 {
+		return nil, fmt.Errorf("%s needs between 1 and 256 characters to choose from, not %d", s.name(), len(chars))
+	}
+	if s.v3 {
+		return s.expandedPassword(p, master, chars)
+	}
+	salt := "v2\t" + strconv.Itoa(p.Generation)
+	for n := p.Length + scryptV2Headroom; ; n *= 2 {
+		stream, err := s.kdf.derive(p, master, salt, n)
+		if err != nil {
+			return nil, err
+		}
+		password, ok := sampleCharset(stream, chars, p.Length)
+		wipe(stream)
+		if ok {
+			return password, nil
+		}
+	}
+}
+
+// expandedPassword is the scrypt-v3 password: one 32-byte scrypt key,
+// expanded with HKDF.
+func (s *scryptV2) expandedPassword(p *Profile, master []byte, chars []rune) ([]byte, error) {
+	key, err := s.kdf.derive(p, master, "v3\t"+strconv.Itoa(p.Generation), sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(key)
+	for n := p.Length + scryptV2Headroom; n <= scryptV3MaxExpand; n *= 2 {
+		stream := make([]byte, n)
+		if _, err := io.ReadFull(hkdf.Expand(sha256.New, key, []byte(scryptV3Info)), stream); err != nil {
+			return nil, err
+		}
+		password, ok := sampleCharset(stream, chars, p.Length)
+		wipe(stream)
+		if ok {
+			return password, nil
+		}
+	}
+	return nil, fmt.Errorf("%s ran out of bytes to sample", schemeScryptV3)
+}
+
+func (s *scryptV2) Key(p *Profile, master []byte, purpose string, n int) ([]byte, error) {
+	if s.v3 {
+		return s.kdf.Key(p, master, "v3\t"+purpose, n)
+	}
+	return s.kdf.Key(p, master, "v2\t"+purpose, n)
+}
+
+// sampleCharset maps bytes onto length characters from chars by
+// rejection sampling, reporting false if the bytes run out first.
+// chars must hold between 1 and 256 characters. The result is UTF-8 in
+// a buffer sized up front, so no copy is left behind by growing it.
+func sampleCharset(stream []byte, chars []rune, length int) ([]byte, bool) {
+	k := len(chars)
+	limit := 256 - 256%k
+	out := make([]byte, 0, length*utf8.UTFMax)
+	count := 0
+	for _, b := range stream {
+		if count == length {
+			break
+		}
+		if int(b) >= limit {
+			continue
+		}
+
+		// select without an index that depends on the byte
+		want, picked := int(b)%k, 0
+		for i, c := range chars {
+			picked = subtle.ConstantTimeSelect(subtle.ConstantTimeEq(int32(i), int32(want)), int(c), picked)
+		}
+		out = utf8.AppendRune(out, rune(picked))
+		count++
+	}
+	if count < length {
+		wipe(out)
+		return nil, false
+	}
+	return out, true
+}
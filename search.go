@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Query describes how to search for profiles.
+type Query struct {
+	Term  string
+	Regex bool
+	Fuzzy bool
+
+	// URL, if set, restricts matches to profiles on the same site or
+	// whose URL contains it.
+	URL string
+
+	// Folder, if set, restricts matches to profiles in that folder or
+	// its subfolders.
+	Folder string
+
+	// User, if set, restricts matches to usernames containing it.
+	User string
+
+	// Favorite restricts matches to favorite profiles.
+	Favorite bool
+
+	// Archived searches archived profiles instead of the rest.
+	Archived bool
+
+	// Any also searches auto-type sequences and the names of custom
+	// fields and attachments.
+	Any bool
+
+	re *regexp.Regexp
+}
+
+// field weights: matches on the name count for more than other fields
+const (
+	nameWeight     = 3
+	usernameWeight = 2
+	urlWeight      = 2
+)
+
+func registerQueryFlags(q *Query) {
+	flag.BoolVar(&q.Regex, "regex", false, "Treat the search term as a regular expression")
+	flag.BoolVar(&q.Fuzzy, "fuzzy", false, "Fuzzy-match the search term")
+	flag.StringVar(&q.User, "user", "", "Only match profiles whose username contains this")
+	flag.BoolVar(&q.Any, "any", false, "Also search auto-type, custom field names, and attachment names")
+	flag.BoolVar(&q.Archived, "archived", false, "Search archived profiles instead of the others")
+}
+
+// Compile prepares the query for use; it must be called after Term is set.
+func (q *Query) Compile() error {
+	if q.Regex {
+		re, err := regexp.Compile("(?i)" + q.Term)
+		if err != nil {
+			return err
+		}
+		q.re = re
+	}
+	return nil
+}
+
+// Score rates how well a profile matches the query. Zero means no match.
+func (q *Query) Score(p *Profile) int {
+	if p.IsDeleted() || p.Archived != q.Archived {
+		return 0
+	}
+	if q.URL != "" && !SameSite(q.URL, p.URL) && !containsFold(p.URL, q.URL) {
+		return 0
+	}
+	if q.Folder != "" && !inFolder(p.Folder, q.Folder) {
+		return 0
+	}
+	if q.Favorite && !p.Favorite {
+		return 0
+	}
+	if q.User != "" && !containsFold(p.Username, q.User) {
+		return 0
+	}
+	if q.Term == "" {
+		return 1
+	}
+	type field struct {
+		text   string
+		weight int
+	}
+	fields := []field{
+		{p.Name, nameWeight},
+		{p.Username, usernameWeight},
+		{p.URL, urlWeight},
+	}
+	if q.Any {
+		fields = append(fields, field{p.AutoType, 1}, field{p.Folder, 1})
+		for name := range p.Fields {
+			fields = append(fields, field{name, 1})
+		}
+		for _, a := range p.Attachments {
+			fields = append(fields, field{a.Name, 1})
+		}
+	}
+	best := 0
+	for _, f := range fields {
+		if score := q.scoreText(f.text) * f.weight; score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+func (q *Query) scoreText(text string) int {
+	if text == "" {
+		return 0
+	}
+	switch {
+	case q.re != nil:
+		loc := q.re.FindStringIndex(text)
+		if loc == nil {
+			return 0
+		}
+		if loc[0] == 0 && loc[1] == len(text) {
+			return 100
+		}
+		return 10
+	case q.Fuzzy:
+		return fuzzyScore(strings.ToLower(q.Term), strings.ToLower(text))
+	default:
+		term, text := strings.ToLower(q.Term), strings.ToLower(text)
+		switch {
+		case text == term:
+			return 100
+		case strings.HasPrefix(text, term):
+			return 50
+		case strings.Contains(text, term):
+			return 10
+		}
+		return 0
+	}
+}
+
+func containsFold(text, sub string) bool {
+	return strings.Contains(strings.ToLower(text), strings.ToLower(sub))
+}
+
+// matchSpan locates the part of text that matches the query, for
+// highlighting. It returns -1, -1 if there is none to show.
+func (q *Query) matchSpan(text string) (int, int) {
+	switch {
+	case q.Term == "" || text == "":
+		return -1, -1
+	case q.re != nil:
+		if loc := q.re.FindStringIndex(text); loc != nil && loc[1] > loc[0] {
+			return loc[0], loc[1]
+		}
+	case q.Fuzzy:
+	default:
+		// lower-casing some non-ASCII text changes its length, which
+		// would throw off the offsets
+		lower, term := strings.ToLower(text), strings.ToLower(q.Term)
+		if len(lower) != len(text) || len(term) != len(q.Term) {
+			break
+		}
+		if i := strings.Index(lower, term); i >= 0 {
+			return i, i + len(term)
+		}
+	}
+	return -1, -1
+}
+
+// fuzzyScore matches term as a subsequence of text, rewarding runs of
+// consecutive characters and matches at the start of the text.
+func fuzzyScore(term, text string) int {
+	if term == text {
+		return 100
+	}
+	score, run, pos := 0, 0, 0
+	for _, r := range term {
+		i := strings.IndexRune(text[pos:], r)
+		if i < 0 {
+			return 0
+		}
+		if i == 0 {
+			run++
+		} else {
+			run = 1
+		}
+		if pos+i == 0 {
+			score += 5
+		}
+		score += run
+		pos += i + len(string(r))
+	}
+	return score
+}
+
+// Search returns the profiles matching a query, best matches first.
+func (c *Client) Search(q *Query) []*Profile {
+	type scored struct {
+		p     *Profile
+		score int
+	}
+	var hits []scored
+	for _, elt := range c.Profiles {
+		if score := q.Score(elt); score > 0 {
+			hits = append(hits, scored{elt, score})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return strings.ToLower(hits[i].p.Name) < strings.ToLower(hits[j].p.Name)
+	})
+	out := []*Profile{}
+	for _, elt := range hits {
+		out = append(out, elt.p)
+	}
+	return out
+}
+
+// uniqueMatch picks the single profile a search refers to: either the
+// only match, or the only match whose name is exactly the search term.
+func uniqueMatch(matches []*Profile, term string) *Profile {
+	if len(matches) == 1 {
+		return matches[0]
+	}
+	var exact *Profile
+	for _, elt := range matches {
+		if strings.EqualFold(elt.Name, term) {
+			if exact != nil {
+				return nil
+			}
+			exact = elt
+		}
+	}
+	return exact
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Releases are announced in a manifest per channel at
+// releaseURL/CHANNEL.json, listing a binary for each platform with its
+// SHA-256 and an Ed25519 signature by the release key. The signature
+// covers the channel, version, platform, and hash together, so a
+// binary cannot be passed off as a different release or platform.
+//
+// version and releaseKey are set when a release is built:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.releaseKey=BASE64KEY"
+
+var (
+	version    = "dev"
+	releaseKey = ""
+)
+
+const (
+	defaultReleaseURL = "https://letmein-app.appspot.com/releases"
+	maxReleaseSize    = 64 << 20
+)
+
+var releaseChannels = []string{"stable", "beta"}
+
+type releaseManifest struct {
+	Channel  string                    `json:"channel"`
+	Version  string                    `json:"version"`
+	Binaries map[string]*releaseBinary `json:"binaries"`
+}
+
+type releaseBinary struct {
+	URL       string `json:"url"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+// releaseMessage is what the release key signs for one binary.
+func releaseMessage(channel, version, platform, sum string) []byte {
+	return []byte("letmein release\x00" + channel + "\x00" + version + "\x00" + platform + "\x00" + sum)
+}
+
+// compareVersions compares dotted version numbers, returning -1, 0, or
+// 1. A leading v and anything after a - or + are ignored.
+func compareVersions(a, b string) int {
+	parse := func(v string) []int {
+		v = strings.TrimPrefix(v, "v")
+		if i := strings.IndexAny(v, "-+"); i >= 0 {
+			v = v[:i]
+		}
+		var out []int
+		for _, part := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(part)
+			out = append(out, n)
+		}
+		return out
+	}
+	x, y := parse(a), parse(b)
+	for i := 0; i < len(x) || i < len(y); i++ {
+		var m, n int
+		if i < len(x) {
+			m = x[i]
+		}
+		if i < len(y) {
+			n = y[i]
+		}
+		switch {
+		case m < n:
+			return -1
+		case m > n:
+			return 1
+		}
+	}
+	return 0
+}
+
+func selfUpdate() {
+	// gather options
+	channel := releaseChannels[0]
+	flag.StringVar(&channel, "channel", channel, "Release channel: "+strings.Join(releaseChannels, " or "))
+	check := false
+	flag.BoolVar(&check, "check", check, "Only report whether an update is available")
+	force := false
+	flag.BoolVar(&force, "force", force, "Install the release even if it is not newer than this one")
+	url := defaultReleaseURL
+	flag.StringVar(&url, "url", url, "Where release manifests are published")
+	flag.Parse()
+	known := false
+	for _, elt := range releaseChannels {
+		known = known || elt == channel
+	}
+	if !known {
+		exitf(exitUsage, "Unknown channel %q; use %s\n", channel, strings.Join(releaseChannels, " or "))
+	}
+	if releaseKey == "" {
+		failf("This letmein was built without a release key, so it cannot verify updates; update it the way you installed it\n")
+	}
+	pub, err := base64.StdEncoding.DecodeString(releaseKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		failf("This letmein was built with a bad release key\n")
+	}
+
+	// find the release
+	manifestURL := strings.TrimRight(url, "/") + "/" + channel + ".json"
+	raw, err := download(manifestURL, 1<<20)
+	if err != nil {
+		exitf(exitNetwork, "Error checking for updates: %v\n", err)
+	}
+	manifest := new(releaseManifest)
+	if err := json.Unmarshal(raw, manifest); err != nil {
+		exitf(exitNetwork, "Error parsing %s: %v\n", manifestURL, err)
+	}
+	if manifest.Channel != channel || manifest.Version == "" {
+		exitf(exitNetwork, "%s is not a manifest for the %s channel\n", manifestURL, channel)
+	}
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	bin := manifest.Binaries[platform]
+	if bin == nil {
+		failf("The %s release %s has no build for %s\n", channel, manifest.Version, platform)
+	}
+	newer := version != "dev" && compareVersions(manifest.Version, version) > 0
+	switch {
+	case check && newer:
+		fmt.Printf("letmein %s is available (this is %s); run letmein self-update -channel %s\n", manifest.Version, version, channel)
+		return
+	case check || !newer && !force:
+		fmt.Printf("letmein %s is up to date (the latest %s release is %s)\n", version, channel, manifest.Version)
+		return
+	}
+
+	// fetch and verify the binary before touching anything
+	sig, err := base64.StdEncoding.DecodeString(bin.Signature)
+	if err != nil || !ed25519.Verify(pub, releaseMessage(channel, manifest.Version, platform, bin.SHA256), sig) {
+		failf("The signature on letmein %s for %s does not verify; not updating\n", manifest.Version, platform)
+	}
+	binary, err := download(bin.URL, maxReleaseSize)
+	if err != nil {
+		exitf(exitNetwork, "Error downloading letmein %s: %v\n", manifest.Version, err)
+	}
+	sum := sha256.Sum256(binary)
+	if hex.EncodeToString(sum[:]) != strings.ToLower(bin.SHA256) {
+		failf("The download of letmein %s does not match its signed hash; not updating\n", manifest.Version)
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		failf("Error finding the letmein executable: %v\n", err)
+	}
+	if err := replaceExecutable(exe, binary); err != nil {
+		failf("Error replacing %s: %v\n", exe, err)
+	}
+	fmt.Printf("updated %s from %s to %s\n", exe, version, manifest.Version)
+}
+
+// download fetches a URL, refusing bodies larger than limit.
+func download(url string, limit int64) ([]byte, error) {
+	resp, err := sendRequest("GET", url, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) > limit {
+		return nil, fmt.Errorf("%s is too large", url)
+	}
+	return raw, nil
+}
+
+// replaceExecutable swaps in a new binary next to the old one and
+// renames it into place. Windows will not replace a running program,
+// so there the old one is moved aside to .old, which the next update
+// removes.
+func replaceExecutable(exe string, binary []byte) error {
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(exe); err == nil {
+		mode = info.Mode().Perm()
+	}
+	next := exe + ".new"
+	if err := writeFileAtomic(next, binary, mode); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			os.Remove(next)
+			return err
+		}
+		if err := os.Rename(next, exe); err != nil {
+			os.Rename(old, exe)
+			return err
+		}
+		return nil
+	}
+	if err := os.Rename(next, exe); err != nil {
+		os.Remove(next)
+		return err
+	}
+	return nil
+}
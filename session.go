@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// letmein unlock asks for the master password once and keeps it for a
+// few minutes, so the commands that follow do not ask again. There is
+// no agent process. The password is sealed in a session file under a
+// random key, and the key is kept only where it cannot outlive the
+// login: the kernel's session keyring on Linux, which also drops it
+// when the session expires, or else a file in the per-user runtime
+// directory, which is kept in memory and emptied at logout. Where
+// neither exists, unlock refuses rather than leave something on disk
+// that opens the password. The sealing key covers the expiry time, so
+// pushing it back breaks the session. letmein lock removes both early.
+
+const defaultSessionMinutes = 15
+
+type sessionFile struct {
+	Expires time.Time `json:"expires"`
+	Master  string    `json:"master"`
+}
+
+// sessionName identifies the session for this user and store.
+func sessionName() string {
+	sum := sha256.Sum256([]byte(filename))
+	return fmt.Sprintf("letmein-session-%d-%s", os.Getuid(), hex.EncodeToString(sum[:6]))
+}
+
+func sessionFilename() string {
+	// not the shared temporary directory, where others could plant one
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = dataDir()
+	}
+	return filepath.Join(dir, sessionName())
+}
+
+// sessionKeyFilename is where the session key goes without a kernel
+// keyring, or "" where there is no runtime directory either.
+func sessionKeyFilename() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, sessionName()+".key")
+}
+
+// saveSessionKey keeps the random key for a session somewhere it does
+// not outlive the login.
+func saveSessionKey(key []byte, ttl time.Duration) error {
+	err := keyringPut(sessionName(), key, ttl)
+	if err == nil {
+		return nil
+	}
+	debugf("cannot use the kernel keyring: %v", err)
+	path := sessionKeyFilename()
+	if path == "" {
+		return fmt.Errorf("there is no kernel keyring or per-user runtime directory (XDG_RUNTIME_DIR) to keep the session key in")
+	}
+	return writeFileAtomic(path, key, 0600)
+}
+
+func loadSessionKey() []byte {
+	if key, err := keyringGet(sessionName()); err == nil {
+		return key
+	}
+	if path := sessionKeyFilename(); path != "" {
+		if key, err := ioutil.ReadFile(path); err == nil {
+			return key
+		}
+	}
+	return nil
+}
+
+// endSession removes the session file and its key.
+func endSession() error {
+	keyringRemove(sessionName())
+	if path := sessionKeyFilename(); path != "" {
+		os.Remove(path)
+	}
+	if err := os.Remove(sessionFilename()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// sessionKey binds the random key to the expiry time.
+func sessionKey(key []byte, expires time.Time) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("letmein session\x00"))
+	mac.Write([]byte(strconv.FormatInt(expires.UnixNano(), 10)))
+	return mac.Sum(nil)
+}
+
+// readSession returns the master password from a current session, or
+// "" if there is none. An expired or unreadable session is removed.
+func readSession() string {
+	path := sessionFilename()
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	s := new(sessionFile)
+	if err := json.Unmarshal(raw, s); err != nil || time.Now().After(s.Expires) {
+		endSession()
+		return ""
+	}
+	key := loadSessionKey()
+	if key == nil {
+		debugf("ignoring session %s: its key is gone", path)
+		endSession()
+		return ""
+	}
+	defer wipe(key)
+	plain, err := openSecret(sessionKey(key, s.Expires), s.Master)
+	if err != nil {
+		debugf("ignoring session %s: %v", path, err)
+		endSession()
+		return ""
+	}
+	defer wipe(plain)
+	debugf("using the session unlocked until %s", s.Expires.Local().Format("15:04"))
+	return string(plain)
+}
+
+func writeSession(master string, expires time.Time) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	defer wipe(key)
+	if err := saveSessionKey(key, time.Until(expires)); err != nil {
+		return err
+	}
+	s := &sessionFile{Expires: expires}
+	plain := []byte(master)
+	defer wipe(plain)
+	s.Master = sealSecret(sessionKey(key, s.Expires), plain)
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(sessionFilename(), raw, 0600)
+}
+
+func unlockCommand() {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	minutes := config.SessionMinutes
+	flag.IntVar(&minutes, "minutes", minutes, "How long the session lasts (or set session_minutes)")
+	flag.Parse()
+	if minutes < 1 {
+		exitf(exitUsage, "-minutes must be at least 1\n")
+	}
+
+	// always ask, even inside a session
+	skipSession = true
+	if master == "" {
+		master = readMaster()
+	}
+	if master == "" {
+		exitf(exitBadMaster, "master password is required\n")
+	}
+	getClient(now, getAndVerifyMaster(master))
+
+	expires := now.Add(time.Duration(minutes) * time.Minute)
+	if err := writeSession(master, expires); err != nil {
+		failf("Cannot start a session: %v\n", err)
+	}
+	fmt.Printf("unlocked until %s; run letmein lock to end the session early\n", expires.Local().Format("15:04"))
+}
+
+func lockCommand() {
+	registerVaultFlag()
+	flag.Parse()
+	if err := endSession(); err != nil {
+		failf("Error removing %s: %v\n", sessionFilename(), err)
+	}
+	fmt.Printf("locked\n")
+}
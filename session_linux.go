@@ -0,0 +1,67 @@
+//go:build linux
+
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The session key goes in the kernel's session keyring as a user key,
+// which lives only in kernel memory and which the kernel drops when the
+// session ends or the timeout passes. A process started outside a login
+// session has no session keyring of its own; the kernel then stands in
+// the user's session keyring, which is shared by that user's processes.
+
+// sessionKeyring finds the keyring without creating a new session one,
+// which would last only as long as this process.
+func sessionKeyring() (int, error) {
+	return unix.KeyctlGetKeyringID(unix.KEY_SPEC_SESSION_KEYRING, false)
+}
+
+func keyringPut(name string, key []byte, ttl time.Duration) error {
+	ring, err := sessionKeyring()
+	if err != nil {
+		return err
+	}
+	id, err := unix.AddKey("user", name, key, ring)
+	if err != nil {
+		return err
+	}
+	if _, err := unix.KeyctlInt(unix.KEYCTL_SET_TIMEOUT, id, int(ttl/time.Second)+1, 0, 0); err != nil {
+		unix.KeyctlInt(unix.KEYCTL_INVALIDATE, id, 0, 0, 0)
+		return err
+	}
+	return nil
+}
+
+func keyringGet(name string) ([]byte, error) {
+	ring, err := sessionKeyring()
+	if err != nil {
+		return nil, err
+	}
+	id, err := unix.KeyctlSearch(ring, "user", name, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 64)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n > len(buf) {
+		n = len(buf)
+	}
+	return buf[:n], nil
+}
+
+func keyringRemove(name string) {
+	ring, err := sessionKeyring()
+	if err != nil {
+		return
+	}
+	if id, err := unix.KeyctlSearch(ring, "user", name, 0); err == nil {
+		unix.KeyctlInt(unix.KEYCTL_INVALIDATE, id, 0, 0, 0)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var errNoKeyring = errors.New("no kernel keyring on this system")
+
+func keyringPut(name string, key []byte, ttl time.Duration) error { return errNoKeyring }
+
+func keyringGet(name string) ([]byte, error) { return nil, errNoKeyring }
+
+func keyringRemove(name string) {}
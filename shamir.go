@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Shamir secret sharing over GF(2^8), one polynomial per secret byte.
+// Share x-coordinates run from 1 to n; x = 0 is the secret itself.
+
+var gfExp, gfLog [256]byte
+
+func init() {
+	// 3 generates the multiplicative group modulo the AES polynomial
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+		x = gfMulSlow(x, 3)
+	}
+	gfExp[255] = gfExp[0]
+}
+
+func gfMulSlow(a, b byte) byte {
+	var p byte
+	for b != 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+int(gfLog[b]))%255]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+255-int(gfLog[b]))%255]
+}
+
+// shamirSplit splits secret into n shares, any k of which recover it.
+// Share i is evaluated at x = i+1.
+func shamirSplit(secret []byte, k, n int) ([][]byte, error) {
+	if k < 2 || k > n || n > 255 {
+		return nil, fmt.Errorf("need 2 <= k <= n <= 255")
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	coeffs := make([]byte, k)
+	defer wipe(coeffs)
+	for j, s := range secret {
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			// Horner's rule at x = i+1
+			x := byte(i + 1)
+			var y byte
+			for c := k - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coeffs[c]
+			}
+			shares[i][j] = y
+		}
+	}
+	return shares, nil
+}
+
+// shamirCombine recovers the secret by Lagrange interpolation at x = 0.
+func shamirCombine(xs []byte, shares [][]byte) ([]byte, error) {
+	if len(xs) == 0 || len(xs) != len(shares) {
+		return nil, errors.New("no shares")
+	}
+	for i := range xs {
+		if xs[i] == 0 || len(shares[i]) != len(shares[0]) {
+			return nil, errors.New("malformed share")
+		}
+		for j := 0; j < i; j++ {
+			if xs[i] == xs[j] {
+				return nil, errors.New("duplicate share")
+			}
+		}
+	}
+	secret := make([]byte, len(shares[0]))
+	for i, xi := range xs {
+		// basis polynomial for share i, evaluated at 0
+		basis := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				basis = gfMul(basis, gfDiv(xj, xj^xi))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(shares[i][b], basis)
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// SharedVault is a set of stored-password profiles shared with other
+// letmein accounts. Its profiles are sealed with a random vault key,
+// and each member gets a copy of that key wrapped with their public
+// identity key. The sync server is responsible for delivering the
+// vault and its profiles to every member.
+//
+// The server is not trusted with the record itself. Each wrapped key
+// names the member who wrapped it, and the wrapping mixes in that
+// member's identity key, so only someone holding it can produce one.
+// A wrapped key is only accepted from a member whose public key this
+// account has confirmed (see Client.Contacts). The whole record then
+// carries a MAC under the vault key, so only members can change who
+// else is in it.
+type SharedVault struct {
+	ID         string                  `json:"id"`
+	Name       string                  `json:"name"`
+	Members    map[string]*VaultMember `json:"members"`
+	ModifiedAt *time.Time              `json:"modified_at,omitempty"`
+	MAC        string                  `json:"mac,omitempty"`
+}
+
+// VaultMember holds one account's copy of the vault key. From names the
+// member who wrapped it; copies from before it was recorded have none.
+type VaultMember struct {
+	PublicKey  string `json:"public_key"`
+	WrappedKey string `json:"wrapped_key"`
+	From       string `json:"from,omitempty"`
+}
+
+// vaultKeys holds the keys of the shared vaults this account could
+// open, filled in by unlockSharedVaults.
+var vaultKeys = make(map[string][]byte)
+
+// identityKey derives this account's X25519 key pair for sharing.
+func identityKey(master, account string) (priv, pub []byte) {
+	priv = secretKey(master, "share-identity\t"+account)
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		failf("Error computing public key: %v\n", err)
+	}
+	return priv, pub
+}
+
+// wrapKeyAgreement derives the key that wraps a vault key for one
+// member from an X25519 shared secret. With a sender, it also mixes in
+// the secret the sender's identity key shares with the recipient.
+func wrapKeyAgreement(shared, ephemeral, recipient, static, sender []byte) []byte {
+	h := sha256.New()
+	if sender == nil {
+		h.Write([]byte("letmein share\t"))
+	} else {
+		h.Write([]byte("letmein share 2\t"))
+	}
+	h.Write(shared)
+	h.Write(ephemeral)
+	h.Write(recipient)
+	if sender != nil {
+		h.Write(static)
+		h.Write(sender)
+	}
+	return h.Sum(nil)
+}
+
+// wrapVaultKey encrypts a vault key to a member's public key, from the
+// sender's identity key.
+func wrapVaultKey(vaultKey, recipient, senderPriv, senderPub []byte) (string, error) {
+	eph := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(eph); err != nil {
+		return "", err
+	}
+	defer wipe(eph)
+	ephPub, err := curve25519.X25519(eph, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	shared, err := curve25519.X25519(eph, recipient)
+	if err != nil {
+		return "", err
+	}
+	static, err := curve25519.X25519(senderPriv, recipient)
+	if err != nil {
+		return "", err
+	}
+	key := wrapKeyAgreement(shared, ephPub, recipient, static, senderPub)
+	return base64.StdEncoding.EncodeToString(ephPub) + ":" + sealSecret(key, vaultKey), nil
+}
+
+// unwrapVaultKey recovers a vault key with this account's private key.
+// senderPub is the public key of the member who wrapped it, or nil for
+// a copy from before senders were recorded.
+func unwrapVaultKey(wrapped string, priv, pub, senderPub []byte) ([]byte, error) {
+	i := strings.Index(wrapped, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("malformed wrapped key")
+	}
+	ephPub, err := base64.StdEncoding.DecodeString(wrapped[:i])
+	if err != nil {
+		return nil, fmt.Errorf("malformed wrapped key")
+	}
+	shared, err := curve25519.X25519(priv, ephPub)
+	if err != nil {
+		return nil, err
+	}
+	var static []byte
+	if senderPub != nil {
+		if static, err = curve25519.X25519(priv, senderPub); err != nil {
+			return nil, err
+		}
+	}
+	return openSecret(wrapKeyAgreement(shared, ephPub, pub, static, senderPub), wrapped[i+1:])
+}
+
+// vaultMAC authenticates a vault record under its key.
+func vaultMAC(key []byte, v *SharedVault) string {
+	mac := hmac.New(sha256.New, key)
+	field := func(s string) {
+		mac.Write([]byte(s))
+		mac.Write([]byte{0})
+	}
+	field("letmein vault")
+	field(v.ID)
+	field(v.Name)
+	if v.ModifiedAt != nil {
+		field(v.ModifiedAt.UTC().Format(time.RFC3339Nano))
+	} else {
+		field("")
+	}
+	var names []string
+	for name := range v.Members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := v.Members[name]
+		field(name)
+		field(m.PublicKey)
+		field(m.WrappedKey)
+		field(m.From)
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sealVault recomputes a vault record's MAC after this account changed it.
+func sealVault(v *SharedVault) {
+	v.MAC = vaultMAC(vaultKeys[v.ID], v)
+}
+
+// trustedKey returns the confirmed public key of an account, or nil.
+func (c *Client) trustedKey(account string, ownPub []byte) []byte {
+	if account == c.Name {
+		return ownPub
+	}
+	if c.Contacts[account] == "" {
+		return nil
+	}
+	pub, err := base64.StdEncoding.DecodeString(c.Contacts[account])
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+// trustKey confirms an account's public key, refusing to change one
+// confirmed before unless replace is set.
+func (c *Client) trustKey(account string, pub []byte, replace bool) {
+	encoded := base64.StdEncoding.EncodeToString(pub)
+	if old := c.Contacts[account]; old != "" && old != encoded && !replace {
+		failf("%s already has a different public key; if they really changed it, run letmein share trust -replace %s KEY\n", account, account)
+	}
+	if c.Contacts == nil {
+		c.Contacts = make(map[string]string)
+	}
+	c.Contacts[account] = encoded
+}
+
+// checkVault authenticates a vault record from the server and returns
+// the vault key it gives this account.
+func checkVault(client *Client, v *SharedVault, priv, pub []byte) ([]byte, error) {
+	m := v.Members[client.Name]
+	switch {
+	case m == nil:
+		return nil, fmt.Errorf("this account is not a member")
+	case m.From == "":
+		return nil, fmt.Errorf("the key does not say who wrapped it; ask a member to run letmein share add again")
+	}
+	sender := client.trustedKey(m.From, pub)
+	if sender == nil {
+		return nil, fmt.Errorf("the key comes from %s, whose public key is not confirmed; get it from them and run letmein share trust %s KEY", m.From, m.From)
+	}
+	if v.Members[m.From] == nil || v.Members[m.From].PublicKey != base64.StdEncoding.EncodeToString(sender) {
+		return nil, fmt.Errorf("the key comes from %s, who is not a member with their confirmed key", m.From)
+	}
+	key, err := unwrapVaultKey(m.WrappedKey, priv, pub, sender)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(v.MAC), []byte(vaultMAC(key, v))) {
+		wipe(key)
+		return nil, fmt.Errorf("the record was changed by someone without the vault key")
+	}
+	for name, member := range v.Members {
+		if known := client.trustedKey(name, pub); known != nil && member.PublicKey != base64.StdEncoding.EncodeToString(known) {
+			wipe(key)
+			return nil, fmt.Errorf("the public key for %s is not the confirmed one", name)
+		}
+	}
+	return key, nil
+}
+
+// unlockSharedVaults unwraps the key of every vault this account is a
+// member of.
+func unlockSharedVaults(client *Client, master string) {
+	if len(client.Shared) == 0 {
+		return
+	}
+	priv, pub := identityKey(master, client.Name)
+	for _, v := range client.Shared {
+		m := v.Members[client.Name]
+		if m == nil {
+			continue
+		}
+
+		// copies from before senders were recorded were accepted then
+		var key []byte
+		var err error
+		if m.From == "" {
+			key, err = unwrapVaultKey(m.WrappedKey, priv, pub, nil)
+		} else {
+			key, err = checkVault(client, v, priv, pub)
+		}
+		if err != nil {
+			infof("warning: cannot open shared vault %s: %v", v.Name, err)
+			continue
+		}
+		vaultKeys[v.ID] = key
+	}
+}
+
+// sharedKey returns the key a shared profile is sealed with.
+func sharedKey(p *Profile) []byte {
+	key := vaultKeys[p.Vault]
+	if key == nil {
+		failf("Profile %s belongs to a shared vault you cannot open\n", p.Name)
+	}
+	return key
+}
+
+// sharedSigningKey is the sync signing key for a shared vault's
+// profiles, which every member must be able to check.
+func sharedSigningKey(vault string) []byte {
+	key := vaultKeys[vault]
+	if key == nil {
+		return nil
+	}
+	sum := sha256.Sum256(append([]byte("letmein share sign\t"), key...))
+	return sum[:]
+}
+
+func (c *Client) findVault(name string) *SharedVault {
+	for _, v := range c.Shared {
+		if v.Name == name || v.ID == name {
+			return v
+		}
+	}
+	return nil
+}
+
+func shareCommand() *Client {
+	now := time.Now().Round(time.Millisecond)
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		failf("Usage: letmein share id|ls|trust|create|add|remove|move [arguments]\n")
+	}
+	sub := os.Args[1]
+	os.Args = os.Args[1:]
+
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	query := new(Query)
+	replace := false
+	if sub == "trust" {
+		flag.BoolVar(&replace, "replace", replace, "Replace a public key confirmed before")
+	}
+	if sub == "move" {
+		registerQueryFlags(query)
+		registerInteractiveFlag()
+	}
+	flag.Parse()
+	args := flag.Args()
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+
+	need := map[string]int{"id": 0, "ls": 0, "trust": 2, "create": 1, "add": 3, "remove": 2, "move": 2}
+	n, ok := need[sub]
+	if !ok {
+		exitf(exitUsage, "Unknown share command %q\n", sub)
+	}
+	if len(args) != n {
+		exitf(exitUsage, "letmein share %s takes %d arguments\n", sub, n)
+	}
+
+	switch sub {
+	case "id":
+		_, pub := identityKey(master, client.Name)
+		fmt.Printf("account:    %s\npublic key: %s\n", client.Name, base64.StdEncoding.EncodeToString(pub))
+		return nil
+
+	case "ls":
+		for _, v := range client.Shared {
+			_, pub := identityKey(master, client.Name)
+			var names []string
+			for name := range v.Members {
+				if client.trustedKey(name, pub) == nil {
+					name += " (unconfirmed)"
+				}
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			count := 0
+			for _, p := range client.Profiles {
+				if p.Vault == v.ID && !p.IsDeleted() {
+					count++
+				}
+			}
+			fmt.Printf("    %s: %d profiles, members %s\n", v.Name, count, strings.Join(names, ", "))
+		}
+		return nil
+
+	case "trust":
+		pub := decodePublicKey(args[1])
+		client.trustKey(args[0], pub, replace)
+		fmt.Printf("confirmed the public key of %s\n", args[0])
+
+	case "create":
+		if client.findVault(args[0]) != nil {
+			failf("A shared vault named %s already exists\n", args[0])
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			failf("Error generating vault key: %v\n", err)
+		}
+		v := &SharedVault{ID: newUUID(), Name: args[0], Members: make(map[string]*VaultMember), ModifiedAt: &now}
+		vaultKeys[v.ID] = key
+		_, pub := identityKey(master, client.Name)
+		addMember(client, v, master, client.Name, pub)
+		sealVault(v)
+		client.Shared = append(client.Shared, v)
+		fmt.Printf("created shared vault %s\n", v.Name)
+
+	case "add":
+		v := openVault(client, args[0])
+		pub := decodePublicKey(args[2])
+		client.trustKey(args[1], pub, false)
+		addMember(client, v, master, args[1], pub)
+		v.ModifiedAt = &now
+		sealVault(v)
+		fmt.Printf("added %s to %s\n", args[1], v.Name)
+
+	case "remove":
+		v := openVault(client, args[0])
+		if v.Members[args[1]] == nil {
+			failf("%s is not a member of %s\n", args[1], v.Name)
+		}
+		delete(v.Members, args[1])
+		rotateVaultKey(client, v, master, now)
+		v.ModifiedAt = &now
+		sealVault(v)
+		fmt.Printf("removed %s from %s and changed the vault key\n", args[1], v.Name)
+		fmt.Printf("they may have copied passwords already; change those at each site\n")
+
+	case "move":
+		v := openVault(client, args[0])
+		query.Term = args[1]
+		if err := query.Compile(); err != nil {
+			failf("Invalid search: %v\n", err)
+		}
+		p := chooseProfile(client.Search(query), args[1], "share")
+		if p.Vault != "" {
+			failf("Profile %s is already shared\n", p.Name)
+		}
+		if len(p.Fields) > 0 || len(p.Attachments) > 0 {
+			failf("Remove custom fields and attachments from %s before sharing it\n", p.Name)
+		}
+
+		// other members cannot derive this account's passwords, so the
+		// current password is stored under the vault key instead
+		before := *p
+		password := p.Generate(master)
+		p.Vault = v.ID
+		setStoredSecret(p, master, password)
+		p.ModifiedAt = &now
+		if err := p.Validate(); err != nil {
+			failf("shared profile is invalid, canceling: %v\n", err)
+		}
+		stampChanges(&before, p, now)
+		recordOp("share", []*Profile{&before}, nil)
+		fmt.Printf("moved %s into %s\n", p.Name, v.Name)
+	}
+	return client
+}
+
+// openVault finds a vault whose key this account holds.
+func openVault(client *Client, name string) *SharedVault {
+	v := client.findVault(name)
+	if v == nil {
+		failf("No shared vault named %s\n", name)
+	}
+	if vaultKeys[v.ID] == nil {
+		failf("You cannot open shared vault %s\n", name)
+	}
+	return v
+}
+
+func decodePublicKey(s string) []byte {
+	pub, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(pub) != curve25519.PointSize {
+		failf("Invalid public key: ask the member to run \"letmein share id\"\n")
+	}
+	return pub
+}
+
+// addMember wraps the vault key for an account, from this one.
+func addMember(client *Client, v *SharedVault, master, account string, pub []byte) {
+	priv, own := identityKey(master, client.Name)
+	wrapped, err := wrapVaultKey(vaultKeys[v.ID], pub, priv, own)
+	if err != nil {
+		failf("Error wrapping vault key: %v\n", err)
+	}
+	v.Members[account] = &VaultMember{
+		PublicKey:  base64.StdEncoding.EncodeToString(pub),
+		WrappedKey: wrapped,
+		From:       client.Name,
+	}
+}
+
+// rotateVaultKey replaces a vault's key, rewrapping it for the remaining
+// members and resealing every profile in the vault. The new key only
+// goes to public keys this account has confirmed.
+func rotateVaultKey(client *Client, v *SharedVault, master string, now time.Time) {
+	_, own := identityKey(master, client.Name)
+	for account, m := range v.Members {
+		pub := client.trustedKey(account, own)
+		switch {
+		case pub == nil:
+			failf("The public key of %s is not confirmed; get it from them and run letmein share trust %s KEY\n", account, account)
+		case m.PublicKey != base64.StdEncoding.EncodeToString(pub):
+			failf("The vault lists a different public key for %s than the confirmed one\n", account)
+		}
+	}
+	old := vaultKeys[v.ID]
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		failf("Error generating vault key: %v\n", err)
+	}
+	for _, p := range client.Profiles {
+		if p.Vault != v.ID || p.IsDeleted() {
+			continue
+		}
+		plain, err := openSecret(old, p.Secret)
+		if err != nil {
+			failf("Error opening %s: %v\n", p.Name, err)
+		}
+		before := *p
+		p.Secret = sealSecret(key, plain)
+		wipe(plain)
+		p.ModifiedAt = &now
+		stampChanges(&before, p, now)
+	}
+	vaultKeys[v.ID] = key
+	for account := range v.Members {
+		addMember(client, v, master, account, client.trustedKey(account, own))
+	}
+}
+
+// profileSigningKey picks the key a profile is signed with on sync:
+// shared profiles use a key every vault member can derive.
+func profileSigningKey(own []byte, p *Profile) []byte {
+	if own == nil || p.Vault == "" {
+		return own
+	}
+	if key := sharedSigningKey(p.Vault); key != nil {
+		return key
+	}
+	return own
+}
+
+// mergeSharedVaults takes the newer copy of each shared vault record
+// from the server, if it checks out, and unlocks any vault keys that
+// changed.
+func mergeSharedVaults(client *Client, remote []*SharedVault, master string) {
+	byid := make(map[string]int)
+	for i, v := range client.Shared {
+		byid[v.ID] = i
+	}
+	priv, pub := identityKey(master, client.Name)
+	for _, v := range remote {
+		i, exists := byid[v.ID]
+		if exists && (v.ModifiedAt == nil || client.Shared[i].ModifiedAt != nil && !v.ModifiedAt.After(*client.Shared[i].ModifiedAt)) {
+			continue
+		}
+		key, err := checkVault(client, v, priv, pub)
+		if err != nil {
+			infof("ignoring shared vault %s from the server: %v", v.Name, err)
+			continue
+		}
+		wipe(key)
+		if exists {
+			debugf("updating shared vault: %s", v.Name)
+			client.Shared[i] = v
+		} else {
+			infof("joining shared vault: %s", v.Name)
+			byid[v.ID] = len(client.Shared)
+			client.Shared = append(client.Shared, v)
+		}
+	}
+	old := make(map[string][]byte)
+	for id, key := range vaultKeys {
+		old[id] = key
+	}
+	unlockSharedVaults(client, master)
+	for _, v := range client.Shared {
+		if old[v.ID] != nil && string(old[v.ID]) != string(vaultKeys[v.ID]) {
+			infof("the key for shared vault %s has changed", v.Name)
+		}
+	}
+}
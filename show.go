@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+func showProfile() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	words := 0
+	registerBIP39Flag(&words)
+	spell := false
+	registerSpellFlag(&spell)
+	flag.Parse()
+	checkBIP39Words(words)
+	master = getAndVerifyMaster(master)
+
+	// get search string
+	args := flag.Args()
+	if len(args) != 1 {
+		failf("Must provide exactly one search term to find profile to show\n")
+	}
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	client := getMatches(now, master, query)
+	p := chooseProfile(client.Search(query), args[0], "show")
+
+	fmt.Printf("name:      %s\n", p.Name)
+	fmt.Printf("username:  %s\n", p.Username)
+	fmt.Printf("url:       %s\n", p.URL)
+	if words > 0 {
+		fmt.Printf("mnemonic:  %s\n", p.Mnemonic(master, words))
+	} else {
+		password := p.Generate(master)
+		recordUse(p)
+		fmt.Printf("password:  %s\n", password)
+		if spell {
+			fmt.Print(spellPassword(password))
+		}
+	}
+	fmt.Printf("profile:   %s\n", p)
+	fmt.Printf("uuid:      %s\n", p.UUID)
+
+	key := secretKey(master, client.Name)
+	for _, name := range fieldNames(p) {
+		value, err := openSecret(key, p.Fields[name])
+		if err != nil {
+			fmt.Printf("%s: <%v>\n", name, err)
+			continue
+		}
+		fmt.Printf("%s: %s\n", name, value)
+	}
+
+	return client
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Sync signature modes for the sync_signatures setting.
+const (
+	signaturesOff     = "off"
+	signaturesSign    = "sign"
+	signaturesRequire = "require"
+)
+
+// signingKey derives the key used to sign profiles for sync. Only
+// someone with the master password can produce a valid signature, so
+// the server cannot forge or alter profiles without being noticed.
+func signingKey(master, account string) []byte {
+	return secretKey(master, "sign\t"+account)
+}
+
+// profileMAC computes a profile's signature over everything except the
+// signature itself and the timestamps the server or sync may stamp.
+// A tombstone's signature covers only its UUID, which still proves a
+// device with the master password deleted it.
+func profileMAC(key []byte, p *Profile) string {
+	c := *p
+	c.Signature = ""
+	c.ModifiedAt = nil
+	c.DeletedAt = nil
+	raw, err := json.Marshal(&c)
+	if err != nil {
+		failf("Error encoding profile for signing: %v\n", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(raw)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signProfile(key []byte, p *Profile) {
+	p.Signature = profileMAC(key, p)
+}
+
+// nextRevision stamps a changed profile with the next revision before
+// it is signed and sent. The signature covers the revision, so the
+// server cannot pass off an older signed copy, or a live copy of a
+// deleted profile, as current: checkSignature rejects any revision
+// below the one this device already has.
+func nextRevision(p *Profile) {
+	p.Revision++
+}
+
+// checkSignature reports whether a profile from the server should be
+// accepted under the configured signature mode. local is this device's
+// copy, if any: in sign mode an unsigned profile is only accepted in
+// place of a local copy that is unsigned too, that is, one known from
+// before signing was enabled. A new UUID, or one this device has seen
+// signed, must carry a signature.
+func checkSignature(key []byte, p *Profile, local *Profile) error {
+	switch {
+	case config.SyncSignatures == signaturesOff:
+		return nil
+	case p.Signature == "" && config.SyncSignatures == signaturesRequire:
+		return fmt.Errorf("profile %s is not signed", p.UUID)
+	case p.Signature == "" && local == nil:
+		return fmt.Errorf("new profile %s is not signed", p.UUID)
+	case p.Signature == "" && local.Signature != "":
+		return fmt.Errorf("profile %s is not signed, but was before", p.UUID)
+	case p.Signature == "":
+		infof("warning: accepting unsigned profile %s from server", p.UUID)
+		return nil
+	case !hmac.Equal([]byte(p.Signature), []byte(profileMAC(key, p))):
+		return fmt.Errorf("profile %s has an invalid signature", p.UUID)
+	case local != nil && p.Revision < local.Revision:
+		return fmt.Errorf("profile %s is an older copy (revision %d, have %d)", p.UUID, p.Revision, local.Revision)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dchest/scrypt"
+)
+
+// schemeSpectre generates the same passwords as Spectre (formerly
+// Master Password), algorithm version 3. Its scheme string names the
+// template class and the user's full name, which Spectre mixes into
+// every password: spectre-v3(long,Robert Lee Mitchell). The site name is
+// the profile's URL and the site counter its generation.
+const schemeSpectre = "spectre-v3"
+
+const (
+	spectreScope  = "com.lyndir.masterpassword"
+	spectreN      = 32768
+	spectreR      = 8
+	spectreP      = 2
+	spectreKeyLen = 64
+)
+
+// spectreTemplates are the templates for each result type. The site
+// seed picks one, and each template character names a class below.
+var spectreTemplates = map[string][]string{
+	"maximum": {"anoxxxxxxxxxxxxxxxxx", "axxxxxxxxxxxxxxxxxno"},
+	"long": {
+		"CvcvnoCvcvCvcv", "CvcvCvcvnoCvcv", "CvcvCvcvCvcvno", "CvccnoCvcvCvcv",
+		"CvccCvcvnoCvcv", "CvccCvcvCvcvno", "CvcvnoCvccCvcv", "CvcvCvccnoCvcv",
+		"CvcvCvccCvcvno", "CvcvnoCvcvCvcc", "CvcvCvcvnoCvcc", "CvcvCvcvCvccno",
+		"CvccnoCvccCvcv", "CvccCvccnoCvcv", "CvccCvccCvcvno", "CvcvnoCvccCvcc",
+		"CvcvCvccnoCvcc", "CvcvCvccCvccno", "CvccnoCvcvCvcc", "CvccCvcvnoCvcc",
+		"CvccCvcvCvccno",
+	},
+	"medium": {"CvcnoCvc", "CvcCvcno"},
+	"short":  {"Cvcn"},
+	"basic":  {"aaanaaan", "aannaaan", "aaannaaa"},
+	"pin":    {"nnnn"},
+	"name":   {"cvccvcvcv"},
+	"phrase": {"cvcc cvc cvccvcv cvc", "cvc cvccvcvcv cvcv", "cv cvccv cvc cvcvccv"},
+}
+
+var spectreClasses = map[byte]string{
+	'V': "AEIOU",
+	'C': "BCDFGHJKLMNPQRSTVWXYZ",
+	'v': "aeiou",
+	'c': "bcdfghjklmnpqrstvwxyz",
+	'A': "AEIOUBCDFGHJKLMNPQRSTVWXYZ",
+	'a': "AEIOUaeiouBCDFGHJKLMNPQRSTVWXYZbcdfghjklmnpqrstvwxyz",
+	'n': "0123456789",
+	'o': "@&%?,=[]_:-+*$#!'^~;()/.",
+	'x': "AEIOUaeiouBCDFGHJKLMNPQRSTVWXYZbcdfghjklmnpqrstvwxyz0123456789!@#$%^&*()",
+	' ': " ",
+}
+
+func init() {
+	registerScheme(schemeSpectre, parseSpectreScheme)
+}
+
+type spectreScheme struct {
+	template string
+	fullName string
+}
+
+// parseSpectreScheme reads spectre-v3(template,full name).
+func parseSpectreScheme(scheme string) (Scheme, error) {
+	args := strings.TrimPrefix(scheme, schemeSpectre+"(")
+	if args == scheme || !strings.HasSuffix(args, ")") {
+		return nil, fmt.Errorf("Spectre schemes look like %s(long,Your Full Name)", schemeSpectre)
+	}
+	parts := strings.SplitN(strings.TrimSuffix(args, ")"), ",", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("Spectre schemes need your full name, as in %s(long,Your Full Name)", schemeSpectre)
+	}
+	if _, ok := spectreTemplates[parts[0]]; !ok {
+		var names []string
+		for elt := range spectreTemplates {
+			names = append(names, elt)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown Spectre template %q; use one of %s", parts[0], strings.Join(names, ", "))
+	}
+	return &spectreScheme{template: parts[0], fullName: parts[1]}, nil
+}
+
+// spectreSalt is the scope, the big-endian byte length of text, and
+// text itself, as Spectre builds its salts.
+func spectreSalt(text string) []byte {
+	salt := []byte(spectreScope)
+	salt = binary.BigEndian.AppendUint32(salt, uint32(len(text)))
+	return append(salt, text...)
+}
+
+// masterKey is scrypt over the master password with the full name as
+// salt; it is the same for every site, so it is cached.
+func (s *spectreScheme) masterKey(master []byte) ([]byte, error) {
+	salt := string(spectreSalt(s.fullName))
+	key := kdfCacheKey(master, salt, spectreN, spectreR, spectreP, spectreKeyLen)
+	if hash, ok := derivedCache.get(key); ok {
+		return hash, nil
+	}
+	done := timed("spectre master key")
+	hash, err := scrypt.Key(master, []byte(salt), spectreN, spectreR, spectreP, spectreKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	done()
+	derivedCache.put(key, hash)
+	return hash, nil
+}
+
+func (s *spectreScheme) Password(p *Profile, master []byte) ([]byte, error) {
+	masterKey, err := s.masterKey(master)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(masterKey)
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write(binary.BigEndian.AppendUint32(spectreSalt(p.URL), uint32(p.Generation)))
+	seed := mac.Sum(nil)
+	defer wipe(seed)
+
+	templates := spectreTemplates[s.template]
+	template := templates[int(seed[0])%len(templates)]
+	out := make([]byte, len(template))
+	for i := range template {
+		chars := spectreClasses[template[i]]
+		out[i] = chars[int(seed[i+1])%len(chars)]
+	}
+	return out, nil
+}
+
+func (s *spectreScheme) Key(p *Profile, master []byte, purpose string, n int) ([]byte, error) {
+	return nil, errors.New("Spectre profiles cannot derive keys")
+}
+
+// exactInputs reports that the site name is used as typed, as Spectre
+// does.
+func (s *spectreScheme) exactInputs() bool {
+	return true
+}
+
+func (s *spectreScheme) setDefaults(p *Profile) {
+	if p.Generation == 0 {
+		p.Generation = 1
+	}
+}
+
+// checkProfile sets the length from the template and clears character
+// settings, which the template decides.
+func (s *spectreScheme) checkProfile(p *Profile) error {
+	if p.Generation < 1 || p.Generation > maxGeneration {
+		return fmt.Errorf("Spectre counter (generation) must be between 1 and %d", maxGeneration)
+	}
+	if p.URL == "" {
+		return fmt.Errorf("Spectre profiles need the site name as the URL")
+	}
+	p.Length = 0
+	for _, elt := range spectreTemplates[s.template] {
+		if len(elt) > p.Length {
+			p.Length = len(elt)
+		}
+	}
+	p.Lower = false
+	p.Upper = false
+	p.Digits = false
+	p.Punctuation = false
+	p.Spaces = false
+	p.Latin1 = false
+	p.Include = ""
+	p.Exclude = ""
+	return nil
+}
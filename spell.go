@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var natoAlphabet = []string{
+	"alfa", "bravo", "charlie", "delta", "echo", "foxtrot", "golf",
+	"hotel", "india", "juliett", "kilo", "lima", "mike", "november",
+	"oscar", "papa", "quebec", "romeo", "sierra", "tango", "uniform",
+	"victor", "whiskey", "x-ray", "yankee", "zulu",
+}
+
+var digitNames = []string{
+	"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+}
+
+var symbolNames = map[rune]string{
+	' ': "space", '!': "exclamation mark", '"': "double quote", '#': "hash",
+	'$': "dollar", '%': "percent", '&': "ampersand", '\'': "single quote",
+	'(': "open parenthesis", ')': "close parenthesis", '*': "asterisk",
+	'+': "plus", ',': "comma", '-': "hyphen", '.': "period", '/': "slash",
+	':': "colon", ';': "semicolon", '<': "less than", '=': "equals",
+	'>': "greater than", '?': "question mark", '@': "at sign",
+	'[': "open bracket", '\\': "backslash", ']': "close bracket",
+	'^': "caret", '_': "underscore", '`': "backtick", '{': "open brace",
+	'|': "vertical bar", '}': "close brace", '~': "tilde",
+	'¡': "inverted exclamation mark", '¢': "cent", '£': "pound",
+	'¥': "yen", '§': "section", '©': "copyright", '«': "open guillemet",
+	'®': "registered", '°': "degree", '±': "plus-minus", 'µ': "micro",
+	'¶': "pilcrow", '·': "middle dot", '»': "close guillemet",
+	'¿': "inverted question mark", '×': "multiplication", '÷': "division",
+}
+
+func registerSpellFlag(spell *bool) {
+	flag.BoolVar(spell, "spell", false, "Spell out the password with the NATO alphabet, for dictation")
+}
+
+// spellRune names one password character.
+func spellRune(r rune) string {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return natoAlphabet[r-'a']
+	case r >= 'A' && r <= 'Z':
+		return "capital " + strings.ToUpper(natoAlphabet[r-'A'])
+	case r >= '0' && r <= '9':
+		return digitNames[r-'0']
+	case symbolNames[r] != "":
+		return symbolNames[r]
+	}
+	return fmt.Sprintf("U+%04X", r)
+}
+
+// spellPassword lists a password one character per line, numbered, with
+// each character's spoken name.
+func spellPassword(password string) string {
+	b := new(strings.Builder)
+	i := 0
+	for _, r := range password {
+		i++
+		shown := string(r)
+		if unicode.IsSpace(r) {
+			shown = " "
+		}
+		fmt.Fprintf(b, "    %2d  %s  %s\n", i, shown, spellRune(r))
+	}
+	return b.String()
+}
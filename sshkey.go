@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func sshKeyProfile() *Client {
+	now := time.Now().Round(time.Millisecond)
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	query := new(Query)
+	registerQueryFlags(query)
+	registerInteractiveFlag()
+	public, addAgent := false, false
+	output := ""
+	flag.BoolVar(&public, "public", public, "Print only the public key")
+	flag.StringVar(&output, "o", output, "Write the private key to this file (and the public key to file.pub)")
+	flag.BoolVar(&addAgent, "agent", addAgent, "Add the key to the running ssh-agent")
+	flag.Parse()
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+
+	// get search string
+	args := flag.Args()
+	if len(args) != 1 {
+		failf("Must provide exactly one search term to find profile for the key\n")
+	}
+	query.Term = args[0]
+	if err := query.Compile(); err != nil {
+		failf("Invalid search: %v\n", err)
+	}
+	p := chooseProfile(client.Search(query), args[0], "derive a key from")
+	if p.Scheme == schemeStored {
+		failf("Cannot derive keys from a stored-password profile\n")
+	}
+
+	// derive the key pair
+	seed := p.DeriveKey(master, "ssh-ed25519", ed25519.SeedSize)
+	priv := ed25519.NewKeyFromSeed(seed)
+	wipe(seed)
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		failf("Error encoding public key: %v\n", err)
+	}
+	comment := p.Name
+	authorized := string(ssh.MarshalAuthorizedKey(pub))
+	authorized = authorized[:len(authorized)-1] + " " + comment + "\n"
+
+	switch {
+	case public:
+		fmt.Print(authorized)
+
+	case addAgent:
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			failf("No ssh-agent found: SSH_AUTH_SOCK is not set\n")
+		}
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			failf("Error connecting to ssh-agent: %v\n", err)
+		}
+		defer conn.Close()
+		if err := agent.NewClient(conn).Add(agent.AddedKey{PrivateKey: priv, Comment: comment}); err != nil {
+			failf("Error adding key to ssh-agent: %v\n", err)
+		}
+		fmt.Printf("added key to ssh-agent: %s", authorized)
+
+	default:
+		block, err := ssh.MarshalPrivateKey(priv, comment)
+		if err != nil {
+			failf("Error encoding private key: %v\n", err)
+		}
+		if output == "" {
+			fmt.Print(string(pem.EncodeToMemory(block)))
+			return client
+		}
+		if err := ioutil.WriteFile(output, pem.EncodeToMemory(block), 0600); err != nil {
+			failf("Error writing %s: %v\n", output, err)
+		}
+		if err := ioutil.WriteFile(output+".pub", []byte(authorized), 0644); err != nil {
+			failf("Error writing %s.pub: %v\n", output, err)
+		}
+		fmt.Printf("wrote %s and %s.pub\n", output, output)
+	}
+
+	return client
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// A server of the form ssh://user@host/path/to/store.json syncs with a
+// store file on a machine reachable over SSH instead of a sync server.
+// The file is read and written with the system ssh command, so keys,
+// agents, and ~/.ssh/config all work as usual, and the remote machine
+// needs nothing but a POSIX shell. The file is an ordinary letmein
+// store for the same account.
+
+const sshScheme = "ssh://"
+
+func isSSHServer(server string) bool {
+	return strings.HasPrefix(server, sshScheme)
+}
+
+type sshTarget struct {
+	host string
+	port string
+	path string
+}
+
+func parseSSHServer(server string) (*sshTarget, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || u.Path == "" || u.Path == "/" {
+		return nil, fmt.Errorf("%s must look like ssh://user@host/path/to/store.json", server)
+	}
+	t := &sshTarget{host: u.Hostname(), port: u.Port(), path: u.Path}
+	if u.User != nil {
+		t.host = u.User.Username() + "@" + t.host
+	}
+
+	// ssh://host/~/letmein.json is relative to the remote home directory
+	if strings.HasPrefix(t.path, "/~/") {
+		t.path = t.path[len("/~/"):]
+	}
+	return t, nil
+}
+
+// run runs a shell script on the remote machine.
+func (t *sshTarget) run(script string, stdin []byte) ([]byte, error) {
+	args := []string{}
+	if t.port != "" {
+		args = append(args, "-p", t.port)
+	}
+	args = append(args, "--", t.host, script)
+	debugf("ssh %s", strings.Join(args, " "))
+	cmd := exec.Command("ssh", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out, fmt.Errorf("%v: %s", err, msg)
+		}
+		return out, err
+	}
+	return out, nil
+}
+
+// read returns the remote store and a checksum of it, or nil data if
+// there is no store yet.
+func (t *sshTarget) read() (raw []byte, sum string, err error) {
+	p := shellQuote(t.path)
+	out, err := t.run("if [ -f "+p+" ]; then cksum < "+p+" && cat "+p+"; else echo none; fi", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	i := bytes.IndexByte(out, '\n')
+	if i < 0 {
+		return nil, "", fmt.Errorf("unexpected reply from the remote shell")
+	}
+	sum = strings.TrimSpace(string(out[:i]))
+	if sum == "none" {
+		return nil, sum, nil
+	}
+	return out[i+1:], sum, nil
+}
+
+// errRemoteChanged reports that another device wrote the remote store
+// while this one was syncing.
+var errRemoteChanged = fmt.Errorf("the remote store changed during the sync")
+
+// write replaces the remote store, but only if it still has the
+// checksum it had when it was read.
+func (t *sshTarget) write(raw []byte, sum string) error {
+	p, tmp := shellQuote(t.path), shellQuote(t.path+".tmp")
+	script := "umask 077 && mkdir -p " + shellQuote(path.Dir(t.path)) + " && " +
+		"cur=$(if [ -f " + p + " ]; then cksum < " + p + "; else echo none; fi) && " +
+		"if [ \"$cur\" != " + shellQuote(sum) + " ]; then exit 3; fi && " +
+		"cat > " + tmp + " && mv " + tmp + " " + p
+	_, err := t.run(script, raw)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 3 {
+		return errRemoteChanged
+	}
+	return err
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// sshSync merges the client with a store on a remote machine and writes
+// the result back to both. Every profile on the remote side takes part,
+// so nothing depends on a sync cursor.
+func sshSync(now time.Time, client *Client, master, server string) *Client {
+	target, err := parseSSHServer(server)
+	if err != nil {
+		exitf(exitUsage, "%v\n", err)
+	}
+	raw, sum, err := target.read()
+	if err != nil {
+		exitf(exitNetwork, "Error reading %s: %v\n", server, err)
+	}
+	remote := &Client{Name: client.Name, Verifier: client.Verifier, NoVerify: client.NoVerify}
+	if raw != nil {
+		if remote, err = decodeClient(raw); err != nil {
+			exitf(exitNetwork, "Error parsing %s: %v\n", server, err)
+		}
+		if remote.Name != client.Name {
+			failf("%s belongs to account %s, not %s\n", server, remote.Name, client.Name)
+		}
+		if err := remote.checkMaster(master); err != nil {
+			exitf(exitBadMaster, "Master password does not match the store at %s\n", server)
+		}
+		if (remote.Integrity != "" || remote.Sealed) && !integrityIntact(integrityKey, remote) {
+			fmt.Fprintf(os.Stderr, "WARNING: %s was modified outside letmein or is corrupted.\n", server)
+		}
+	}
+	debugf("read %d profiles from %s", len(remote.Profiles), server)
+
+	var signKey []byte
+	if config.SyncSignatures != signaturesOff {
+		signKey = signingKey(master, client.Name)
+		for _, elt := range client.Profiles {
+			if elt.ModifiedAt != nil {
+				nextRevision(elt)
+			}
+		}
+	}
+	recordSnapshot("sync", client)
+	rec := &SyncRecord{At: now, Server: server, Status: "ok", Sent: countChanged(client)}
+	mergeUpdates(now, client, master, remote.Profiles, signKey, false, rec)
+
+	// both sides now hold the merged profiles
+	settleSynced(client)
+	remote.Version = storeVersion
+	remote.Profiles = syncCopies(client.Profiles, signKey)
+	sealIntegrity(remote)
+	out, err := json.MarshalIndent(remote, "", "    ")
+	if err != nil {
+		failf("Error encoding %s: %v\n", server, err)
+	}
+	if err := target.write(append(out, '\n'), sum); err == errRemoteChanged {
+		exitf(exitNetwork, "Error writing %s: %v; run sync again\n", server, err)
+	} else if err != nil {
+		exitf(exitNetwork, "Error writing %s: %v\n", server, err)
+	}
+	attached := 0
+	for _, elt := range client.Profiles {
+		if len(elt.Attachments) > 0 {
+			attached++
+		}
+	}
+	if attached > 0 {
+		infof("attachments are not synced over ssh; %d profiles keep theirs only on the devices that have them", attached)
+	}
+	recordSync(rec)
+	return client
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// storeLock holds the advisory lock on the profile store, if taken.
+var storeLock *os.File
+
+// lockStore takes an exclusive advisory lock on the profile store for the
+// rest of the process lifetime, so concurrent read-modify-write commands
+// cannot interleave. The lock is released by the OS when the process exits.
+func lockStore() {
+	if storeLock != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		failf("Error creating directory for %s: %v\n", filename, err)
+	}
+	f, err := os.OpenFile(filename+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		failf("Error opening lock file for %s: %v\n", filename, err)
+	}
+	if err := lockFile(f); err != nil {
+		failf("Error locking %s: %v\n", filename, err)
+	}
+	storeLock = f
+}
+
+// unlockStore releases the advisory lock taken by lockStore, for
+// long-running commands that touch the store repeatedly.
+func unlockStore() {
+	if storeLock == nil {
+		return
+	}
+	unlockFile(storeLock)
+	storeLock.Close()
+	storeLock = nil
+}
+
+// Store holds the client record. The default is a single JSON file;
+// it can also be a directory of files, one per profile, and builds
+// with the sqlite tag can keep it in a SQLite database.
+type Store interface {
+	// Read loads the client record, returning an error that satisfies
+	// os.IsNotExist if there is none.
+	Read() (*Client, error)
+
+	// Write replaces the client record.
+	Write(client *Client) error
+
+	// Export returns the record in the JSON store format, for backups.
+	Export() ([]byte, error)
+
+	// Import replaces the record with one in the JSON store format.
+	Import(raw []byte) error
+}
+
+// A matchReader can read just the profiles that may match a query.
+// The client it returns holds only those (and is marked partial), so
+// it must not be saved.
+type matchReader interface {
+	ReadMatches(q *Query) (*Client, error)
+}
+
+// Storage backends for the backend setting and init -backend.
+const (
+	backendJSON   = "json"
+	backendSQLite = "sqlite"
+)
+
+// sqliteHeader starts every SQLite database file.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// newSQLiteStore opens a SQLite store. It is nil unless letmein was
+// built with the sqlite tag.
+var newSQLiteStore func(path string) (Store, error)
+
+// openStore opens the store at filename. An existing store is opened
+// with the backend that wrote it; a new one uses the backend setting.
+func openStore() Store {
+	backend, what := config.Backend, "The backend setting"
+	if isDirStore(filename) {
+		backend = backendDir
+	} else if fp, err := os.Open(filename); err == nil {
+		header := make([]byte, len(sqliteHeader))
+		n, _ := io.ReadFull(fp, header)
+		fp.Close()
+		backend, what = backendJSON, filename
+		if bytes.Equal(header[:n], sqliteHeader) {
+			backend = backendSQLite
+		}
+	}
+	switch backend {
+	case backendDir:
+		return dirStore{path: filename}
+	case backendJSON:
+		return jsonStore{path: filename}
+	}
+	if newSQLiteStore == nil {
+		failf("%s selects SQLite, but this letmein was built without it; rebuild with -tags sqlite\n", what)
+	}
+	store, err := newSQLiteStore(filename)
+	if err != nil {
+		failf("Error opening %s: %v\n", filename, err)
+	}
+	return store
+}
+
+// jsonStore keeps the client record in one JSON file, rewritten whole
+// on every change.
+type jsonStore struct {
+	path string
+}
+
+func (s jsonStore) Read() (*Client, error) {
+	raw, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	client, err := decodeClient(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", s.path, err)
+	}
+	debugf("read %s (%d bytes)", s.path, len(raw))
+	return client, nil
+}
+
+func (s jsonStore) Write(client *Client) error {
+	raw, err := json.MarshalIndent(client, "", "    ")
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+	if err := s.Import(raw); err != nil {
+		return err
+	}
+	writeIndex(s.path, raw, client)
+	return nil
+}
+
+// ReadMatches uses the index if the store has a current one.
+func (s jsonStore) ReadMatches(q *Query) (*Client, error) {
+	client, err := readIndexed(s.path, q)
+	if client == nil && err == nil {
+		return s.Read()
+	}
+	return client, err
+}
+
+// Export returns the file as it is, so even a damaged store is backed up.
+func (s jsonStore) Export() ([]byte, error) {
+	return ioutil.ReadFile(s.path)
+}
+
+func (s jsonStore) Import(raw []byte) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, raw, 0600); err != nil {
+		return err
+	}
+	if err := os.Remove(indexFile(s.path)); err != nil && !os.IsNotExist(err) {
+		debugf("removing %s: %v", indexFile(s.path), err)
+	}
+	debugf("wrote %s (%d bytes)", s.path, len(raw))
+	return nil
+}
+
+// readClient reads the profile store without locking it or checking the
+// master password, for read-only callers that report their own errors.
+func readClient() (*Client, error) {
+	checkStorePermissions()
+	client, err := openStore().Read()
+	if err == nil {
+		snapshotIntegrity(client)
+	}
+	return client, err
+}
+
+// readMatches is readClient for callers that only look at the
+// profiles matching q, which it may read alone if the store allows.
+// With a nil query it may read no profiles at all.
+func readMatches(q *Query) (*Client, error) {
+	checkStorePermissions()
+	store := openStore()
+	if m, ok := store.(matchReader); ok {
+		return m.ReadMatches(q)
+	}
+	return store.Read()
+}
+
+// saveClient writes the client record to the profile store.
+func saveClient(client *Client) {
+	if readOnly {
+		exitf(exitReadOnly, "Refusing to write %s in read-only mode\n", filename)
+	}
+	if client.partial {
+		panic("saveClient called with a partially loaded store")
+	}
+	client.Version = storeVersion
+	sealIntegrity(client)
+	backupStore()
+	if err := openStore().Write(client); err != nil {
+		failf("Error writing %s: %v\n", filename, err)
+	}
+	debugf("saved %d profiles", len(client.Profiles))
+	commitJournal()
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory
+// and renames it over the target, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(name)
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(name)
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(name)
+		return err
+	}
+	if err = os.Chmod(name, perm); err != nil {
+		os.Remove(name)
+		return err
+	}
+	if err = os.Rename(name, path); err != nil {
+		os.Remove(name)
+		return fmt.Errorf("renaming temporary file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	_ "modernc.org/sqlite"
+)
+
+// The SQLite store keeps one row per profile, so saving rewrites only
+// the profiles that changed, and the database journals every write.
+// The client record without its profiles is kept as JSON in one row.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS client (
+	id   INTEGER PRIMARY KEY CHECK (id = 1),
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS profiles (
+	uuid    TEXT PRIMARY KEY,
+	name    TEXT NOT NULL,
+	url     TEXT NOT NULL,
+	folder  TEXT NOT NULL,
+	deleted INTEGER NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS profiles_name ON profiles (name);
+CREATE INDEX IF NOT EXISTS profiles_url ON profiles (url);
+`
+
+func init() {
+	newSQLiteStore = func(path string) (Store, error) {
+		return sqliteStore{path: path}, nil
+	}
+}
+
+type sqliteStore struct {
+	path string
+}
+
+// open opens the database, creating it only if create is set, since
+// sql.Open would otherwise leave an empty file behind.
+func (s sqliteStore) open(create bool) (*sql.DB, error) {
+	if _, err := os.Stat(s.path); err != nil && (!create || !os.IsNotExist(err)) {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", s.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{"PRAGMA journal_mode = WAL", "PRAGMA busy_timeout = 5000", sqliteSchema} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := os.Chmod(s.path, 0600); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Read reassembles the JSON store format, so older formats are
+// upgraded by decodeClient as usual.
+func (s sqliteStore) Read() (*Client, error) {
+	db, err := s.open(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var data string
+	if err := db.QueryRow(`SELECT data FROM client WHERE id = 1`).Scan(&data); err == sql.ErrNoRows {
+		return nil, &os.PathError{Op: "read", Path: s.path, Err: os.ErrNotExist}
+	} else if err != nil {
+		return nil, err
+	}
+	var store map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &store); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", s.path, err)
+	}
+
+	rows, err := db.Query(`SELECT data FROM profiles ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	profiles := []json.RawMessage{}
+	for rows.Next() {
+		var elt string
+		if err := rows.Scan(&elt); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, json.RawMessage(elt))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if store["profiles"], err = json.Marshal(profiles); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(store)
+	if err != nil {
+		return nil, err
+	}
+	client, err := decodeClient(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", s.path, err)
+	}
+	debugf("read %s (%d profiles)", s.path, len(profiles))
+	return client, nil
+}
+
+// Write updates the changed profiles and removes those that are gone,
+// all in one transaction.
+func (s sqliteStore) Write(client *Client) error {
+	db, err := s.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	header := *client
+	header.Profiles = nil
+	data, err := json.Marshal(&header)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO client (id, data) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data`, string(data)); err != nil {
+		return err
+	}
+
+	// drop the profiles that are no longer in the record
+	if _, err := tx.Exec(`CREATE TEMP TABLE keep (uuid TEXT PRIMARY KEY)`); err != nil {
+		return err
+	}
+	changed := 0
+	for _, p := range client.Profiles {
+		raw, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(`INSERT INTO profiles (uuid, name, url, folder, deleted, data) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (uuid) DO UPDATE SET name = excluded.name, url = excluded.url,
+				folder = excluded.folder, deleted = excluded.deleted, data = excluded.data
+			WHERE data != excluded.data`,
+			p.UUID, p.Name, p.URL, p.Folder, p.IsDeleted(), string(raw))
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			changed++
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO keep (uuid) VALUES (?)`, p.UUID); err != nil {
+			return err
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM profiles WHERE uuid NOT IN (SELECT uuid FROM keep)`)
+	if err != nil {
+		return err
+	}
+	removed, _ := result.RowsAffected()
+	if _, err := tx.Exec(`DROP TABLE keep`); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	debugf("wrote %s (%d profiles changed, %d removed)", s.path, changed, removed)
+	return nil
+}
+
+func (s sqliteStore) Export() ([]byte, error) {
+	client, err := s.Read()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.MarshalIndent(client, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(raw, '\n'), nil
+}
+
+func (s sqliteStore) Import(raw []byte) error {
+	client, err := decodeClient(raw)
+	if err != nil {
+		return err
+	}
+	return s.Write(client)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func init() {
+	registerScheme(schemeStored, func(string) (Scheme, error) {
+		return storedScheme{}, nil
+	})
+}
+
+// storedScheme decrypts a password saved with the profile instead of
+// deriving one.
+type storedScheme struct{}
+
+func (storedScheme) Password(p *Profile, master []byte) ([]byte, error) {
+	key := storedKey(master, p)
+	if p.Vault != "" {
+		key = sharedKey(p)
+	}
+	plain, err := openSecret(key, p.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting stored password: %v", err)
+	}
+	return plain, nil
+}
+
+func (storedScheme) Key(p *Profile, master []byte, purpose string, n int) ([]byte, error) {
+	return nil, errors.New("stored-password profiles cannot derive keys")
+}
+
+// derivationFlags are the profile flags that only make sense for
+// generated passwords.
+var derivationFlags = map[string]bool{
+	"generation":  true,
+	"length":      true,
+	"lower":       true,
+	"upper":       true,
+	"digits":      true,
+	"punctuation": true,
+	"spaces":      true,
+	"latin1":      true,
+	"include":     true,
+	"exclude":     true,
+	"pin":         true,
+}
+
+// getSecret returns the password to store, prompting for it if necessary.
+func getSecret(secret string) string {
+	if secret != "" {
+		return secret
+	}
+	fmt.Printf("Password to store: ")
+	secret = readMasked()
+	if secret == "" {
+		failf("a password to store is required\n")
+	}
+	fmt.Printf("Repeat password: ")
+	if readMasked() != secret {
+		failf("passwords do not match\n")
+	}
+	return secret
+}
+
+// setStoredSecret makes p a stored-password profile holding secret.
+func setStoredSecret(p *Profile, master, secret string) {
+	if len(secret) < minLength || len(secret) > maxStoredLength {
+		failf("stored password must be between %d and %d characters\n", minLength, maxStoredLength)
+	}
+	p.Scheme = schemeStored
+	if p.Vault != "" {
+		p.Secret = sealSecret(sharedKey(p), []byte(secret))
+	} else {
+		password := []byte(master)
+		p.Secret = sealSecret(storedKey(password, p), []byte(secret))
+		wipe(password)
+	}
+	p.Length = len(secret)
+}
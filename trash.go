@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const defaultTrashDays = 30
+
+// TrashEntry keeps an encrypted copy of a deleted profile, since the
+// profile itself is reduced to a tombstone.
+type TrashEntry struct {
+	UUID      string    `json:"uuid"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Sealed    string    `json:"sealed"`
+}
+
+func trashFilename() string {
+	return filename + ".trash"
+}
+
+func trashKey(master, account string) []byte {
+	return secretKey(master, "trash\t"+account)
+}
+
+func loadTrash() []*TrashEntry {
+	raw, err := ioutil.ReadFile(trashFilename())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		failf("Error reading %s: %v\n", trashFilename(), err)
+	}
+	var trash []*TrashEntry
+	if err := json.Unmarshal(raw, &trash); err != nil {
+		failf("Error parsing %s: %v\n", trashFilename(), err)
+	}
+	return trash
+}
+
+// saveTrash writes the trash, dropping entries older than trash_days.
+func saveTrash(trash []*TrashEntry, now time.Time) {
+	cutoff := now.AddDate(0, 0, -config.TrashDays)
+	kept := []*TrashEntry{}
+	for _, elt := range trash {
+		if elt.DeletedAt.After(cutoff) {
+			kept = append(kept, elt)
+		}
+	}
+	writeTrash(kept)
+}
+
+func writeTrash(trash []*TrashEntry) {
+	raw, err := json.MarshalIndent(trash, "", "    ")
+	if err != nil {
+		failf("Error encoding %s: %v\n", trashFilename(), err)
+	}
+	raw = append(raw, '\n')
+	if err := writeFileAtomic(trashFilename(), raw, 0600); err != nil {
+		failf("Error writing %s: %v\n", trashFilename(), err)
+	}
+}
+
+// trashProfile saves a copy of a profile that is about to be deleted.
+func trashProfile(master, account string, p *Profile, now time.Time) {
+	if config.TrashDays == 0 || readOnly {
+		return
+	}
+	raw, err := json.Marshal(p)
+	if err != nil {
+		failf("Error encoding profile for the trash: %v\n", err)
+	}
+	entry := &TrashEntry{UUID: p.UUID, DeletedAt: now, Sealed: sealSecret(trashKey(master, account), raw)}
+	saveTrash(append(loadTrash(), entry), now)
+}
+
+func (e *TrashEntry) open(master, account string) (*Profile, error) {
+	raw, err := openSecret(trashKey(master, account), e.Sealed)
+	if err != nil {
+		return nil, err
+	}
+	p := new(Profile)
+	if err := json.Unmarshal(raw, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func trashCommand() *Client {
+	now := time.Now().Round(time.Millisecond)
+	if len(os.Args) < 2 || (os.Args[1] != "list" && os.Args[1] != "restore") {
+		exitf(exitUsage, "Usage: letmein trash list | letmein trash restore <uuid>\n")
+	}
+	sub := os.Args[1]
+	os.Args = os.Args[1:]
+
+	// gather options
+	var master string
+	registerMasterFlag(&master)
+	registerVaultFlag()
+	flag.Parse()
+	args := flag.Args()
+	if (sub == "list" && len(args) != 0) || (sub == "restore" && len(args) != 1) {
+		exitf(exitUsage, "Usage: letmein trash list | letmein trash restore <uuid>\n")
+	}
+	master = getAndVerifyMaster(master)
+	client := getClient(now, master)
+	trash := loadTrash()
+
+	if sub == "list" {
+		for _, elt := range trash {
+			p, err := elt.open(master, client.Name)
+			if err != nil {
+				fmt.Printf("    %s  (cannot decrypt: %v)\n", elt.UUID, err)
+				continue
+			}
+			fmt.Printf("    %s  deleted %s  %s\n", elt.UUID, elt.DeletedAt.Local().Format(time.Stamp), p)
+		}
+		return nil
+	}
+
+	// restore the most recently trashed copy
+	var entry *TrashEntry
+	index := -1
+	for i, elt := range trash {
+		if elt.UUID == args[0] {
+			entry, index = elt, i
+		}
+	}
+	if entry == nil {
+		failf("No profile with uuid %s in the trash\n", args[0])
+	}
+	p, err := entry.open(master, client.Name)
+	if err != nil {
+		failf("Error decrypting trashed profile: %v\n", err)
+	}
+	if matches := client.Matches(p.Name); len(matches) != 0 {
+		failf("Cannot restore %s: it matches existing profile %s\n", p.Name, matches[0].Name)
+	}
+	p.DeletedAt = nil
+	p.ModifiedAt = &now
+	p.FieldTimes = nil
+	if err := p.Validate(); err != nil {
+		failf("trashed profile is invalid, canceling: %v\n", err)
+	}
+
+	// replace the tombstone, if it has not been collected yet
+	replaced := false
+	for i, elt := range client.Profiles {
+		if elt.UUID == p.UUID {
+			if !elt.IsDeleted() {
+				failf("Profile %s was not deleted\n", p.UUID)
+			}
+			before := *elt
+			recordOp("restore", []*Profile{&before}, nil)
+			client.Profiles[i] = p
+			replaced = true
+		}
+	}
+	if !replaced {
+		client.Profiles = append(client.Profiles, p)
+		recordOp("restore", nil, []string{p.UUID})
+	}
+	saveTrash(append(trash[:index:index], trash[index+1:]...), now)
+	fmt.Printf("profile restored: %s\n", p)
+
+	return client
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Usage records how often and how recently a profile's password was
+// used on this device. It is kept in a local file and never synced.
+type Usage struct {
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+func usageFilename() string {
+	return filename + ".usage"
+}
+
+// loadUsage reads the usage records, keyed by profile UUID. Usage is
+// only a convenience, so a missing or damaged file is treated as empty.
+func loadUsage() map[string]*Usage {
+	usage := make(map[string]*Usage)
+	raw, err := ioutil.ReadFile(usageFilename())
+	if err != nil {
+		return usage
+	}
+	if err := json.Unmarshal(raw, &usage); err != nil {
+		infof("warning: ignoring damaged %s: %v", usageFilename(), err)
+		return make(map[string]*Usage)
+	}
+	return usage
+}
+
+// recordUse notes that a profile's password was just shown, copied, or
+// typed.
+func recordUse(p *Profile) {
+	if readOnly {
+		return
+	}
+	usage := loadUsage()
+	u := usage[p.UUID]
+	if u == nil {
+		u = new(Usage)
+		usage[p.UUID] = u
+	}
+	u.Count++
+	u.LastUsed = time.Now().Round(time.Second)
+	raw, err := json.MarshalIndent(usage, "", "    ")
+	if err != nil {
+		failf("Error encoding %s: %v\n", usageFilename(), err)
+	}
+	raw = append(raw, '\n')
+	if err := writeFileAtomic(usageFilename(), raw, 0600); err != nil {
+		infof("warning: could not record use of %s: %v", p.Name, err)
+	}
+}
+
+// sortRecent orders profiles by when they were last used on this
+// device, dropping any that never were.
+func sortRecent(profiles []*Profile, usage map[string]*Usage) []*Profile {
+	out := []*Profile{}
+	for _, elt := range profiles {
+		if usage[elt.UUID] != nil {
+			out = append(out, elt)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return usage[out[i].UUID].LastUsed.After(usage[out[j].UUID].LastUsed)
+	})
+	return out
+}
+
+// sortForPicker puts favorites first, then the most used profiles,
+// then the rest by name, so pickers show the daily ones at the top.
+func sortForPicker(profiles []*Profile, usage map[string]*Usage) {
+	count := func(p *Profile) int {
+		if u := usage[p.UUID]; u != nil {
+			return u.Count
+		}
+		return 0
+	}
+	sort.SliceStable(profiles, func(i, j int) bool {
+		a, b := profiles[i], profiles[j]
+		switch {
+		case a.Favorite != b.Favorite:
+			return a.Favorite
+		case count(a) != count(b):
+			return count(a) > count(b)
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// letmein vectors prints known answers for every password scheme, so
+// other implementations can check themselves against this one, and
+// with -verify checks that this build still gives them. A change that
+// breaks a vector changes passwords people already use.
+//
+// The LessPass and Spectre vectors come from those projects' own
+// published examples, so they also check letmein against the originals.
+
+// testVector is one known answer. Words is set for a BIP39 mnemonic
+// instead of a password.
+type testVector struct {
+	Comment  string   `json:"comment"`
+	Profile  *Profile `json:"profile"`
+	Master   string   `json:"master"`
+	Words    int      `json:"words,omitempty"`
+	Password string   `json:"password"`
+}
+
+const vectorMaster = "correct horse battery staple"
+
+var testVectors = []*testVector{
+	{
+		Comment:  "scrypt, default character set",
+		Profile:  &Profile{Scheme: schemeScrypt, UUID: "00000000-0000-4000-8000-000000000001", Name: "github", Username: "bob@example.com", URL: "github.com", Length: 16, Lower: true, Upper: true, Digits: true, Punctuation: true},
+		Master:   vectorMaster,
+		Password: "tM<jyw5$4U\"qO?S0",
+	},
+	{
+		Comment:  "scrypt, next generation",
+		Profile:  &Profile{Scheme: schemeScrypt, UUID: "00000000-0000-4000-8000-000000000002", Name: "github", Username: "bob@example.com", URL: "github.com", Generation: 1, Length: 16, Lower: true, Upper: true, Digits: true, Punctuation: true},
+		Master:   vectorMaster,
+		Password: "P*g7S1z-U]C]3%g,",
+	},
+	{
+		Comment:  "scrypt, letters and digits only",
+		Profile:  &Profile{Scheme: schemeScrypt, UUID: "00000000-0000-4000-8000-000000000003", Name: "bank", Username: "bob", URL: "bank.example.com", Length: 24, Lower: true, Upper: true, Digits: true},
+		Master:   vectorMaster,
+		Password: "JnmG9cUBSv4PckXcWPRTevEr",
+	},
+	{
+		Comment:  "scrypt, numeric PIN",
+		Profile:  &Profile{Scheme: schemeScrypt, UUID: "00000000-0000-4000-8000-000000000004", Name: "phone", Length: 6, Digits: true},
+		Master:   vectorMaster,
+		Password: "212482",
+	},
+	{
+		Comment:  "scrypt, included and excluded characters",
+		Profile:  &Profile{Scheme: schemeScrypt, UUID: "00000000-0000-4000-8000-000000000005", Name: "router", URL: "192.168.1.1", Username: "admin", Length: 12, Lower: true, Digits: true, Include: "!@#", Exclude: "0o1l"},
+		Master:   vectorMaster,
+		Password: "h3i87t3r#y2x",
+	},
+	{
+		Comment:  "scrypt, spaces and Latin-1",
+		Profile:  &Profile{Scheme: schemeScrypt, UUID: "00000000-0000-4000-8000-000000000006", Name: "wiki", Username: "bob", URL: "wiki.example.org", Length: 20, Lower: true, Upper: true, Digits: true, Punctuation: true, Spaces: true, Latin1: true},
+		Master:   vectorMaster,
+		Password: "@pQ¿:bU)~Li7S)°Q\"=¿{",
+	},
+	{
+		Comment:  "scrypt, non-ASCII master password",
+		Profile:  &Profile{Scheme: schemeScrypt, UUID: "00000000-0000-4000-8000-000000000007", Name: "mail", Username: "bob@example.com", URL: "mail.example.com", Length: 16, Lower: true, Upper: true, Digits: true, Punctuation: true},
+		Master:   "pässwörd ✓ 密码",
+		Password: "B\\OfWwt$K&lpyvOu",
+	},
+	{
+		Comment:  "scrypt, higher cost",
+		Profile:  &Profile{Scheme: scryptScheme(32768, 8, 2), UUID: "00000000-0000-4000-8000-000000000008", Name: "github", Username: "bob@example.com", URL: "github.com", Length: 16, Lower: true, Upper: true, Digits: true, Punctuation: true},
+		Master:   vectorMaster,
+		Password: "!@CnBA1B)@PP7<gy",
+	},
+	{
+		Comment:  "BIP39 mnemonic, 12 words",
+		Profile:  &Profile{Scheme: schemeScrypt, UUID: "00000000-0000-4000-8000-000000000009", Name: "wallet", Length: 16, Lower: true, Upper: true, Digits: true, Punctuation: true},
+		Master:   vectorMaster,
+		Words:    12,
+		Password: "offer spider connect midnight hurdle laptop typical have ghost attract victory flip",
+	},
+	{
+		Comment:  "LessPass, from the LessPass tests",
+		Profile:  &Profile{Scheme: schemeLessPass, UUID: "00000000-0000-4000-8000-00000000000a", Name: "example.org", Username: "contact@example.org", URL: "example.org", Generation: 1, Length: 16, Lower: true, Upper: true, Digits: true, Punctuation: true},
+		Master:   "password",
+		Password: "WHLpUL)e00[iHR+w",
+	},
+	{
+		Comment:  "Spectre, from the Spectre tests",
+		Profile:  &Profile{Scheme: schemeSpectre + "(long,Robert Lee Mitchell)", UUID: "00000000-0000-4000-8000-00000000000b", Name: "masterpasswordapp.com", URL: "masterpasswordapp.com", Generation: 1, Length: 14, Lower: true, Upper: true, Digits: true, Punctuation: true},
+		Master:   "banana colored duckling",
+		Password: "Jejr5[RepuSosp",
+	},
+	{
+		Comment:  "scrypt-v2, default character set",
+		Profile:  &Profile{Scheme: scryptV2Scheme(scryptN, scryptR, scryptP), UUID: "00000000-0000-4000-8000-00000000000c", Name: "github", Username: "bob@example.com", URL: "github.com", Length: 16, Lower: true, Upper: true, Digits: true, Punctuation: true},
+		Master:   vectorMaster,
+		Password: "ehwx&@1?kJlg.\\=q",
+	},
+	{
+		Comment:  "scrypt-v2, numeric PIN",
+		Profile:  &Profile{Scheme: scryptV2Scheme(scryptN, scryptR, scryptP), UUID: "00000000-0000-4000-8000-00000000000d", Name: "phone", Length: 6, Digits: true},
+		Master:   vectorMaster,
+		Password: "280905",
+	},
+	{
+		Comment:  "scrypt-v3, default character set",
+		Profile:  &Profile{Scheme: scryptV3Scheme(scryptN, scryptR, scryptP), UUID: "00000000-0000-4000-8000-00000000000f", Name: "github", Username: "bob@example.com", URL: "github.com", Length: 16, Lower: true, Upper: true, Digits: true, Punctuation: true},
+		Master:   vectorMaster,
+		Password: ">zjDzga_PvrkO^Q)",
+	},
+	{
+		Comment:  "scrypt-v3, 32 characters",
+		Profile:  &Profile{Scheme: scryptV3Scheme(scryptN, scryptR, scryptP), UUID: "00000000-0000-4000-8000-00000000000e", Name: "bank", Username: "bob", URL: "bank.example.com", Length: 32, Lower: true, Upper: true, Digits: true, Punctuation: true},
+		Master:   vectorMaster,
+		Password: "]OWYNWKNfyTl)egy|ZQ@mbNECa1{/*1o",
+	},
+}
+
+// answer works out what this build gives for a vector.
+func (v *testVector) answer() (string, error) {
+	p := *v.Profile
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	if v.Words > 0 {
+		return p.Mnemonic(v.Master, v.Words), nil
+	}
+	return p.generate(v.Master)
+}
+
+func vectorsCommand() {
+	// gather options
+	verify := false
+	flag.BoolVar(&verify, "verify", verify, "Check that this build reproduces every vector")
+	current := false
+	flag.BoolVar(&current, "current", current, "Print what this build gives instead of the known answers")
+	flag.Parse()
+
+	if !verify {
+		out := testVectors
+		if current {
+			out = nil
+			for _, v := range testVectors {
+				elt := *v
+				password, err := v.answer()
+				if err != nil {
+					failf("%s: %v\n", v.Comment, err)
+				}
+				elt.Password = password
+				out = append(out, &elt)
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(out); err != nil {
+			failf("Error encoding vectors: %v\n", err)
+		}
+		return
+	}
+
+	failed := 0
+	for _, v := range testVectors {
+		password, err := v.answer()
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "FAIL %s: %v\n", v.Comment, err)
+			failed++
+		case password != v.Password:
+			fmt.Fprintf(os.Stderr, "FAIL %s: got %q, want %q\n", v.Comment, password, v.Password)
+			failed++
+		default:
+			infof("ok %s", v.Comment)
+		}
+	}
+	if failed > 0 {
+		failf("%d of %d test vectors failed\n", failed, len(testVectors))
+	}
+	fmt.Printf("all %d test vectors pass\n", len(testVectors))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultVerifyLength = 16
+	maxVerifyLength     = 32
+	defaultVerifyChars  = "alnum"
+)
+
+// verifyCharsets are the character sets a verification code can use.
+// Shorter codes and smaller sets leak less about the master password
+// but let more typos through.
+var verifyCharsets = map[string]*Profile{
+	"digits": {Digits: true},
+	"lower":  {Lower: true},
+	"alnum":  {Lower: true, Upper: true, Digits: true},
+	"all":    {Lower: true, Upper: true, Digits: true, Punctuation: true},
+}
+
+func verifyCharsetNames() string {
+	var names []string
+	for name := range verifyCharsets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Verifier checks the master password without storing anything that is
+// cheaper to attack than the profiles themselves. The old Verify code
+// was derived with an empty URL and username, so the same four letters
+// identified a master password in every vault; the verifier mixes in a
+// random per-client salt and uses the current scrypt cost parameters.
+type Verifier struct {
+	Scheme string `json:"scheme"`
+	Salt   string `json:"salt"`
+	Length int    `json:"length"`
+	Chars  string `json:"chars,omitempty"`
+	Code   string `json:"code"`
+}
+
+// newVerifier makes a verifier with a code of the given length drawn
+// from one of the verifyCharsets.
+func newVerifier(master string, length int, chars string) (*Verifier, error) {
+	if length < 1 || length > maxVerifyLength {
+		return nil, fmt.Errorf("verification code length must be between 1 and %d", maxVerifyLength)
+	}
+	if verifyCharsets[chars] == nil {
+		return nil, fmt.Errorf("unknown verification character set %q: choose from %s", chars, verifyCharsetNames())
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		panic(fmt.Sprintf("error generating verifier salt: %v", err))
+	}
+	v := &Verifier{
+		Scheme: defaultScheme(),
+		Salt:   base64.RawStdEncoding.EncodeToString(salt),
+		Length: length,
+		Chars:  chars,
+	}
+	v.Code = v.profile().Generate(master)
+	return v, nil
+}
+
+// profile is the pseudo-profile the verification code is derived from.
+func (v *Verifier) profile() *Profile {
+	chars := v.Chars
+	if chars == "" {
+		chars = defaultVerifyChars
+	}
+	set := verifyCharsets[chars]
+	if set == nil {
+		failf("Unknown verification character set %q in %s\n", v.Chars, filename)
+	}
+	return &Profile{
+		Scheme:      v.Scheme,
+		Username:    "verify",
+		URL:         "salt:" + v.Salt,
+		Length:      v.Length,
+		Lower:       set.Lower,
+		Upper:       set.Upper,
+		Digits:      set.Digits,
+		Punctuation: set.Punctuation,
+	}
+}
+
+// Check reports whether master produces the stored code.
+func (v *Verifier) Check(master string) bool {
+	code := v.profile().Generate(master)
+	return subtle.ConstantTimeCompare([]byte(code), []byte(v.Code)) == 1
+}
+
+var errMasterMismatch = errors.New("master password verification mismatch")
+
+// checkMaster verifies master against the client's verifier, falling back
+// to the legacy Verify code for vaults that have not been migrated yet.
+func (c *Client) checkMaster(master string) error {
+	switch {
+	case c.NoVerify:
+	case c.Verifier != nil:
+		if !c.Verifier.Check(master) {
+			return errMasterMismatch
+		}
+	case c.Verify != "":
+		if c.Verify != VerifyProfile.Generate(master) {
+			return errMasterMismatch
+		}
+	}
+	return nil
+}
+
+// upgradeVerifier replaces a legacy Verify code with a salted verifier.
+// It must only be called after checkMaster succeeds, and reports
+// whether the client changed.
+func (c *Client) upgradeVerifier(master string) bool {
+	if c.Verifier != nil || c.NoVerify {
+		return false
+	}
+	v, err := newVerifier(master, defaultVerifyLength, defaultVerifyChars)
+	if err != nil {
+		panic(err.Error())
+	}
+	c.Verifier = v
+	c.Verify = ""
+	return true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// askString prompts for a value, returning def for a blank answer.
+func askString(prompt, def string) string {
+	if def != "" {
+		prompt += " [" + def + "]"
+	}
+	if s := readLine(prompt + ": "); s != "" {
+		return s
+	}
+	return def
+}
+
+// askBool prompts for a yes/no answer.
+func askBool(prompt string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		switch strings.ToLower(readLine(prompt + " [" + hint + "]: ")) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Printf("Please answer y or n\n")
+	}
+}
+
+// askInt prompts for a number in a range.
+func askInt(prompt string, def, min, max int) int {
+	for {
+		s := readLine(fmt.Sprintf("%s [%d]: ", prompt, def))
+		if s == "" {
+			return def
+		}
+		n, err := strconv.Atoi(s)
+		if err == nil && n >= min && n <= max {
+			return n
+		}
+		fmt.Printf("Please enter a number between %d and %d\n", min, max)
+	}
+}
+
+// createWizard fills in a new profile by asking for each setting, using
+// the current values (from flags, a template, or the defaults) as the
+// suggested answers. It previews the password and fails if the user
+// does not accept it.
+func createWizard(client *Client, p *Profile, master string) {
+	if !interactive() {
+		failf("Cannot run the create wizard without a terminal\n")
+	}
+	for {
+		p.Name = askString("Profile name", p.Name)
+		if strings.TrimSpace(p.Name) == "" {
+			fmt.Printf("A name is required\n")
+		} else if matches := client.Matches(p.Name); len(matches) != 0 {
+			fmt.Printf("That name matches existing profile %s\n", matches[0].Name)
+		} else {
+			break
+		}
+		p.Name = ""
+	}
+	p.URL = askString("Website URL", p.URL)
+	p.Username = askString("Username or email", p.Username)
+	for {
+		p.Length = askInt("Length", p.Length, minLength, config.MaxLength)
+		p.Lower = askBool("Lower-case letters?", p.Lower)
+		p.Upper = askBool("Upper-case letters?", p.Upper)
+		p.Digits = askBool("Digits?", p.Digits)
+		p.Punctuation = askBool("Punctuation?", p.Punctuation)
+		p.Spaces = askBool("Spaces?", p.Spaces)
+
+		// preview using a copy, since Validate normalizes fields
+		preview := *p
+		preview.Scheme = defaultScheme()
+		if err := preview.Validate(); err != nil {
+			fmt.Printf("Those settings do not work: %v\n", err)
+			continue
+		}
+		fmt.Printf("\n    %s --> %s\n\n", &preview, maskPassword(preview.Generate(master)))
+		break
+	}
+	if !askBool("Save this profile?", true) {
+		failf("Canceled\n")
+	}
+}